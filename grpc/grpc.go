@@ -0,0 +1,543 @@
+package grpc
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	serviceName = "resgate.Transport"
+	connectName = "Connect"
+)
+
+// ErrNoService is the error passed to the Response when no connected
+// service has registered a pattern matching the request subject.
+var ErrNoService = reserr.ErrServiceUnavailable
+
+// Client is an mq.Client implementation serving the gRPC transport, which
+// services connect to. Requests are routed to services by the resource
+// patterns they register, and events sent by the services are passed to
+// the subscribers.
+type Client struct {
+	// Address to listen on for service connections. Eg. ":4223"
+	Addr           string
+	RequestTimeout time.Duration
+	// Certificate and key file paths for TLS. If not set, TLS is disabled.
+	TLSCert *string
+	TLSKey  *string
+	// Root CA certificate file paths for verifying service certificates. If
+	// set, services must connect with a client certificate signed by one of
+	// the CAs. Requires TLSCert and TLSKey.
+	ClientCAs []string
+	// Shared secret that services must send when registering.
+	// At least one of ClientCAs and Token must be set.
+	Token  *string
+	Logger logger.Logger
+
+	mu           sync.Mutex
+	srv          *grpc.Server
+	lis          net.Listener
+	conns        []*serviceConn
+	next         int
+	reqs         map[uint64]*pending
+	reqID        uint64
+	subs         map[string]map[*Subscription]struct{}
+	queue        []func()
+	work         chan struct{}
+	stopped      chan struct{}
+	closeHandler func(error)
+}
+
+// Subscription implements the mq.Unsubscriber interface.
+type Subscription struct {
+	c  *Client
+	ns string
+	cb mq.Response
+}
+
+// serviceConn is a connected service stream.
+type serviceConn struct {
+	stream   grpc.ServerStream
+	patterns []rescache.ResourcePattern
+	mu       sync.Mutex // Protects sending on the stream
+}
+
+type pending struct {
+	subj string
+	sc   *serviceConn
+	cb   mq.Response
+	t    *time.Timer
+}
+
+// Logf writes a formatted log message
+func (c *Client) Logf(format string, v ...interface{}) {
+	c.Logger.Log(fmt.Sprintf(format, v...))
+}
+
+// Debugf writes a formatted debug message
+func (c *Client) Debugf(format string, v ...interface{}) {
+	if c.Logger.IsDebug() {
+		c.Logger.Debug(fmt.Sprintf(format, v...))
+	}
+}
+
+// Tracef writes a formatted trace message
+func (c *Client) Tracef(format string, v ...interface{}) {
+	if c.Logger.IsTrace() {
+		c.Logger.Trace(fmt.Sprintf(format, v...))
+	}
+}
+
+// Connect starts listening for service connections.
+func (c *Client) Connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.ClientCAs) == 0 && c.Token == nil {
+		return errors.New("grpc: service authentication required, by client CAs or a token")
+	}
+	opts := []grpc.ServerOption{grpc.ForceServerCodec(codec{})}
+	if c.TLSCert != nil || c.TLSKey != nil || len(c.ClientCAs) > 0 {
+		tlsCfg, err := c.tlsConfig()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+
+	lis, err := net.Listen("tcp", c.Addr)
+	if err != nil {
+		return err
+	}
+	c.Logf("Listening for gRPC service connections on %s", lis.Addr())
+
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    connectName,
+			Handler:       c.handleStream,
+			ServerStreams: true,
+			ClientStreams: true,
+		}},
+		Metadata: "transport.proto",
+	}, nil)
+
+	c.srv = srv
+	c.lis = lis
+	c.conns = nil
+	c.reqs = make(map[uint64]*pending)
+	c.subs = make(map[string]map[*Subscription]struct{})
+	c.queue = nil
+	c.work = make(chan struct{}, 1)
+	c.stopped = make(chan struct{})
+
+	go c.listener(c.work, c.stopped)
+	go srv.Serve(lis)
+
+	return nil
+}
+
+// tlsConfig returns the server TLS configuration, requiring verified
+// service certificates if client CAs are set.
+func (c *Client) tlsConfig() (*tls.Config, error) {
+	if c.TLSCert == nil || c.TLSKey == nil {
+		return nil, errors.New("grpc: both certificate and key must be set")
+	}
+	cert, err := tls.LoadX509KeyPair(*c.TLSCert, *c.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(c.ClientCAs) > 0 {
+		pool := x509.NewCertPool()
+		for _, f := range c.ClientCAs {
+			pem, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("grpc: no certificates found in client CA file %s", f)
+			}
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// ListenAddr returns the address listened on for service connections, or
+// nil if the client is not connected.
+func (c *Client) ListenAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lis == nil {
+		return nil
+	}
+	return c.lis.Addr()
+}
+
+// IsClosed tests if the client has been closed.
+func (c *Client) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.srv == nil
+}
+
+// Close stops the server, disconnecting all services.
+func (c *Client) Close() {
+	c.mu.Lock()
+	srv := c.srv
+	if srv == nil {
+		c.mu.Unlock()
+		return
+	}
+	c.srv = nil
+	c.lis = nil
+	for _, p := range c.reqs {
+		p.t.Stop()
+	}
+	c.reqs = nil
+	close(c.work)
+	stopped := c.stopped
+	c.mu.Unlock()
+
+	c.Debugf("Stopping gRPC server...")
+	srv.Stop()
+	c.Debugf("gRPC server stopped")
+
+	<-stopped
+}
+
+// SetClosedHandler sets the handler when the connection is closed.
+// As the client is the server, the handler is never called.
+func (c *Client) SetClosedHandler(cb func(error)) {
+	c.closeHandler = cb
+}
+
+// SendRequest sends a request to a service registered for the subject.
+func (c *Client) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.mu.Lock()
+	if c.srv == nil {
+		c.mu.Unlock()
+		go cb("", nil, mq.ErrRequestTimeout)
+		return
+	}
+	sc := c.route(subj)
+	if sc == nil {
+		c.enqueue(func() {
+			c.Tracef("x=> %s: No service registered", subj)
+			cb("", nil, ErrNoService)
+		})
+		c.mu.Unlock()
+		return
+	}
+	c.reqID++
+	id := c.reqID
+	p := &pending{subj: subj, sc: sc, cb: cb}
+	p.t = c.afterTimeout(id, c.RequestTimeout)
+	c.reqs[id] = p
+	c.Tracef("<== (%d) %s: %s", id, subj, payload)
+	c.mu.Unlock()
+
+	if err := sc.send(&Request{ID: id, Subject: subj, Payload: payload}); err != nil {
+		c.Debugf("Error sending request %s: %s", subj, err)
+		c.respond(id, nil, err)
+	}
+}
+
+// Subscribe to all events on a resource namespace.
+// The namespace has the format "event."+resource
+func (c *Client) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.srv == nil {
+		return nil, errors.New("grpc: client closed")
+	}
+
+	sub := &Subscription{c: c, ns: namespace, cb: cb}
+	m, ok := c.subs[namespace]
+	if !ok {
+		m = make(map[*Subscription]struct{})
+		c.subs[namespace] = m
+	}
+	m[sub] = struct{}{}
+	c.Tracef("S=> %s.*", namespace)
+	return sub, nil
+}
+
+// Unsubscribe removes the subscription.
+func (s *Subscription) Unsubscribe() error {
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+
+	s.c.Tracef("U=> %s.*", s.ns)
+	if m, ok := s.c.subs[s.ns]; ok {
+		delete(m, s)
+		if len(m) == 0 {
+			delete(s.c.subs, s.ns)
+		}
+	}
+	return nil
+}
+
+// route returns a service registered for the request subject, with the
+// format "<type>.<resource>" or, for call and auth requests,
+// "<type>.<resource>.<method>". Client.mu is held when called.
+func (c *Client) route(subj string) *serviceConn {
+	idx := strings.IndexByte(subj, '.')
+	if idx < 0 {
+		return nil
+	}
+	typ, rname := subj[:idx], subj[idx+1:]
+	if typ == "call" || typ == "auth" {
+		// Match on resource name without method, falling back to the full
+		// name to allow patterns on method names.
+		if idx = strings.LastIndexByte(rname, '.'); idx >= 0 {
+			if sc := c.match(rname[:idx]); sc != nil {
+				return sc
+			}
+		}
+	}
+	return c.match(rname)
+}
+
+// match returns a service registered for the resource name, rotating between
+// matching services. Client.mu is held when called.
+func (c *Client) match(rname string) *serviceConn {
+	l := len(c.conns)
+	for i := 0; i < l; i++ {
+		sc := c.conns[(c.next+i)%l]
+		for _, p := range sc.patterns {
+			if p.Match(rname) {
+				c.next = (c.next + i + 1) % l
+				return sc
+			}
+		}
+	}
+	return nil
+}
+
+// handleStream handles a service stream until it is closed. The stream is
+// closed if the service sends an invalid token, or sends responses or
+// events prior to registering.
+func (c *Client) handleStream(_ interface{}, stream grpc.ServerStream) error {
+	sc := &serviceConn{stream: stream}
+	defer c.removeConn(sc)
+
+	registered := false
+	for {
+		var m ServiceMessage
+		if err := stream.RecvMsg(&m); err != nil {
+			return err
+		}
+		if m.Register == nil && !registered {
+			c.Logf("gRPC service rejected: not registered")
+			return status.Error(codes.FailedPrecondition, "service not registered")
+		}
+		switch {
+		case m.Register != nil:
+			if !c.validToken(m.Register.Token) {
+				c.Logf("gRPC service rejected: invalid token")
+				return status.Error(codes.Unauthenticated, "invalid token")
+			}
+			registered = true
+			c.register(sc, m.Register.Patterns)
+		case m.Response != nil:
+			r := m.Response
+			if len(r.Payload) == 0 && r.Timeout > 0 {
+				c.extendTimeout(r.ID, time.Duration(r.Timeout)*time.Millisecond)
+			} else {
+				c.respond(r.ID, r.Payload, nil)
+			}
+		case m.Event != nil:
+			c.event(m.Event.Subject, m.Event.Payload)
+		}
+	}
+}
+
+// validToken reports if the token matches the configured token, or if no
+// token is configured.
+func (c *Client) validToken(token string) bool {
+	if c.Token == nil {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(*c.Token)) == 1
+}
+
+func (c *Client) register(sc *serviceConn, patterns []string) {
+	ps := make([]rescache.ResourcePattern, 0, len(patterns))
+	for _, s := range patterns {
+		p := rescache.ParseResourcePattern(s)
+		if !p.IsValid() {
+			c.Logf("Invalid gRPC service pattern: %s", s)
+			continue
+		}
+		ps = append(ps, p)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Debugf("gRPC service registered: %s", strings.Join(patterns, ", "))
+	sc.patterns = append(sc.patterns, ps...)
+	for _, conn := range c.conns {
+		if conn == sc {
+			return
+		}
+	}
+	c.conns = append(c.conns, sc)
+}
+
+// removeConn removes a disconnected service, failing any requests awaiting
+// a response from it.
+func (c *Client) removeConn(sc *serviceConn) {
+	c.mu.Lock()
+	for i, conn := range c.conns {
+		if conn == sc {
+			c.conns = append(c.conns[:i], c.conns[i+1:]...)
+			c.Debugf("gRPC service disconnected")
+			break
+		}
+	}
+	var ids []uint64
+	for id, p := range c.reqs {
+		if p.sc == sc {
+			ids = append(ids, id)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, id := range ids {
+		c.respond(id, nil, ErrNoService)
+	}
+}
+
+// respond passes a response to the request callback.
+func (c *Client) respond(id uint64, payload []byte, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.reqs[id]
+	if !ok {
+		return
+	}
+	delete(c.reqs, id)
+	p.t.Stop()
+	c.enqueue(func() {
+		c.Tracef("==> (%d): %s", id, payload)
+		if err != nil {
+			p.cb("", nil, err)
+		} else {
+			p.cb(p.subj, payload, nil)
+		}
+	})
+}
+
+// extendTimeout sets a new timeout duration for a request, as requested by
+// a pre-response.
+func (c *Client) extendTimeout(id uint64, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.reqs[id]
+	if !ok {
+		return
+	}
+	if p.t.Stop() {
+		c.Tracef("==> (%d): timeout:\"%d\"", id, d.Milliseconds())
+		p.t = c.afterTimeout(id, d)
+	}
+}
+
+// event passes an event to the subscribers of its namespace.
+func (c *Client) event(subj string, payload []byte) {
+	idx := strings.LastIndexByte(subj, '.')
+	if idx < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for sub := range c.subs[subj[:idx]] {
+		cb := sub.cb
+		c.enqueue(func() {
+			c.Tracef("=>> %s: %s", subj, payload)
+			cb(subj, payload, nil)
+		})
+	}
+}
+
+// afterTimeout starts the timeout timer for a request.
+// Client.mu is held when called.
+func (c *Client) afterTimeout(id uint64, d time.Duration) *time.Timer {
+	return time.AfterFunc(d, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		p, ok := c.reqs[id]
+		if !ok {
+			return
+		}
+		delete(c.reqs, id)
+		c.enqueue(func() {
+			c.Tracef("x=> (%d) Request timeout", id)
+			p.cb("", nil, mq.ErrRequestTimeout)
+		})
+	})
+}
+
+// enqueue queues a callback to be called by the listener. Callbacks queued
+// after the client is closed are dropped. Client.mu is held when called.
+func (c *Client) enqueue(f func()) {
+	if c.srv == nil {
+		return
+	}
+	c.queue = append(c.queue, f)
+	select {
+	case c.work <- struct{}{}:
+	default:
+	}
+}
+
+// listener calls the queued callbacks in order.
+func (c *Client) listener(work chan struct{}, stopped chan struct{}) {
+	defer close(stopped)
+	for range work {
+		for {
+			c.mu.Lock()
+			q := c.queue
+			c.queue = nil
+			c.mu.Unlock()
+			if len(q) == 0 {
+				break
+			}
+			for _, f := range q {
+				f()
+			}
+		}
+	}
+}
+
+func (sc *serviceConn) send(r *Request) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.stream.SendMsg(r)
+}
@@ -0,0 +1,464 @@
+package grpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/server/mq"
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const testTimeout = 5 * time.Second
+
+var testToken = "secret"
+
+// connect starts a client on a random port, calling the configure callback
+// prior to connecting.
+func connect(t *testing.T, configure func(c *Client)) *Client {
+	c := &Client{
+		Addr:           "127.0.0.1:0",
+		RequestTimeout: time.Second,
+		Logger:         logger.NewMemLogger(false, false),
+	}
+	configure(c)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	return c
+}
+
+// dial opens a transport stream to the client.
+func dial(t *testing.T, c *Client) (grpc.ClientStream, func()) {
+	cc, err := grpc.Dial(c.ListenAddr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("error dialing: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	stream, err := cc.NewStream(ctx, &grpc.StreamDesc{
+		StreamName:    connectName,
+		ServerStreams: true,
+		ClientStreams: true,
+	}, "/"+serviceName+"/"+connectName, grpc.ForceCodec(codec{}))
+	if err != nil {
+		cancel()
+		cc.Close()
+		t.Fatalf("error opening stream: %s", err)
+	}
+	return stream, func() {
+		cancel()
+		cc.Close()
+	}
+}
+
+// assertStreamClosed asserts that the stream is closed with the status code.
+func assertStreamClosed(t *testing.T, stream grpc.ClientStream, code codes.Code) {
+	var r Request
+	err := stream.RecvMsg(&r)
+	if status.Code(err) != code {
+		t.Fatalf("expected stream to be closed with code %s, but got: %v", code, err)
+	}
+}
+
+// awaitRegistered waits until a request for the subject is routed to a
+// service, as registration is asynchronous.
+func awaitRegistered(t *testing.T, c *Client, subj string) {
+	deadline := time.Now().Add(testTimeout)
+	for {
+		c.mu.Lock()
+		sc := c.route(subj)
+		c.mu.Unlock()
+		if sc != nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a service registered for %s", subj)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Test that connecting without any service authentication fails
+func TestConnect_WithoutAuthentication_ReturnsError(t *testing.T) {
+	c := &Client{Addr: "127.0.0.1:0", Logger: logger.NewMemLogger(false, false)}
+	if err := c.Connect(); err == nil {
+		c.Close()
+		t.Fatal("expected an error, but got none")
+	}
+}
+
+// Test that a service registering with the token receives requests
+func TestRegister_WithValidToken_ReceivesRequests(t *testing.T) {
+	c := connect(t, func(c *Client) { c.Token = &testToken })
+	defer c.Close()
+	stream, done := dial(t, c)
+	defer done()
+
+	if err := stream.SendMsg(&ServiceMessage{Register: &Register{Patterns: []string{"test.>"}, Token: testToken}}); err != nil {
+		t.Fatalf("error registering: %s", err)
+	}
+	awaitRegistered(t, c, "get.test.model")
+
+	ch := make(chan []byte, 1)
+	c.SendRequest("get.test.model", []byte(`{}`), func(_ string, payload []byte, err error) {
+		ch <- payload
+	})
+	var r Request
+	if err := stream.RecvMsg(&r); err != nil {
+		t.Fatalf("error receiving request: %s", err)
+	}
+	if r.Subject != "get.test.model" {
+		t.Fatalf("expected request subject get.test.model, but got %s", r.Subject)
+	}
+	stream.SendMsg(&ServiceMessage{Response: &Response{ID: r.ID, Payload: []byte(`{"result":null}`)}})
+	select {
+	case payload := <-ch:
+		if string(payload) != `{"result":null}` {
+			t.Fatalf("expected response payload {\"result\":null}, but got %s", payload)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("expected a response, but found none")
+	}
+}
+
+// Test that a service registering with an invalid token is rejected
+func TestRegister_WithInvalidToken_ClosesStream(t *testing.T) {
+	for _, token := range []string{"", "invalid"} {
+		c := connect(t, func(c *Client) { c.Token = &testToken })
+		stream, done := dial(t, c)
+		stream.SendMsg(&ServiceMessage{Register: &Register{Patterns: []string{">"}, Token: token}})
+		assertStreamClosed(t, stream, codes.Unauthenticated)
+		c.mu.Lock()
+		conns := len(c.conns)
+		c.mu.Unlock()
+		if conns != 0 {
+			t.Fatalf("expected no registered services for token %q, but got %d", token, conns)
+		}
+		done()
+		c.Close()
+	}
+}
+
+// Test that events and responses sent prior to registering close the stream
+func TestUnregistered_SendingMessages_ClosesStream(t *testing.T) {
+	tbl := []*ServiceMessage{
+		{Event: &Event{Subject: "event.test.model.change", Payload: []byte(`{"values":{"foo":"bar"}}`)}},
+		{Response: &Response{ID: 1, Payload: []byte(`{"result":null}`)}},
+	}
+
+	for _, m := range tbl {
+		c := connect(t, func(c *Client) { c.Token = &testToken })
+		events := make(chan string, 1)
+		c.Subscribe("event.test.model", func(subj string, _ []byte, _ error) {
+			events <- subj
+		})
+		stream, done := dial(t, c)
+		stream.SendMsg(m)
+		assertStreamClosed(t, stream, codes.FailedPrecondition)
+		done()
+		c.Close()
+		select {
+		case subj := <-events:
+			t.Fatalf("expected no event, but got %s", subj)
+		default:
+		}
+	}
+}
+
+// Test that services must connect with a client certificate signed by the
+// client CAs
+func TestClientCAs_RequiresClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := createCert(t, dir, "ca", nil, nil)
+	createCert(t, dir, "server", ca, caKey)
+	createCert(t, dir, "client", ca, caKey)
+	other, otherKey := createCert(t, dir, "other", nil, nil)
+	createCert(t, dir, "otherclient", other, otherKey)
+
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	c := connect(t, func(c *Client) {
+		c.TLSCert = &certFile
+		c.TLSKey = &keyFile
+		c.ClientCAs = []string{filepath.Join(dir, "ca.pem")}
+	})
+	defer c.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	tbl := []struct {
+		Client string // Client certificate name, or empty for none
+		Valid  bool
+	}{
+		{"client", true},
+		{"otherclient", false},
+		{"", false},
+	}
+
+	for _, l := range tbl {
+		cfg := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+		if l.Client != "" {
+			cert, err := tls.LoadX509KeyPair(filepath.Join(dir, l.Client+".pem"), filepath.Join(dir, l.Client+".key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		err := connectTLS(t, c, cfg)
+		if l.Valid && err != nil {
+			t.Fatalf("expected client %q to connect, but got: %s", l.Client, err)
+		}
+		if !l.Valid && err == nil {
+			t.Fatalf("expected client %q to be rejected", l.Client)
+		}
+	}
+}
+
+// connectTLS connects a service over TLS, returning any error from
+// connecting or awaiting a request. As a rejected client certificate may
+// only be detected after the handshake, the service is considered connected
+// if awaiting a request times out.
+func connectTLS(t *testing.T, c *Client, cfg *tls.Config) error {
+	cc, err := grpc.Dial(c.ListenAddr().String(), grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+	if err != nil {
+		t.Fatalf("error dialing: %s", err)
+	}
+	defer cc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	s, err := ConnectService(ctx, cc, "", "test.>")
+	if err != nil {
+		return err
+	}
+	if _, err = s.Recv(); status.Code(err) == codes.DeadlineExceeded {
+		return nil
+	}
+	return err
+}
+
+// createCert creates a certificate and key, written to <name>.pem and
+// <name>.key in dir. The certificate is a CA if parent is nil, and otherwise
+// signed by the parent.
+func createCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// register registers a service for test.> resources over the stream.
+func register(t *testing.T, c *Client, stream grpc.ClientStream) {
+	if err := stream.SendMsg(&ServiceMessage{Register: &Register{Patterns: []string{"test.>"}, Token: testToken}}); err != nil {
+		t.Fatalf("error registering: %s", err)
+	}
+	awaitRegistered(t, c, "get.test.model")
+}
+
+type response struct {
+	payload []byte
+	err     error
+}
+
+func sendRequest(c *Client, subj string) chan response {
+	ch := make(chan response, 1)
+	c.SendRequest(subj, []byte(`{}`), func(_ string, payload []byte, err error) {
+		ch <- response{payload, err}
+	})
+	return ch
+}
+
+func awaitResponse(t *testing.T, ch chan response) response {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(testTimeout):
+		t.Fatal("expected a response, but found none")
+	}
+	return response{}
+}
+
+// Test that call and auth requests are routed to a service registered for the
+// resource without the method name
+func TestRequest_CallAndAuth_RoutedByResourceName(t *testing.T) {
+	c := connect(t, func(c *Client) { c.Token = &testToken })
+	defer c.Close()
+	stream, done := dial(t, c)
+	defer done()
+
+	if err := stream.SendMsg(&ServiceMessage{Register: &Register{Patterns: []string{"test.model"}, Token: testToken}}); err != nil {
+		t.Fatalf("error registering: %s", err)
+	}
+	awaitRegistered(t, c, "get.test.model")
+
+	for _, subj := range []string{"call.test.model.method", "auth.test.model.login"} {
+		ch := sendRequest(c, subj)
+		var r Request
+		if err := stream.RecvMsg(&r); err != nil {
+			t.Fatalf("error receiving request: %s", err)
+		}
+		if r.Subject != subj {
+			t.Fatalf("expected request subject %s, but got %s", subj, r.Subject)
+		}
+		stream.SendMsg(&ServiceMessage{Response: &Response{ID: r.ID, Payload: []byte(`{"result":null}`)}})
+		if r := awaitResponse(t, ch); r.err != nil {
+			t.Fatalf("expected no error, but got: %s", r.err)
+		}
+	}
+	if r := awaitResponse(t, sendRequest(c, "call.test.other.method")); r.err != ErrNoService {
+		t.Fatalf("expected error %v, but got: %v", ErrNoService, r.err)
+	}
+}
+
+// Test that requests without a registered service fail with service
+// unavailable
+func TestRequest_NoService_ReturnsServiceUnavailable(t *testing.T) {
+	c := connect(t, func(c *Client) { c.Token = &testToken })
+	defer c.Close()
+
+	if r := awaitResponse(t, sendRequest(c, "get.test.model")); r.err != ErrNoService {
+		t.Fatalf("expected error %v, but got: %v", ErrNoService, r.err)
+	}
+}
+
+// Test that a pre-response extends the request timeout
+func TestPreResponse_ExtendsTimeout(t *testing.T) {
+	tbl := []struct {
+		Timeout  uint32 // Pre-response timeout in milliseconds, or 0 for none
+		Expected error
+	}{
+		{1000, nil},
+		{0, mq.ErrRequestTimeout},
+	}
+
+	for i, l := range tbl {
+		c := connect(t, func(c *Client) {
+			c.Token = &testToken
+			c.RequestTimeout = 100 * time.Millisecond
+		})
+		stream, done := dial(t, c)
+		register(t, c, stream)
+
+		ch := sendRequest(c, "call.test.model.method")
+		var r Request
+		if err := stream.RecvMsg(&r); err != nil {
+			t.Fatalf("#%d: error receiving request: %s", i+1, err)
+		}
+		if l.Timeout > 0 {
+			stream.SendMsg(&ServiceMessage{Response: &Response{ID: r.ID, Timeout: l.Timeout}})
+		}
+		time.Sleep(300 * time.Millisecond)
+		stream.SendMsg(&ServiceMessage{Response: &Response{ID: r.ID, Payload: []byte(`{"result":null}`)}})
+
+		if resp := awaitResponse(t, ch); resp.err != l.Expected {
+			t.Errorf("#%d: expected error %v, but got: %v", i+1, l.Expected, resp.err)
+		}
+		done()
+		c.Close()
+	}
+}
+
+// Test that events sent by a service are passed to subscribers of the
+// resource
+func TestEvent_PassedToSubscriber(t *testing.T) {
+	c := connect(t, func(c *Client) { c.Token = &testToken })
+	defer c.Close()
+	stream, done := dial(t, c)
+	defer done()
+	register(t, c, stream)
+
+	type event struct {
+		subj    string
+		payload string
+	}
+	ch := make(chan event, 1)
+	if _, err := c.Subscribe("event.test.model", func(subj string, payload []byte, _ error) {
+		ch <- event{subj, string(payload)}
+	}); err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+	stream.SendMsg(&ServiceMessage{Event: &Event{Subject: "event.test.other.change", Payload: []byte(`{"values":{"foo":"bar"}}`)}})
+	stream.SendMsg(&ServiceMessage{Event: &Event{Subject: "event.test.model.change", Payload: []byte(`{"values":{"foo":"baz"}}`)}})
+
+	select {
+	case ev := <-ch:
+		if ev.subj != "event.test.model.change" || ev.payload != `{"values":{"foo":"baz"}}` {
+			t.Fatalf("expected event.test.model.change event, but got %s: %s", ev.subj, ev.payload)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("expected an event, but found none")
+	}
+}
+
+// Test that pending requests fail, and that requests are no longer routed to
+// a service, when it disconnects
+func TestServiceDisconnected_ReturnsServiceUnavailable(t *testing.T) {
+	c := connect(t, func(c *Client) { c.Token = &testToken })
+	defer c.Close()
+	stream, done := dial(t, c)
+	register(t, c, stream)
+
+	ch := sendRequest(c, "get.test.model")
+	var r Request
+	if err := stream.RecvMsg(&r); err != nil {
+		t.Fatalf("error receiving request: %s", err)
+	}
+	done()
+
+	if resp := awaitResponse(t, ch); resp.err != ErrNoService {
+		t.Fatalf("expected pending request to fail with %v, but got: %v", ErrNoService, resp.err)
+	}
+	if resp := awaitResponse(t, sendRequest(c, "get.test.model")); resp.err != ErrNoService {
+		t.Fatalf("expected error %v, but got: %v", ErrNoService, resp.err)
+	}
+}
@@ -0,0 +1,251 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Request is a RES request sent by Resgate to a service.
+// See transport.proto for the message definitions.
+type Request struct {
+	ID      uint64
+	Subject string
+	Payload []byte
+}
+
+// ServiceMessage is a message sent by a service to Resgate. Exactly one of
+// the fields should be set.
+type ServiceMessage struct {
+	Register *Register
+	Response *Response
+	Event    *Event
+}
+
+// Register registers resource patterns handled by the service.
+type Register struct {
+	Patterns []string
+	Token    string
+}
+
+// Response is a response, or pre-response, to a request.
+type Response struct {
+	ID      uint64
+	Payload []byte
+	Timeout uint32
+}
+
+// Event is a RES event.
+type Event struct {
+	Subject string
+	Payload []byte
+}
+
+// message is implemented by the transport messages, encoding them using the
+// protobuf wire format.
+type message interface {
+	marshal(b []byte) []byte
+	unmarshal(b []byte) error
+}
+
+var errInvalidMessage = errors.New("invalid protobuf message")
+
+// codec is a gRPC codec for the transport messages.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(message)
+	if !ok {
+		return nil, fmt.Errorf("unsupported message type %T", v)
+	}
+	return m.marshal(nil), nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(message)
+	if !ok {
+		return fmt.Errorf("unsupported message type %T", v)
+	}
+	return m.unmarshal(data)
+}
+
+func (codec) Name() string {
+	return "proto"
+}
+
+func (m *Request) marshal(b []byte) []byte {
+	b = appendUint(b, 1, m.ID)
+	b = appendBytes(b, 2, []byte(m.Subject))
+	b = appendBytes(b, 3, m.Payload)
+	return b
+}
+
+func (m *Request) unmarshal(b []byte) error {
+	*m = Request{}
+	return parseFields(b, func(num protowire.Number, v uint64, bs []byte) {
+		switch num {
+		case 1:
+			m.ID = v
+		case 2:
+			m.Subject = string(bs)
+		case 3:
+			m.Payload = bs
+		}
+	})
+}
+
+func (m *ServiceMessage) marshal(b []byte) []byte {
+	switch {
+	case m.Register != nil:
+		b = appendMessage(b, 1, m.Register)
+	case m.Response != nil:
+		b = appendMessage(b, 2, m.Response)
+	case m.Event != nil:
+		b = appendMessage(b, 3, m.Event)
+	}
+	return b
+}
+
+func (m *ServiceMessage) unmarshal(b []byte) error {
+	*m = ServiceMessage{}
+	var err error
+	perr := parseFields(b, func(num protowire.Number, _ uint64, bs []byte) {
+		// A later oneof field replaces any earlier one.
+		switch num {
+		case 1:
+			*m = ServiceMessage{Register: &Register{}}
+			err = m.Register.unmarshal(bs)
+		case 2:
+			*m = ServiceMessage{Response: &Response{}}
+			err = m.Response.unmarshal(bs)
+		case 3:
+			*m = ServiceMessage{Event: &Event{}}
+			err = m.Event.unmarshal(bs)
+		}
+	})
+	if perr != nil {
+		return perr
+	}
+	return err
+}
+
+func (m *Register) marshal(b []byte) []byte {
+	for _, p := range m.Patterns {
+		b = appendBytes(b, 1, []byte(p))
+	}
+	b = appendBytes(b, 2, []byte(m.Token))
+	return b
+}
+
+func (m *Register) unmarshal(b []byte) error {
+	*m = Register{}
+	return parseFields(b, func(num protowire.Number, _ uint64, bs []byte) {
+		switch num {
+		case 1:
+			m.Patterns = append(m.Patterns, string(bs))
+		case 2:
+			m.Token = string(bs)
+		}
+	})
+}
+
+func (m *Response) marshal(b []byte) []byte {
+	b = appendUint(b, 1, m.ID)
+	b = appendBytes(b, 2, m.Payload)
+	b = appendUint(b, 3, uint64(m.Timeout))
+	return b
+}
+
+func (m *Response) unmarshal(b []byte) error {
+	*m = Response{}
+	return parseFields(b, func(num protowire.Number, v uint64, bs []byte) {
+		switch num {
+		case 1:
+			m.ID = v
+		case 2:
+			m.Payload = bs
+		case 3:
+			m.Timeout = uint32(v)
+		}
+	})
+}
+
+func (m *Event) marshal(b []byte) []byte {
+	b = appendBytes(b, 1, []byte(m.Subject))
+	b = appendBytes(b, 2, m.Payload)
+	return b
+}
+
+func (m *Event) unmarshal(b []byte) error {
+	*m = Event{}
+	return parseFields(b, func(num protowire.Number, _ uint64, bs []byte) {
+		switch num {
+		case 1:
+			m.Subject = string(bs)
+		case 2:
+			m.Payload = bs
+		}
+	})
+}
+
+// appendUint appends a varint field, omitting zero values as in proto3.
+func appendUint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// appendBytes appends a length-delimited field, omitting empty values as in
+// proto3.
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// appendMessage appends an embedded message field.
+func appendMessage(b []byte, num protowire.Number, m message) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m.marshal(nil))
+}
+
+// parseFields parses the fields of a message, calling cb with the value of
+// each varint or length-delimited field. Other field types are skipped.
+// Byte slices are copied, as the buffer may be reused.
+func parseFields(b []byte, cb func(num protowire.Number, v uint64, bs []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errInvalidMessage
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return errInvalidMessage
+			}
+			b = b[n:]
+			cb(num, v, nil)
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return errInvalidMessage
+			}
+			b = b[n:]
+			cb(num, 0, append([]byte(nil), v...))
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return errInvalidMessage
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	grpc "google.golang.org/grpc"
+)
+
+// Service is a Go service connection to the Resgate gRPC transport.
+// Services written in other languages use stubs generated from
+// transport.proto instead.
+type Service struct {
+	stream grpc.ClientStream
+	mu     sync.Mutex // Protects sending on the stream
+}
+
+// ConnectService opens a transport stream over the client connection, and
+// registers the resource patterns handled by the service. The token is the
+// shared secret configured for Resgate, or empty if none is configured.
+func ConnectService(ctx context.Context, cc *grpc.ClientConn, token string, patterns ...string) (*Service, error) {
+	stream, err := cc.NewStream(ctx, &grpc.StreamDesc{
+		StreamName:    connectName,
+		ServerStreams: true,
+		ClientStreams: true,
+	}, "/"+serviceName+"/"+connectName, grpc.ForceCodec(codec{}))
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{stream: stream}
+	if err := s.send(&ServiceMessage{Register: &Register{Patterns: patterns, Token: token}}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Recv waits for the next request.
+func (s *Service) Recv() (*Request, error) {
+	var r Request
+	if err := s.stream.RecvMsg(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Respond sends the response to a request.
+func (s *Service) Respond(id uint64, payload []byte) error {
+	return s.send(&ServiceMessage{Response: &Response{ID: id, Payload: payload}})
+}
+
+// Timeout sends a pre-response, setting a new timeout duration for the
+// request.
+func (s *Service) Timeout(id uint64, d time.Duration) error {
+	return s.send(&ServiceMessage{Response: &Response{ID: id, Timeout: uint32(d.Milliseconds())}})
+}
+
+// Publish sends an event. Eg. subject "event.example.model.change"
+func (s *Service) Publish(subject string, payload []byte) error {
+	return s.send(&ServiceMessage{Event: &Event{Subject: subject, Payload: payload}})
+}
+
+// Close closes the sending direction of the stream. The stream is fully
+// closed by canceling the context passed to ConnectService.
+func (s *Service) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream.CloseSend()
+}
+
+func (s *Service) send(m *ServiceMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream.SendMsg(m)
+}
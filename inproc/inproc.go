@@ -0,0 +1,378 @@
+// Package inproc provides an in-process implementation of the mq.Client
+// interface, letting Go services run in the same binary as resgate without
+// a message broker.
+//
+// Subjects are routed using NATS semantics, where the partial wildcard (*)
+// matches a single token, and the full wildcard (>) matches one or more
+// trailing tokens.
+package inproc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/server/mq"
+)
+
+// DefaultRequestTimeout is the request timeout used if none is set.
+const DefaultRequestTimeout = 3 * time.Second
+
+// Errors returned by the client.
+var (
+	ErrClosed           = errors.New("inproc: connection closed")
+	ErrInvalidSubject   = errors.New("inproc: invalid subject")
+	ErrAlreadyResponded = errors.New("inproc: request already responded to")
+	ErrNoReply          = errors.New("inproc: message has no reply")
+)
+
+// Client is an in-process messaging client, routing requests and events
+// between resgate and services handling subjects using Handle.
+type Client struct {
+	RequestTimeout time.Duration
+	Logger         logger.Logger
+
+	mu           sync.Mutex
+	connected    bool
+	subs         *sublist
+	reqs         map[*pending]struct{}
+	out          *queue
+	closeHandler func(error)
+}
+
+// Handler handles messages sent to a subject handled by a service.
+type Handler func(r *Request)
+
+// Request is a message received by a Handler. If the message is a request,
+// the handler should call Respond once.
+type Request struct {
+	// Subject the message was sent on.
+	Subject string
+	// Payload of the message.
+	Payload []byte
+
+	c         *Client
+	p         *pending
+	responded bool
+}
+
+// Subscription implements the mq.Unsubscriber interface.
+type Subscription struct {
+	c       *Client
+	subject string
+	q       *queue
+	event   bool
+	deliver func(subj string, payload []byte, p *pending)
+}
+
+// pending is a request awaiting a response.
+type pending struct {
+	cb mq.Response
+	t  *time.Timer
+}
+
+// Logf writes a formatted log message
+func (c *Client) Logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Log(fmt.Sprintf(format, v...))
+	}
+}
+
+// Tracef writes a formatted trace message
+func (c *Client) Tracef(format string, v ...interface{}) {
+	if c.Logger != nil && c.Logger.IsTrace() {
+		c.Logger.Trace(fmt.Sprintf(format, v...))
+	}
+}
+
+// Connect opens the client for messaging.
+func (c *Client) Connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connected {
+		return nil
+	}
+	c.init()
+	c.reqs = make(map[*pending]struct{})
+	c.out = newQueue()
+	c.connected = true
+	c.Logf("Connected to in-process messaging")
+	return nil
+}
+
+// IsClosed tests if the client has been closed.
+func (c *Client) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.connected
+}
+
+// Close closes the client. Pending requests are discarded, and event
+// subscriptions are removed. Service handlers remain registered.
+func (c *Client) Close() {
+	c.mu.Lock()
+	if !c.connected {
+		c.mu.Unlock()
+		return
+	}
+	c.connected = false
+	for p := range c.reqs {
+		p.t.Stop()
+	}
+	c.reqs = nil
+	out := c.out
+	c.out = nil
+	for _, sub := range c.subs.all() {
+		if sub.event {
+			c.subs.remove(sub)
+		}
+	}
+	c.mu.Unlock()
+
+	<-out.close()
+}
+
+// SetClosedHandler sets the handler when the connection is closed. As an
+// in-process client never loses its connection, the handler is never called.
+func (c *Client) SetClosedHandler(cb func(error)) {
+	c.closeHandler = cb
+}
+
+// SendRequest sends an asynchronous request on a subject, calling the
+// Response callback once on a separate go routine.
+func (c *Client) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.connected {
+		go cb("", nil, ErrClosed)
+		return
+	}
+	if !isValidSubject(subj, false) {
+		c.out.push(func() { cb("", nil, ErrInvalidSubject) })
+		return
+	}
+
+	c.Tracef("<== %s: %s", subj, payload)
+
+	p := &pending{cb: cb}
+	p.t = c.afterTimeout(p, c.requestTimeout())
+	c.reqs[p] = struct{}{}
+	for _, sub := range c.subs.match(subj) {
+		sub.push(subj, payload, p)
+	}
+}
+
+// Subscribe to all events on a resource namespace.
+// The namespace has the format "event."+resource
+func (c *Client) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.connected {
+		return nil, ErrClosed
+	}
+
+	sub := &Subscription{
+		c:       c,
+		subject: namespace + ".*",
+		q:       c.out,
+		event:   true,
+		deliver: func(subj string, payload []byte, _ *pending) {
+			c.Tracef("=>> %s: %s", subj, payload)
+			cb(subj, payload, nil)
+		},
+	}
+	if !isValidSubject(sub.subject, true) {
+		return nil, ErrInvalidSubject
+	}
+	c.Tracef("S=> %s", sub.subject)
+	c.subs.insert(sub)
+	return sub, nil
+}
+
+// Handle registers a service handler for a subject, which may contain
+// wildcards. Messages to a subscription are handled in order, on a separate
+// go routine for each subscription. Handlers may be registered before the
+// client is connected.
+func (c *Client) Handle(subject string, h Handler) (*Subscription, error) {
+	if !isValidSubject(subject, true) {
+		return nil, ErrInvalidSubject
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.init()
+	sub := &Subscription{
+		c:       c,
+		subject: subject,
+		q:       newQueue(),
+		deliver: func(subj string, payload []byte, p *pending) {
+			h(&Request{Subject: subj, Payload: payload, c: c, p: p})
+		},
+	}
+	c.subs.insert(sub)
+	return sub, nil
+}
+
+// Publish sends a message, such as an event, to all subscriptions matching
+// the subject.
+func (c *Client) Publish(subject string, payload []byte) error {
+	if !isValidSubject(subject, false) {
+		return ErrInvalidSubject
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.connected {
+		return ErrClosed
+	}
+	for _, sub := range c.subs.match(subject) {
+		sub.push(subject, payload, nil)
+	}
+	return nil
+}
+
+// Unsubscribe removes the subscription.
+func (s *Subscription) Unsubscribe() error {
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+
+	if s.event {
+		if !s.c.connected {
+			return ErrClosed
+		}
+		s.c.Tracef("U=> %s", s.subject)
+	} else {
+		s.q.close()
+	}
+	s.c.subs.remove(s)
+	return nil
+}
+
+// push queues a message for delivery. Client.mu is held when called.
+func (s *Subscription) push(subj string, payload []byte, p *pending) {
+	s.q.push(func() { s.deliver(subj, payload, p) })
+}
+
+// IsRequest reports if the message expects a response.
+func (r *Request) IsRequest() bool {
+	return r.p != nil
+}
+
+// Respond sends the response to a request. If more than one service handles
+// the same request, the first response is used. An inline meta response,
+// such as a pre-response with a timeout, does not count as a response.
+func (r *Request) Respond(payload []byte) error {
+	if r.p == nil {
+		return ErrNoReply
+	}
+	// Is the first character a-z or A-Z?
+	// Then it is an inline meta response
+	if len(payload) > 0 && (payload[0]|32) >= 'a' && (payload[0]|32) <= 'z' {
+		return r.respondMeta(payload)
+	}
+
+	c := r.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r.responded {
+		return ErrAlreadyResponded
+	}
+	r.responded = true
+
+	if !c.connected {
+		return ErrClosed
+	}
+	if _, ok := c.reqs[r.p]; !ok {
+		// Already responded to by another handler, or timed out.
+		return nil
+	}
+	delete(c.reqs, r.p)
+	r.p.t.Stop()
+
+	cb := r.p.cb
+	c.out.push(func() {
+		c.Tracef("==> %s: %s", r.Subject, payload)
+		cb(r.Subject, payload, nil)
+	})
+	return nil
+}
+
+// Timeout sets a new timeout duration for the request, counting from now.
+// It is the equivalent of a pre-response with a timeout.
+func (r *Request) Timeout(d time.Duration) error {
+	if r.p == nil {
+		return ErrNoReply
+	}
+
+	c := r.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r.responded {
+		return ErrAlreadyResponded
+	}
+	if _, ok := c.reqs[r.p]; !ok {
+		return nil
+	}
+	if r.p.t.Stop() {
+		c.Tracef("==> %s: timeout:\"%d\"", r.Subject, d.Milliseconds())
+		r.p.t = c.afterTimeout(r.p, d)
+	}
+	return nil
+}
+
+// respondMeta parses an inline meta response, extending the request timeout
+// if a timeout is set.
+func (r *Request) respondMeta(payload []byte) error {
+	tag := reflect.StructTag(payload)
+
+	// timeout tag
+	if v, ok := tag.Lookup("timeout"); ok {
+		timeout, err := strconv.Atoi(v)
+		if err != nil {
+			return nil
+		}
+		return r.Timeout(time.Duration(timeout) * time.Millisecond)
+	}
+	return nil
+}
+
+// init initializes the subject routing. Client.mu is held when called.
+func (c *Client) init() {
+	if c.subs == nil {
+		c.subs = newSublist()
+	}
+}
+
+// afterTimeout starts the timeout timer for a pending request.
+func (c *Client) afterTimeout(p *pending, d time.Duration) *time.Timer {
+	return time.AfterFunc(d, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.reqs[p]; !ok {
+			return
+		}
+		delete(c.reqs, p)
+		c.out.push(func() {
+			c.Tracef("x=> Request timeout")
+			p.cb("", nil, mq.ErrRequestTimeout)
+		})
+	})
+}
+
+func (c *Client) requestTimeout() time.Duration {
+	if c.RequestTimeout <= 0 {
+		return DefaultRequestTimeout
+	}
+	return c.RequestTimeout
+}
@@ -0,0 +1,227 @@
+package inproc
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+)
+
+const testTimeout = 5 * time.Second
+
+type response struct {
+	payload []byte
+	err     error
+}
+
+func sendRequest(c *Client, subj string) chan response {
+	ch := make(chan response, 1)
+	c.SendRequest(subj, []byte(`{}`), func(_ string, payload []byte, err error) {
+		ch <- response{payload, err}
+	})
+	return ch
+}
+
+func awaitResponse(t *testing.T, ch chan response) response {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(testTimeout):
+		t.Fatal("expected a response, but found none")
+	}
+	return response{}
+}
+
+// Test that a request is responded to by the service handler
+func TestRequest_HandledByService_ReturnsResponse(t *testing.T) {
+	c := &Client{}
+	c.Handle("get.test.>", func(r *Request) {
+		r.Respond([]byte(`{"result":{"model":{"foo":"bar"}}}`))
+	})
+	c.Connect()
+	defer c.Close()
+
+	r := awaitResponse(t, sendRequest(c, "get.test.model"))
+	if r.err != nil {
+		t.Fatalf("expected no error, but got: %s", r.err)
+	}
+	if string(r.payload) != `{"result":{"model":{"foo":"bar"}}}` {
+		t.Fatalf("expected response payload %s, but got %s", `{"result":{"model":{"foo":"bar"}}}`, r.payload)
+	}
+}
+
+// Test that a service handler may extend the request timeout
+func TestRequest_Timeout_ExtendsTimeout(t *testing.T) {
+	tbl := []struct {
+		Extend   bool
+		Expected error
+	}{
+		{true, nil},
+		{false, mq.ErrRequestTimeout},
+	}
+
+	for i, l := range tbl {
+		i, l := i, l
+		c := &Client{RequestTimeout: 100 * time.Millisecond}
+		c.Handle("get.test.slow", func(r *Request) {
+			if l.Extend {
+				r.Timeout(time.Second)
+			}
+			time.AfterFunc(300*time.Millisecond, func() {
+				r.Respond([]byte(`{"result":{"model":{"foo":"slow"}}}`))
+			})
+		})
+		c.Connect()
+
+		if r := awaitResponse(t, sendRequest(c, "get.test.slow")); r.err != l.Expected {
+			t.Errorf("#%d: expected error %v, but got: %v", i+1, l.Expected, r.err)
+		}
+		c.Close()
+	}
+}
+
+// Test that an inline meta pre-response extends the request timeout
+func TestRespond_InlineMetaTimeout_ExtendsTimeout(t *testing.T) {
+	tbl := []struct {
+		Meta     string
+		Expected error
+	}{
+		{`timeout:"1000"`, nil},
+		{`timeout:"foo"`, mq.ErrRequestTimeout},
+		{`foo:"bar"`, mq.ErrRequestTimeout},
+	}
+
+	for i, l := range tbl {
+		i, l := i, l
+		c := &Client{RequestTimeout: 100 * time.Millisecond}
+		c.Handle("get.test.slow", func(r *Request) {
+			if err := r.Respond([]byte(l.Meta)); err != nil {
+				t.Errorf("#%d: error responding with meta: %s", i+1, err)
+			}
+			time.AfterFunc(300*time.Millisecond, func() {
+				r.Respond([]byte(`{"result":{"model":{"foo":"slow"}}}`))
+			})
+		})
+		c.Connect()
+
+		r := awaitResponse(t, sendRequest(c, "get.test.slow"))
+		if r.err != l.Expected {
+			t.Errorf("#%d: expected error %v, but got: %v", i+1, l.Expected, r.err)
+		} else if r.err == nil && string(r.payload) != `{"result":{"model":{"foo":"slow"}}}` {
+			t.Errorf("#%d: expected the final response, but got %s", i+1, r.payload)
+		}
+		c.Close()
+	}
+}
+
+// Test that events published by a service are passed to subscribers of the
+// event namespace
+func TestSubscribe_PublishedEvent_PassedToSubscriber(t *testing.T) {
+	c := &Client{}
+	c.Connect()
+	defer c.Close()
+
+	ch := make(chan string, 4)
+	if _, err := c.Subscribe("event.test.model", func(subj string, _ []byte, _ error) {
+		ch <- subj
+	}); err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+	c.Publish("event.test.model.child.change", nil)
+	c.Publish("event.test.model.change", nil)
+
+	select {
+	case subj := <-ch:
+		if subj != "event.test.model.change" {
+			t.Fatalf("expected event.test.model.change, but got %s", subj)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("expected an event, but found none")
+	}
+}
+
+// Test that subjects are matched using NATS wildcard semantics
+func TestPublish_MatchesWildcards(t *testing.T) {
+	tbl := []struct {
+		Subject  string
+		Expected []string
+	}{
+		{"a.b.c", []string{"a.*.c", "a.>", "a.b.c", "*.b.*"}},
+		{"a.x.c", []string{"a.*.c", "a.>"}},
+		{"a.b", []string{"a.>"}},
+		{"x.b.y", []string{"*.b.*"}},
+		{"a", nil},
+		{"b.c", nil},
+	}
+
+	c := &Client{}
+	ch := make(chan string, 16)
+	for _, subj := range []string{"a.*.c", "a.>", "a.b.c", "*.b.*"} {
+		subj := subj
+		if _, err := c.Handle(subj, func(r *Request) { ch <- subj }); err != nil {
+			t.Fatalf("error handling %s: %s", subj, err)
+		}
+	}
+	c.Connect()
+	defer c.Close()
+
+	for i, l := range tbl {
+		if err := c.Publish(l.Subject, nil); err != nil {
+			t.Fatalf("#%d: error publishing: %s", i+1, err)
+		}
+		var got []string
+		for len(got) < len(l.Expected) {
+			select {
+			case s := <-ch:
+				got = append(got, s)
+			case <-time.After(testTimeout):
+				t.Fatalf("#%d: expected %d handler calls, but got %v", i+1, len(l.Expected), got)
+			}
+		}
+		select {
+		case s := <-ch:
+			t.Fatalf("#%d: unexpected handler call for %s", i+1, s)
+		case <-time.After(10 * time.Millisecond):
+		}
+		sort.Strings(got)
+		expected := append([]string(nil), l.Expected...)
+		sort.Strings(expected)
+		if len(expected) > 0 && !reflect.DeepEqual(got, expected) {
+			t.Fatalf("#%d: expected handlers %v, but got %v", i+1, expected, got)
+		}
+	}
+}
+
+// Test that invalid subjects are rejected
+func TestInvalidSubject_ReturnsError(t *testing.T) {
+	c := &Client{}
+	c.Connect()
+	defer c.Close()
+
+	for _, subj := range []string{"", "a..b", "a.>.b", "a.b*", "a b"} {
+		if _, err := c.Handle(subj, func(*Request) {}); err != ErrInvalidSubject {
+			t.Errorf("expected Handle(%#v) to return ErrInvalidSubject, but got %v", subj, err)
+		}
+	}
+	for _, subj := range []string{"a.*", "a.>"} {
+		if err := c.Publish(subj, nil); err != ErrInvalidSubject {
+			t.Errorf("expected Publish(%#v) to return ErrInvalidSubject, but got %v", subj, err)
+		}
+	}
+}
+
+// Test that requests on a closed client fail
+func TestClosed_SendRequest_ReturnsErrClosed(t *testing.T) {
+	c := &Client{}
+	c.Handle("get.test.model", func(r *Request) {
+		r.Respond([]byte(`{"result":null}`))
+	})
+	c.Connect()
+	c.Close()
+
+	if r := awaitResponse(t, sendRequest(c, "get.test.model")); r.err != ErrClosed {
+		t.Fatalf("expected error %v, but got: %v", ErrClosed, r.err)
+	}
+}
@@ -0,0 +1,70 @@
+package inproc
+
+import "sync"
+
+// queue is an unbounded queue of callbacks, called in order on a separate
+// go routine. Being unbounded, pushing never blocks, preventing deadlocks
+// between resgate and services calling each other.
+type queue struct {
+	mu      sync.Mutex
+	fs      []func()
+	work    chan struct{}
+	closed  bool
+	stopped chan struct{}
+}
+
+// newQueue creates a new queue and starts its worker.
+func newQueue() *queue {
+	q := &queue{
+		work:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// push adds a callback to the queue. Returns false if the queue is closed.
+func (q *queue) push(f func()) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	q.fs = append(q.fs, f)
+	select {
+	case q.work <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// close closes the queue. Callbacks already queued are still called.
+// The returned channel is closed once the worker has stopped.
+func (q *queue) close() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.work)
+	}
+	return q.stopped
+}
+
+func (q *queue) run() {
+	defer close(q.stopped)
+	for range q.work {
+		for {
+			q.mu.Lock()
+			if len(q.fs) == 0 {
+				q.fs = nil
+				q.mu.Unlock()
+				break
+			}
+			f := q.fs[0]
+			q.fs[0] = nil
+			q.fs = q.fs[1:]
+			q.mu.Unlock()
+			f()
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package inproc
+
+import (
+	"strings"
+)
+
+const (
+	pwc = "*" // Partial wildcard, matching a single token
+	fwc = ">" // Full wildcard, matching one or more trailing tokens
+)
+
+// sublist is a subject trie used to match subjects against subscriptions,
+// following NATS wildcard semantics.
+type sublist struct {
+	root *level
+}
+
+type level struct {
+	nodes map[string]*node
+}
+
+type node struct {
+	next *level
+	subs map[*Subscription]struct{}
+}
+
+func newSublist() *sublist {
+	return &sublist{root: newLevel()}
+}
+
+func newLevel() *level {
+	return &level{nodes: make(map[string]*node)}
+}
+
+// insert adds a subscription to the sublist.
+func (sl *sublist) insert(sub *Subscription) {
+	l := sl.root
+	var n *node
+	for _, t := range strings.Split(sub.subject, ".") {
+		if l == nil {
+			l = newLevel()
+			n.next = l
+		}
+		n = l.nodes[t]
+		if n == nil {
+			n = &node{}
+			l.nodes[t] = n
+		}
+		l = n.next
+	}
+	if n.subs == nil {
+		n.subs = make(map[*Subscription]struct{})
+	}
+	n.subs[sub] = struct{}{}
+}
+
+// remove removes a subscription from the sublist, pruning empty nodes.
+func (sl *sublist) remove(sub *Subscription) {
+	sl.root.remove(strings.Split(sub.subject, "."), sub)
+}
+
+// remove removes the subscription from the node matching the tokens, and
+// reports if the level is empty afterwards.
+func (l *level) remove(toks []string, sub *Subscription) bool {
+	n := l.nodes[toks[0]]
+	if n == nil {
+		return false
+	}
+	if len(toks) == 1 {
+		delete(n.subs, sub)
+	} else if n.next != nil && n.next.remove(toks[1:], sub) {
+		n.next = nil
+	}
+	if len(n.subs) == 0 && n.next == nil {
+		delete(l.nodes, toks[0])
+	}
+	return len(l.nodes) == 0
+}
+
+// all returns all subscriptions in the sublist.
+func (sl *sublist) all() []*Subscription {
+	var subs []*Subscription
+	sl.root.all(&subs)
+	return subs
+}
+
+func (l *level) all(subs *[]*Subscription) {
+	for _, n := range l.nodes {
+		*subs = appendSubs(*subs, n)
+		if n.next != nil {
+			n.next.all(subs)
+		}
+	}
+}
+
+// match returns all subscriptions matching a subject without wildcards.
+func (sl *sublist) match(subject string) []*Subscription {
+	var subs []*Subscription
+	sl.root.match(strings.Split(subject, "."), &subs)
+	return subs
+}
+
+func (l *level) match(toks []string, subs *[]*Subscription) {
+	if n := l.nodes[fwc]; n != nil {
+		*subs = appendSubs(*subs, n)
+	}
+	if n := l.nodes[pwc]; n != nil {
+		n.match(toks[1:], subs)
+	}
+	if n := l.nodes[toks[0]]; n != nil {
+		n.match(toks[1:], subs)
+	}
+}
+
+func (n *node) match(toks []string, subs *[]*Subscription) {
+	if len(toks) == 0 {
+		*subs = appendSubs(*subs, n)
+		return
+	}
+	if n.next != nil {
+		n.next.match(toks, subs)
+	}
+}
+
+func appendSubs(subs []*Subscription, n *node) []*Subscription {
+	for sub := range n.subs {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// isValidSubject reports if s is a valid subject. If wildcards is true, the
+// partial wildcard (*) is allowed as any token, and the full wildcard (>) is
+// allowed as the last token.
+func isValidSubject(s string, wildcards bool) bool {
+	if s == "" {
+		return false
+	}
+	toks := strings.Split(s, ".")
+	for i, t := range toks {
+		if t == "" || strings.ContainsAny(t, " \t\r\n") {
+			return false
+		}
+		if t == pwc || t == fwc {
+			if !wildcards || (t == fwc && i < len(toks)-1) {
+				return false
+			}
+			continue
+		}
+		if strings.ContainsAny(t, pwc+fwc) {
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -11,9 +12,13 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/resgateio/resgate/grpc"
 	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/mqrouter"
 	"github.com/resgateio/resgate/nats"
 	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/schema"
 )
 
 const (
@@ -32,7 +37,7 @@ var usageStr = `
 Usage: resgate [options]
 
 Server Options:
-    -n, --nats <url>                 NATS Server URL (default: nats://127.0.0.1:4222)
+    -n, --nats <url>                 NATS Server URL(s), separated by comma (default: nats://127.0.0.1:4222)
     -i  --addr <host>                Bind to HOST address (default: 0.0.0.0)
     -p, --port <port>                HTTP port for client connections (default: 8080)
     -w, --wspath <path>              WebSocket path for clients (default: /)
@@ -44,10 +49,26 @@ Server Options:
         --tlskey <file>              Private key for HTTP server certificate
         --apiencoding <type>         Encoding for web resources: json, jsonflat (default: json)
         --creds <file>               NATS User Credentials file
+        --nkeyseed <file>            NATS NKey seed file
+        --natsuser <user>            NATS user for user/password authentication
+        --natspass <password>        NATS password for user/password authentication
+        --natstoken <token>          NATS token for token authentication
+        --natscert <file>            NATS client certificate file for TLS
+        --natskey <file>             Private key for NATS client certificate
+        --natsrootca <file>          NATS root CA certificate file(s) for TLS
+        --natsservername <name>      NATS server name for TLS certificate verification
+        --natsmaxreconnect <count>   NATS reconnect attempts on lost connection, -1 for no limit (default: 0)
+        --natsreconnectwait <ms>     Wait duration between NATS reconnect attempts (default: 2000)
+        --grpc <addr>                Serve the gRPC service transport on address, instead of using NATS
+        --grpcclientca <file>        gRPC root CA certificate file(s) for verifying service certificates
+        --grpctoken <token>          gRPC shared secret that services must register with
         --alloworigin <origin>       Allowed origin(s): *, or <scheme>://<hostname>[:<port>] (default: *)
         --putmethod <methodName>     Call method name mapped to HTTP PUT requests
         --deletemethod <methodName>  Call method name mapped to HTTP DELETE requests
         --patchmethod <methodName>   Call method name mapped to HTTP PATCH requests
+        --graphqlpath <path>         GraphQL endpoint path for clients
+        --schema <file>              Resource schema file
+        --openapi                    Print OpenAPI document generated from the schema and exit
     -c, --config <file>              Configuration file
 
 Logging Options:
@@ -64,14 +85,49 @@ Configuration Documentation:         https://resgate.io/docs/get-started/configu
 
 // Config holds server configuration
 type Config struct {
-	NatsURL        string  `json:"natsUrl"`
-	NatsCreds      *string `json:"natsCreds"`
-	RequestTimeout int     `json:"requestTimeout"`
-	Debug          bool    `json:"debug"`
-	Trace          bool    `json:"trace"`
+	NatsURL           string               `json:"natsUrl"`
+	NatsCreds         *string              `json:"natsCreds"`
+	NatsNKeySeed      *string              `json:"natsNKeySeed"`
+	NatsUser          *string              `json:"natsUser"`
+	NatsPassword      *string              `json:"natsPassword"`
+	NatsToken         *string              `json:"natsToken"`
+	NatsTLSCert       *string              `json:"natsTLSCert"`
+	NatsTLSKey        *string              `json:"natsTLSKey"`
+	NatsRootCAs       []string             `json:"natsRootCAs"`
+	NatsTLSServerName *string              `json:"natsTLSServerName"`
+	NatsMaxReconnect  int                  `json:"natsMaxReconnect"`
+	NatsReconnectWait int                  `json:"natsReconnectWait"`
+	NatsServer        *nats.ServerConfig   `json:"natsServer"`
+	NatsRoutes        []NatsRoute          `json:"natsRoutes"`
+	GRPCAddr          *string              `json:"grpcAddr"`
+	GRPCTLSCert       *string              `json:"grpcTLSCert"`
+	GRPCTLSKey        *string              `json:"grpcTLSKey"`
+	GRPCClientCAs     []string             `json:"grpcClientCAs"`
+	GRPCToken         *string              `json:"grpcToken"`
+	RequestTimeout    int                  `json:"requestTimeout"`
+	RequestPolicies   []nats.RequestPolicy `json:"requestPolicies"`
+	CircuitBreaker    *nats.BreakerConfig  `json:"circuitBreaker"`
+	Debug             bool                 `json:"debug"`
+	Trace             bool                 `json:"trace"`
 	server.Config
 }
 
+// NatsRoute holds the configuration of a NATS connection used for resources
+// matching any of the patterns.
+type NatsRoute struct {
+	Patterns      []string `json:"patterns"`
+	URL           string   `json:"url"`
+	Creds         *string  `json:"creds"`
+	NKeySeed      *string  `json:"nkeySeed"`
+	User          *string  `json:"user"`
+	Password      *string  `json:"password"`
+	Token         *string  `json:"token"`
+	TLSCert       *string  `json:"tlsCert"`
+	TLSKey        *string  `json:"tlsKey"`
+	RootCAs       []string `json:"rootCAs"`
+	TLSServerName *string  `json:"tlsServerName"`
+}
+
 // StringSlice is a slice of strings implementing the flag.Value interface.
 type StringSlice []string
 
@@ -99,6 +155,32 @@ func (c *Config) SetDefault() {
 	c.Config.SetDefault()
 }
 
+// prepare validates the settings not validated by the server configuration.
+func (c *Config) prepare() error {
+	if c.GRPCAddr != nil {
+		if len(c.GRPCClientCAs) == 0 && c.GRPCToken == nil {
+			return fmt.Errorf("invalid grpcAddr setting (%s)\n\trequires grpcClientCAs or grpcToken to authenticate services", *c.GRPCAddr)
+		}
+		natsOnly := []struct {
+			name string
+			set  bool
+		}{
+			{"natsServer", c.NatsServer != nil},
+			{"natsRoutes", len(c.NatsRoutes) > 0},
+			{"requestPolicies", len(c.RequestPolicies) > 0},
+			{"circuitBreaker", c.CircuitBreaker != nil},
+		}
+		for _, s := range natsOnly {
+			if s.set {
+				return fmt.Errorf("invalid grpcAddr setting (%s)\n\tmust not be combined with %s, which is only used with NATS", *c.GRPCAddr, s.name)
+			}
+		}
+	} else if len(c.GRPCClientCAs) > 0 || c.GRPCToken != nil || c.GRPCTLSCert != nil || c.GRPCTLSKey != nil {
+		return errors.New("invalid gRPC settings\n\trequire grpcAddr to be set")
+	}
+	return nil
+}
+
 // Init takes a path to a json encoded file and loads the config
 // If no file exists, a new file with default settings is created
 func (c *Config) Init(fs *flag.FlagSet, args []string) {
@@ -110,11 +192,25 @@ func (c *Config) Init(fs *flag.FlagSet, args []string) {
 		headauth     string
 		addr         string
 		natsCreds    string
+		nkeySeed     string
+		natsUser     string
+		natsPass     string
+		natsToken    string
+		natsCert     string
+		natsKey      string
+		natsRootCAs  StringSlice
+		natsSrvName  string
+		grpcAddr     string
+		grpcCAs      StringSlice
+		grpcToken    string
 		debugTrace   bool
 		allowOrigin  StringSlice
 		putMethod    string
 		deleteMethod string
 		patchMethod  string
+		graphqlPath  string
+		schemaFile   string
+		showOpenAPI  bool
 	)
 
 	fs.BoolVar(&showHelp, "h", false, "Show this message.")
@@ -140,10 +236,26 @@ func (c *Config) Init(fs *flag.FlagSet, args []string) {
 	fs.IntVar(&c.RequestTimeout, "r", 0, "Timeout in milliseconds for NATS requests.")
 	fs.IntVar(&c.RequestTimeout, "reqtimeout", 0, "Timeout in milliseconds for NATS requests.")
 	fs.StringVar(&natsCreds, "creds", "", "NATS User Credentials file.")
+	fs.StringVar(&nkeySeed, "nkeyseed", "", "NATS NKey seed file.")
+	fs.StringVar(&natsUser, "natsuser", "", "NATS user for user/password authentication.")
+	fs.StringVar(&natsPass, "natspass", "", "NATS password for user/password authentication.")
+	fs.StringVar(&natsToken, "natstoken", "", "NATS token for token authentication.")
+	fs.StringVar(&natsCert, "natscert", "", "NATS client certificate file for TLS.")
+	fs.StringVar(&natsKey, "natskey", "", "Private key for NATS client certificate.")
+	fs.Var(&natsRootCAs, "natsrootca", "NATS root CA certificate file(s) for TLS.")
+	fs.StringVar(&natsSrvName, "natsservername", "", "NATS server name for TLS certificate verification.")
+	fs.IntVar(&c.NatsMaxReconnect, "natsmaxreconnect", 0, "NATS reconnect attempts on lost connection, -1 for no limit.")
+	fs.IntVar(&c.NatsReconnectWait, "natsreconnectwait", 0, "Wait duration in milliseconds between NATS reconnect attempts.")
+	fs.StringVar(&grpcAddr, "grpc", "", "Serve the gRPC service transport on address, instead of using NATS.")
+	fs.Var(&grpcCAs, "grpcclientca", "gRPC root CA certificate file(s) for verifying service certificates.")
+	fs.StringVar(&grpcToken, "grpctoken", "", "gRPC shared secret that services must register with.")
 	fs.Var(&allowOrigin, "alloworigin", "Allowed origin(s) for CORS.")
 	fs.StringVar(&putMethod, "putmethod", "", "Call method name mapped to HTTP PUT requests.")
 	fs.StringVar(&deleteMethod, "deletemethod", "", "Call method name mapped to HTTP DELETE requests.")
 	fs.StringVar(&patchMethod, "patchmethod", "", "Call method name mapped to HTTP PATCH requests.")
+	fs.StringVar(&graphqlPath, "graphqlpath", "", "GraphQL endpoint path for clients.")
+	fs.StringVar(&schemaFile, "schema", "", "Resource schema file.")
+	fs.BoolVar(&showOpenAPI, "openapi", false, "Print OpenAPI document generated from the schema and exit.")
 	fs.BoolVar(&c.Debug, "D", false, "Enable debugging output.")
 	fs.BoolVar(&c.Debug, "debug", false, "Enable debugging output.")
 	fs.BoolVar(&c.Trace, "V", false, "Enable trace logging.")
@@ -209,6 +321,28 @@ func (c *Config) Init(fs *flag.FlagSet, args []string) {
 			setString(headauth, &c.HeaderAuth)
 		case "creds":
 			setString(natsCreds, &c.NatsCreds)
+		case "nkeyseed":
+			setString(nkeySeed, &c.NatsNKeySeed)
+		case "natsuser":
+			setString(natsUser, &c.NatsUser)
+		case "natspass":
+			setString(natsPass, &c.NatsPassword)
+		case "natstoken":
+			setString(natsToken, &c.NatsToken)
+		case "natscert":
+			setString(natsCert, &c.NatsTLSCert)
+		case "natskey":
+			setString(natsKey, &c.NatsTLSKey)
+		case "natsrootca":
+			c.NatsRootCAs = natsRootCAs
+		case "natsservername":
+			setString(natsSrvName, &c.NatsTLSServerName)
+		case "grpc":
+			setString(grpcAddr, &c.GRPCAddr)
+		case "grpcclientca":
+			c.GRPCClientCAs = grpcCAs
+		case "grpctoken":
+			setString(grpcToken, &c.GRPCToken)
 		case "alloworigin":
 			str := allowOrigin.String()
 			c.AllowOrigin = &str
@@ -218,6 +352,10 @@ func (c *Config) Init(fs *flag.FlagSet, args []string) {
 			setString(deleteMethod, &c.DELETEMethod)
 		case "patchmethod":
 			setString(patchMethod, &c.PATCHMethod)
+		case "graphqlpath":
+			setString(graphqlPath, &c.GraphQLPath)
+		case "schema":
+			setString(schemaFile, &c.Schema)
 		case "i":
 			fallthrough
 		case "addr":
@@ -239,6 +377,10 @@ func (c *Config) Init(fs *flag.FlagSet, args []string) {
 		}
 		ioutil.WriteFile(configFile, fout, os.FileMode(0664))
 	}
+
+	if showOpenAPI {
+		openAPI(c)
+	}
 }
 
 // usage will print out the flag options for the server.
@@ -247,6 +389,23 @@ func usage() {
 	os.Exit(0)
 }
 
+// openAPI will print out the OpenAPI document generated from the schema file.
+func openAPI(c *Config) {
+	if c.Schema == nil {
+		printAndDie("Missing schema file for generating OpenAPI document", true)
+	}
+	sch, err := schema.Load(*c.Schema)
+	if err != nil {
+		printAndDie(fmt.Sprintf("Error loading schema: %s", err), false)
+	}
+	out, err := server.OpenAPI(sch, c.Config)
+	if err != nil {
+		printAndDie(fmt.Sprintf("Error generating OpenAPI document: %s", err), false)
+	}
+	fmt.Printf("%s\n", out)
+	os.Exit(0)
+}
+
 // version will print out the current resgate and protocol version.
 func version() {
 	fmt.Printf("resgate  v%s\nprotocol v%s\n", server.Version, server.ProtocolVersion)
@@ -268,6 +427,9 @@ func main() {
 	var cfg Config
 
 	cfg.Init(fs, os.Args[1:])
+	if err := cfg.prepare(); err != nil {
+		printAndDie(fmt.Sprintf("Invalid configuration: %s", err), false)
+	}
 
 	l := logger.NewStdLogger(cfg.Debug, cfg.Trace)
 
@@ -276,12 +438,66 @@ func main() {
 		fmt.Fprintf(os.Stderr, "[DEPRECATED] Request timeout should be in milliseconds.\nChange your requestTimeout from %d to %d, and you won't be bothered anymore.\n", cfg.RequestTimeout, cfg.RequestTimeout*1000)
 		cfg.RequestTimeout *= 1000
 	}
-	serv, err := server.NewService(&nats.Client{
-		URL:            cfg.NatsURL,
-		Creds:          cfg.NatsCreds,
-		RequestTimeout: time.Duration(cfg.RequestTimeout) * time.Millisecond,
-		Logger:         l,
-	}, cfg.Config)
+	var mqClient mq.Client
+	if cfg.GRPCAddr != nil {
+		mqClient = &grpc.Client{
+			Addr:           *cfg.GRPCAddr,
+			TLSCert:        cfg.GRPCTLSCert,
+			TLSKey:         cfg.GRPCTLSKey,
+			ClientCAs:      cfg.GRPCClientCAs,
+			Token:          cfg.GRPCToken,
+			RequestTimeout: time.Duration(cfg.RequestTimeout) * time.Millisecond,
+			Logger:         l,
+		}
+	} else {
+		mqClient = &nats.Client{
+			URL:             cfg.NatsURL,
+			Creds:           cfg.NatsCreds,
+			NKeySeed:        cfg.NatsNKeySeed,
+			Username:        cfg.NatsUser,
+			Password:        cfg.NatsPassword,
+			Token:           cfg.NatsToken,
+			TLSCert:         cfg.NatsTLSCert,
+			TLSKey:          cfg.NatsTLSKey,
+			RootCAs:         cfg.NatsRootCAs,
+			TLSServerName:   cfg.NatsTLSServerName,
+			MaxReconnect:    cfg.NatsMaxReconnect,
+			ReconnectWait:   time.Duration(cfg.NatsReconnectWait) * time.Millisecond,
+			Server:          cfg.NatsServer,
+			RequestTimeout:  time.Duration(cfg.RequestTimeout) * time.Millisecond,
+			RequestPolicies: cfg.RequestPolicies,
+			CircuitBreaker:  cfg.CircuitBreaker,
+			Logger:          l,
+		}
+		if len(cfg.NatsRoutes) > 0 {
+			router := &mqrouter.Client{Default: mqClient}
+			for _, r := range cfg.NatsRoutes {
+				router.Routes = append(router.Routes, mqrouter.Route{
+					Patterns: r.Patterns,
+					Client: &nats.Client{
+						URL:             r.URL,
+						Creds:           r.Creds,
+						NKeySeed:        r.NKeySeed,
+						Username:        r.User,
+						Password:        r.Password,
+						Token:           r.Token,
+						TLSCert:         r.TLSCert,
+						TLSKey:          r.TLSKey,
+						RootCAs:         r.RootCAs,
+						TLSServerName:   r.TLSServerName,
+						MaxReconnect:    cfg.NatsMaxReconnect,
+						ReconnectWait:   time.Duration(cfg.NatsReconnectWait) * time.Millisecond,
+						RequestTimeout:  time.Duration(cfg.RequestTimeout) * time.Millisecond,
+						RequestPolicies: cfg.RequestPolicies,
+						CircuitBreaker:  cfg.CircuitBreaker,
+						Logger:          l,
+					},
+				})
+			}
+			mqClient = router
+		}
+	}
+	serv, err := server.NewService(mqClient, cfg.Config)
 	if err != nil {
 		printAndDie(fmt.Sprintf("Failed to initialize server: %s", err.Error()), false)
 	}
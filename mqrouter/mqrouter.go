@@ -0,0 +1,268 @@
+// Package mqrouter provides an mq.Client that routes requests and event
+// subscriptions to different underlying clients by resource name pattern,
+// letting a single gateway serve resources from multiple messaging systems.
+package mqrouter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// QueryRouteTTL is the duration a query request subject, received in a
+// query event, is routed to the client that delivered the event.
+const QueryRouteTTL = time.Minute
+
+// Route maps resource name patterns to a client.
+type Route struct {
+	// Resource name patterns, using NATS wildcards. Eg. "billing.>"
+	Patterns []string
+	// Client used for resources matching any of the patterns.
+	Client mq.Client
+
+	patterns []rescache.ResourcePattern
+}
+
+// Client is an mq.Client routing requests and event subscriptions to the
+// client of the first route matching the resource name, or to the default
+// client if no route matches. Subscriptions to system and connection
+// events are made on all clients.
+type Client struct {
+	// Default client for resources not matching any route.
+	Default mq.Client
+	// Routes to match in order.
+	Routes []Route
+
+	mu      sync.Mutex
+	clients []mq.Client
+	queries map[string]mq.Client
+}
+
+// Subscription implements the mq.Unsubscriber interface, holding the
+// subscriptions made on one or more clients.
+type Subscription struct {
+	subs []mq.Unsubscriber
+}
+
+// Connect validates the routes and connects all clients.
+func (c *Client) Connect() error {
+	if c.Default == nil {
+		return errors.New("mqrouter: missing default client")
+	}
+
+	clients := []mq.Client{c.Default}
+	for i := range c.Routes {
+		r := &c.Routes[i]
+		if r.Client == nil {
+			return fmt.Errorf("mqrouter: missing client for route %s", strings.Join(r.Patterns, ", "))
+		}
+		r.patterns = make([]rescache.ResourcePattern, len(r.Patterns))
+		for j, s := range r.Patterns {
+			p := rescache.ParseResourcePattern(s)
+			if !p.IsValid() {
+				return fmt.Errorf("mqrouter: invalid route pattern %s", s)
+			}
+			r.patterns[j] = p
+		}
+		if !containsClient(clients, r.Client) {
+			clients = append(clients, r.Client)
+		}
+	}
+
+	for i, mc := range clients {
+		if err := mc.Connect(); err != nil {
+			for _, connected := range clients[:i] {
+				connected.Close()
+			}
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.clients = clients
+	c.queries = make(map[string]mq.Client)
+	c.mu.Unlock()
+	return nil
+}
+
+// Close closes all clients.
+func (c *Client) Close() {
+	c.mu.Lock()
+	clients := c.clients
+	c.clients = nil
+	c.mu.Unlock()
+
+	for _, mc := range clients {
+		mc.Close()
+	}
+}
+
+// IsClosed tests if any of the clients has been closed.
+func (c *Client) IsClosed() bool {
+	c.mu.Lock()
+	clients := c.clients
+	c.mu.Unlock()
+
+	if clients == nil {
+		return true
+	}
+	for _, mc := range clients {
+		if mc.IsClosed() {
+			return true
+		}
+	}
+	return false
+}
+
+// SetClosedHandler sets the handler called when any of the clients is
+// closed.
+func (c *Client) SetClosedHandler(cb func(error)) {
+	c.mu.Lock()
+	clients := c.clients
+	c.mu.Unlock()
+
+	for _, mc := range clients {
+		mc.SetClosedHandler(cb)
+	}
+}
+
+// SendRequest sends a request using the client routed to by the subject.
+func (c *Client) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.mu.Lock()
+	mc, ok := c.queries[subj]
+	c.mu.Unlock()
+
+	if !ok {
+		mc = c.routeRequest(subj)
+	}
+	mc.SendRequest(subj, payload, cb)
+}
+
+// Subscribe to all events on a resource namespace. Event namespaces are
+// routed by resource name, while other namespaces, such as system and
+// connection events, are subscribed to on all clients.
+func (c *Client) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
+	if strings.HasPrefix(namespace, "event.") {
+		mc := c.route(namespace[len("event."):])
+		return mc.Subscribe(namespace, c.queryHandler(mc, cb))
+	}
+
+	c.mu.Lock()
+	clients := c.clients
+	c.mu.Unlock()
+
+	s := &Subscription{}
+	for _, mc := range clients {
+		sub, err := mc.Subscribe(namespace, cb)
+		if err != nil {
+			s.Unsubscribe()
+			return nil, err
+		}
+		s.subs = append(s.subs, sub)
+	}
+	return s, nil
+}
+
+// Unsubscribe removes the subscriptions on all clients.
+func (s *Subscription) Unsubscribe() error {
+	var err error
+	for _, sub := range s.subs {
+		if e := sub.Unsubscribe(); e != nil && err == nil {
+			err = e
+		}
+	}
+	s.subs = nil
+	return err
+}
+
+// routeRequest returns the client for a request subject, with the format
+// "<type>.<resource>" or, for call and auth requests,
+// "<type>.<resource>.<method>".
+func (c *Client) routeRequest(subj string) mq.Client {
+	idx := strings.IndexByte(subj, '.')
+	if idx < 0 {
+		return c.Default
+	}
+	typ, rname := subj[:idx], subj[idx+1:]
+	if typ == "call" || typ == "auth" {
+		// Match on resource name without method, falling back to the full
+		// name to allow patterns on method names.
+		if idx = strings.LastIndexByte(rname, '.'); idx >= 0 {
+			if mc := c.match(rname[:idx]); mc != nil {
+				return mc
+			}
+		}
+	}
+	return c.route(rname)
+}
+
+// route returns the client for a resource name.
+func (c *Client) route(rname string) mq.Client {
+	if mc := c.match(rname); mc != nil {
+		return mc
+	}
+	return c.Default
+}
+
+// match returns the client of the first route matching the resource name,
+// or nil if no route matches.
+func (c *Client) match(rname string) mq.Client {
+	for _, r := range c.Routes {
+		for _, p := range r.patterns {
+			if p.Match(rname) {
+				return r.Client
+			}
+		}
+	}
+	return nil
+}
+
+// queryHandler wraps an event callback to route the query request subjects
+// of query events to the client delivering the event.
+func (c *Client) queryHandler(mc mq.Client, cb mq.Response) mq.Response {
+	if mc == c.Default && len(c.Routes) == 0 {
+		return cb
+	}
+	return func(subj string, payload []byte, err error) {
+		if strings.HasSuffix(subj, ".query") {
+			var ev struct {
+				Subject string `json:"subject"`
+			}
+			if json.Unmarshal(payload, &ev) == nil && ev.Subject != "" {
+				c.addQueryRoute(ev.Subject, mc)
+			}
+		}
+		cb(subj, payload, err)
+	}
+}
+
+func (c *Client) addQueryRoute(subj string, mc mq.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.queries == nil {
+		return
+	}
+	if _, ok := c.queries[subj]; !ok {
+		time.AfterFunc(QueryRouteTTL, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			delete(c.queries, subj)
+		})
+	}
+	c.queries[subj] = mc
+}
+
+func containsClient(clients []mq.Client, mc mq.Client) bool {
+	for _, v := range clients {
+		if v == mc {
+			return true
+		}
+	}
+	return false
+}
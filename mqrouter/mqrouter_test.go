@@ -0,0 +1,216 @@
+package mqrouter
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/resgateio/resgate/server/mq"
+)
+
+// testClient is an mq.Client recording the requests and subscriptions made.
+type testClient struct {
+	mu       sync.Mutex
+	connects int
+	reqs     []string
+	subs     map[string]mq.Response
+}
+
+type testSubscription struct {
+	c  *testClient
+	ns string
+}
+
+func (c *testClient) Connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connects++
+	c.subs = make(map[string]mq.Response)
+	return nil
+}
+
+func (c *testClient) Close()                       {}
+func (c *testClient) IsClosed() bool               { return false }
+func (c *testClient) SetClosedHandler(func(error)) {}
+
+func (c *testClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reqs = append(c.reqs, subj)
+}
+
+func (c *testClient) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subs[namespace] = cb
+	return &testSubscription{c: c, ns: namespace}, nil
+}
+
+func (s *testSubscription) Unsubscribe() error {
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	delete(s.c.subs, s.ns)
+	return nil
+}
+
+// publish calls the callback of the subscription for the namespace.
+func (c *testClient) publish(t *testing.T, ns, event string, payload []byte) {
+	c.mu.Lock()
+	cb, ok := c.subs[ns]
+	c.mu.Unlock()
+	if !ok {
+		t.Fatalf("expected a subscription for %s, but found none", ns)
+	}
+	cb(ns+"."+event, payload, nil)
+}
+
+// hasSubscription tests if there is a subscription for the namespace.
+func (c *testClient) hasSubscription(ns string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.subs[ns]
+	return ok
+}
+
+// lastRequest returns the subject of the last request, or an empty string
+// if none.
+func (c *testClient) lastRequest() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.reqs) == 0 {
+		return ""
+	}
+	return c.reqs[len(c.reqs)-1]
+}
+
+// connect returns a connected router with billing.> resources routed to
+// one client, and other resources to the default client.
+func connect(t *testing.T) (*Client, *testClient, *testClient) {
+	def := &testClient{}
+	routed := &testClient{}
+	c := &Client{
+		Default: def,
+		Routes: []Route{
+			{Patterns: []string{"billing.>"}, Client: routed},
+		},
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	return c, def, routed
+}
+
+// Test that requests are routed by the resource name of the subject
+func TestSendRequest_RoutedByPattern(t *testing.T) {
+	tbl := []struct {
+		Subject string
+		Routed  bool
+	}{
+		{"get.billing.model", true},
+		{"access.billing.model", true},
+		{"call.billing.model.method", true},
+		{"auth.billing.login", true},
+		{"get.test.model", false},
+		{"call.test.billing.method", false},
+		{"get.billing", false},
+	}
+
+	for i, l := range tbl {
+		c, def, routed := connect(t)
+		c.SendRequest(l.Subject, nil, nil)
+		expected, other, name := def, routed, "default"
+		if l.Routed {
+			expected, other, name = routed, def, "routed"
+		}
+		if expected.lastRequest() != l.Subject || other.lastRequest() != "" {
+			t.Errorf("#%d: expected %s to be sent to the %s client", i+1, l.Subject, name)
+		}
+	}
+}
+
+// Test that resource event subscriptions are made on the routed client only
+func TestSubscribe_EventNamespace_RoutedByPattern(t *testing.T) {
+	c, def, routed := connect(t)
+
+	if _, err := c.Subscribe("event.billing.model", func(string, []byte, error) {}); err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+	if !routed.hasSubscription("event.billing.model") || def.hasSubscription("event.billing.model") {
+		t.Fatal("expected subscription on the routed client only")
+	}
+}
+
+// Test that system event subscriptions are made on all clients, and removed
+// from all clients on unsubscribe
+func TestSubscribe_SystemNamespace_SubscribesOnAllClients(t *testing.T) {
+	c, def, routed := connect(t)
+
+	var got []string
+	sub, err := c.Subscribe("system", func(subj string, _ []byte, _ error) {
+		got = append(got, subj)
+	})
+	if err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+	def.publish(t, "system", "reset", []byte(`{"resources":["test.>"]}`))
+	routed.publish(t, "system", "reset", []byte(`{"resources":["billing.>"]}`))
+	if expected := []string{"system.reset", "system.reset"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected events %v, but got %v", expected, got)
+	}
+
+	sub.Unsubscribe()
+	if def.hasSubscription("system") || routed.hasSubscription("system") {
+		t.Fatal("expected subscriptions to be removed from all clients")
+	}
+}
+
+// Test that query requests of query events are sent to the client
+// delivering the event
+func TestQueryEvent_QueryRequest_RoutedToEventClient(t *testing.T) {
+	c, def, routed := connect(t)
+
+	if _, err := c.Subscribe("event.billing.model", func(string, []byte, error) {}); err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+	routed.publish(t, "event.billing.model", "query", []byte(`{"subject":"_QUERY_.abc"}`))
+
+	c.SendRequest("_QUERY_.abc", nil, nil)
+	if routed.lastRequest() != "_QUERY_.abc" || def.lastRequest() != "" {
+		t.Fatal("expected query request to be sent to the routed client")
+	}
+}
+
+// Test that a client used by multiple routes is connected once
+func TestConnect_SharedClient_ConnectsOnce(t *testing.T) {
+	def := &testClient{}
+	shared := &testClient{}
+	c := &Client{
+		Default: def,
+		Routes: []Route{
+			{Patterns: []string{"billing.>"}, Client: shared},
+			{Patterns: []string{"report.>"}, Client: shared},
+			{Patterns: []string{"test.>"}, Client: def},
+		},
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	if def.connects != 1 || shared.connects != 1 {
+		t.Fatalf("expected each client to connect once, but got %d and %d", def.connects, shared.connects)
+	}
+}
+
+// Test that connecting with invalid routes returns an error
+func TestConnect_InvalidRoute_ReturnsError(t *testing.T) {
+	tbl := []*Client{
+		{},
+		{Default: &testClient{}, Routes: []Route{{Patterns: []string{"billing.>.foo"}, Client: &testClient{}}}},
+		{Default: &testClient{}, Routes: []Route{{Patterns: []string{"billing.>"}}}},
+	}
+
+	for i, c := range tbl {
+		if err := c.Connect(); err == nil {
+			t.Errorf("#%d: expected an error, but got none", i+1)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package nats
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+const (
+	// DefaultBreakerThreshold is the default number of consecutive timeouts
+	// opening a circuit.
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerProbeInterval is the default duration in milliseconds
+	// between probe requests on an open circuit.
+	DefaultBreakerProbeInterval = 5000
+)
+
+// BreakerConfig holds the circuit breaker settings. A circuit is kept for
+// each resource namespace, and opens after a number of consecutive request
+// timeouts. While open, requests fail with system.serviceUnavailable, except
+// for a periodic probe request that closes the circuit on response.
+type BreakerConfig struct {
+	// Consecutive timeouts opening the circuit.
+	// Defaults to DefaultBreakerThreshold.
+	Threshold int `json:"threshold"`
+	// Duration in milliseconds between probe requests on an open circuit.
+	// Defaults to DefaultBreakerProbeInterval.
+	ProbeInterval int `json:"probeInterval"`
+	// Resource patterns, using NATS wildcards, defining namespaces sharing
+	// a circuit. Resources not matching any pattern use the first segment
+	// of the resource name as namespace.
+	Patterns []string `json:"patterns"`
+}
+
+type breaker struct {
+	threshold int
+	interval  time.Duration
+	names     []string
+	patterns  []rescache.ResourcePattern
+	mu        sync.Mutex
+	circuits  map[string]*circuit
+}
+
+type circuit struct {
+	failures int
+	open     bool
+	probing  bool
+	probeAt  time.Time
+}
+
+// newBreaker validates the config and returns a new breaker.
+func newBreaker(cfg *BreakerConfig) (*breaker, error) {
+	b := &breaker{
+		threshold: cfg.Threshold,
+		interval:  time.Duration(cfg.ProbeInterval) * time.Millisecond,
+		circuits:  make(map[string]*circuit),
+	}
+	if cfg.Threshold < 0 || cfg.ProbeInterval < 0 {
+		return nil, fmt.Errorf("invalid circuit breaker config: negative value")
+	}
+	if b.threshold == 0 {
+		b.threshold = DefaultBreakerThreshold
+	}
+	if b.interval == 0 {
+		b.interval = DefaultBreakerProbeInterval * time.Millisecond
+	}
+	for _, s := range cfg.Patterns {
+		p := rescache.ParseResourcePattern(s)
+		if !p.IsValid() {
+			return nil, fmt.Errorf("invalid circuit breaker pattern: %s", s)
+		}
+		b.names = append(b.names, s)
+		b.patterns = append(b.patterns, p)
+	}
+	return b, nil
+}
+
+// namespace returns the circuit namespace of a request subject, or an empty
+// string if the subject has no resource name.
+func (b *breaker) namespace(subj string) string {
+	_, rname := requestResource(subj)
+	if rname == "" {
+		return ""
+	}
+	for i, p := range b.patterns {
+		if p.Match(rname) {
+			return b.names[i]
+		}
+	}
+	if idx := strings.IndexByte(rname, '.'); idx >= 0 {
+		return rname[:idx]
+	}
+	return rname
+}
+
+// allow reports if a request may be sent on the namespace circuit. An open
+// circuit allows a single probe request once the probe interval has passed.
+func (b *breaker) allow(ns string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cc, ok := b.circuits[ns]
+	if !ok || !cc.open {
+		return true
+	}
+	if cc.probing || time.Now().Before(cc.probeAt) {
+		return false
+	}
+	cc.probing = true
+	return true
+}
+
+// done records the outcome of a request, and returns if the circuit was
+// opened or closed by it, and if it is open.
+func (b *breaker) done(ns string, err error) (changed bool, open bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cc, ok := b.circuits[ns]
+	if err == nil {
+		// Any response, including error responses, shows the service is up.
+		if !ok {
+			return false, false
+		}
+		delete(b.circuits, ns)
+		return cc.open, false
+	}
+	if err != mq.ErrRequestTimeout {
+		// Other errors are not caused by the service. Release the probe
+		// without counting a failure.
+		if ok && cc.probing {
+			cc.probing = false
+			cc.probeAt = time.Now().Add(b.interval)
+		}
+		return false, ok && cc.open
+	}
+	if !ok {
+		cc = &circuit{}
+		b.circuits[ns] = cc
+	}
+	cc.failures++
+	if cc.open {
+		if cc.probing {
+			cc.probing = false
+			cc.probeAt = time.Now().Add(b.interval)
+		}
+		return false, true
+	}
+	if cc.failures >= b.threshold {
+		cc.open = true
+		cc.probeAt = time.Now().Add(b.interval)
+		return true, true
+	}
+	return false, false
+}
+
+// breakerResponse wraps a response callback to record the outcome on the
+// namespace circuit.
+func (c *Client) breakerResponse(ns string, cb mq.Response) mq.Response {
+	return func(subj string, data []byte, err error) {
+		if changed, open := c.breaker.done(ns, err); changed {
+			if open {
+				c.Logf("Circuit opened for namespace %s after %d consecutive timeouts", ns, c.breaker.threshold)
+			} else {
+				c.Logf("Circuit closed for namespace %s", ns)
+			}
+		}
+		cb(subj, data, err)
+	}
+}
+
+// breakerAllow checks the namespace circuit before sending a request,
+// returning the wrapped callback, or false if the request should fail fast.
+func (c *Client) breakerAllow(subj string, cb mq.Response) (mq.Response, bool) {
+	if c.breaker == nil {
+		return cb, true
+	}
+	ns := c.breaker.namespace(subj)
+	if ns == "" {
+		return cb, true
+	}
+	if !c.breaker.allow(ns) {
+		c.Tracef("x=> %s: Circuit open", subj)
+		go cb("", nil, reserr.ErrServiceUnavailable)
+		return nil, false
+	}
+	return c.breakerResponse(ns, cb), true
+}
@@ -0,0 +1,93 @@
+package nats
+
+import (
+	"testing"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// connectBreaker returns a client using the circuit breaker, and a service
+// connection subscribing to all requests without responding, to have
+// requests time out instead of failing for lack of responders.
+func connectBreaker(t *testing.T, cfg *BreakerConfig) (*Client, *nats.Conn, func()) {
+	c, nc, done := connect(t, func(c *Client) {
+		c.RequestTimeout = 50 * time.Millisecond
+		c.CircuitBreaker = cfg
+	})
+	nc.Subscribe(">", func(*nats.Msg) {})
+	nc.Flush()
+	return c, nc, done
+}
+
+func assertError(t *testing.T, subj string, r response, expected error) {
+	if r.err != expected {
+		t.Fatalf("expected %s to return error %v, but got %v", subj, expected, r.err)
+	}
+}
+
+// Test that the circuit opens after consecutive timeouts, failing requests
+// fast, and closes when a probe request gets a response
+func TestCircuitBreaker_ConsecutiveTimeouts_OpensCircuit(t *testing.T) {
+	c, nc, done := connectBreaker(t, &BreakerConfig{Threshold: 2, ProbeInterval: 200})
+	defer done()
+
+	for i := 0; i < 2; i++ {
+		r := awaitResponse(t, sendRequest(c, "get.test.model", nil))
+		assertError(t, "get.test.model", r, mq.ErrRequestTimeout)
+	}
+
+	// Open circuit should fail fast for the namespace only
+	start := time.Now()
+	r := awaitResponse(t, sendRequest(c, "call.test.other.method", nil))
+	assertError(t, "call.test.other.method", r, reserr.ErrServiceUnavailable)
+	if d := time.Since(start); d > 40*time.Millisecond {
+		t.Errorf("expected open circuit to fail fast, but took %s", d)
+	}
+	r = awaitResponse(t, sendRequest(c, "get.example.model", nil))
+	assertError(t, "get.example.model", r, mq.ErrRequestTimeout)
+
+	// Service recovers, and the probe closes the circuit
+	nc.Subscribe("get.test.>", func(m *nats.Msg) {
+		m.Respond([]byte(`{"result":{"model":{}}}`))
+	})
+	nc.Flush()
+	time.Sleep(250 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		r = awaitResponse(t, sendRequest(c, "get.test.model", nil))
+		assertError(t, "get.test.model", r, nil)
+	}
+}
+
+// Test that responses reset the consecutive timeout count
+func TestCircuitBreaker_ResponseBetweenTimeouts_KeepsCircuitClosed(t *testing.T) {
+	c, nc, done := connectBreaker(t, &BreakerConfig{Threshold: 2})
+	defer done()
+	nc.Subscribe("get.test.ok", func(m *nats.Msg) {
+		m.Respond([]byte(`{"error":{"code":"system.notFound","message":"Not found"}}`))
+	})
+	nc.Flush()
+
+	for _, subj := range []string{"get.test.model", "get.test.ok", "get.test.model"} {
+		r := awaitResponse(t, sendRequest(c, subj, nil))
+		if r.err == reserr.ErrServiceUnavailable {
+			t.Fatalf("expected circuit to be closed for %s", subj)
+		}
+	}
+}
+
+// Test that configured patterns define separate circuit namespaces
+func TestCircuitBreaker_Pattern_DefinesNamespace(t *testing.T) {
+	c, _, done := connectBreaker(t, &BreakerConfig{Threshold: 1, Patterns: []string{"test.report.>"}})
+	defer done()
+
+	r := awaitResponse(t, sendRequest(c, "get.test.report.daily", nil))
+	assertError(t, "get.test.report.daily", r, mq.ErrRequestTimeout)
+	r = awaitResponse(t, sendRequest(c, "get.test.report.weekly", nil))
+	assertError(t, "get.test.report.weekly", r, reserr.ErrServiceUnavailable)
+	r = awaitResponse(t, sendRequest(c, "get.test.model", nil))
+	assertError(t, "get.test.model", r, mq.ErrRequestTimeout)
+}
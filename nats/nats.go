@@ -1,13 +1,20 @@
 package nats
 
 import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/jirenius/timerqueue"
+	server "github.com/nats-io/nats-server/v2/server"
 	nats "github.com/nats-io/nats.go"
 	"github.com/resgateio/resgate/logger"
 	"github.com/resgateio/resgate/server/mq"
@@ -15,15 +22,77 @@ import (
 
 const (
 	natsChannelSize = 256
+
+	// HeaderContentType is the message header describing the payload
+	// encoding. Requests are sent with application/json.
+	HeaderContentType = "Content-Type"
+
+	// HeaderTimeout is the response message header used by services to set
+	// a new request timeout duration in milliseconds. A response with the
+	// header and an empty payload is treated as a meta response.
+	HeaderTimeout = "Timeout"
+
+	// HeaderTraceParent is the message header carrying the W3C trace
+	// context. Requests are sent with a new trace context, and the trace
+	// context of responses and events is included in the trace log.
+	HeaderTraceParent = "traceparent"
+
+	// HeaderStatus is the message header set by the NATS server on status
+	// messages, such as when a request has no responders.
+	HeaderStatus = "Status"
+
+	statusNoResponders = "503"
+
+	contentTypeJSON = "application/json"
+
+	// systemSubject is the subject of the system event subscription.
+	systemSubject = "system.*"
 )
 
+// resetAllPayload is the system reset event payload for all resources and
+// access, sent after a reconnect as events may have been missed.
+var resetAllPayload = []byte(`{"resources":[">"],"access":[">"]}`)
+
 // Client holds a client connection to a nats server.
 type Client struct {
 	RequestTimeout time.Duration
-	URL            string
-	Creds          *string
+	// URL to the NATS server. Multiple seed URLs are separated by comma.
+	URL string
+	// User credentials file path.
+	Creds *string
+	// NKey seed file path.
+	NKeySeed *string
+	// Username and password for user/password authentication.
+	Username *string
+	Password *string
+	// Token for token authentication.
+	Token *string
+	// Client certificate and private key file paths for TLS.
+	TLSCert *string
+	TLSKey  *string
+	// Root CA certificate file paths for verifying the server certificate.
+	RootCAs []string
+	// Server name used to verify the server certificate.
+	TLSServerName *string
+	// Maximum number of reconnect attempts, cycling through the seed URLs,
+	// when the connection is lost. Zero disables reconnect, and a negative
+	// value reconnects indefinitely. After a reconnect, all resources and
+	// access are reset.
+	MaxReconnect int
+	// Wait duration between reconnect attempts to the same server.
+	ReconnectWait time.Duration
+	// Embedded NATS server settings. If set, a server is started on Connect,
+	// and URL and the client authentication settings are ignored.
+	Server *ServerConfig
+	// Request policies, matched in order, setting timeout and retries for
+	// requests by resource pattern and request type.
+	RequestPolicies []RequestPolicy
+	// Circuit breaker settings. Missing value or nil disables the breaker.
+	CircuitBreaker *BreakerConfig
 	Logger         logger.Logger
 
+	ns           *server.Server
+	nsURL        string
 	mq           *nats.Conn
 	mqCh         chan *nats.Msg
 	mqReqs       map[*nats.Subscription]*responseCont
@@ -31,6 +100,8 @@ type Client struct {
 	mu           sync.Mutex
 	closeHandler func(error)
 	stopped      chan struct{}
+	policies     []requestPolicy
+	breaker      *breaker
 }
 
 // Subscription implements the mq.Unsubscriber interface.
@@ -69,17 +140,58 @@ func (c *Client) Connect() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.Logf("Connecting to NATS at %s", c.URL)
+	policies, err := parsePolicies(c.RequestPolicies)
+	if err != nil {
+		return err
+	}
+	c.policies = policies
+	if c.CircuitBreaker != nil {
+		if c.breaker, err = newBreaker(c.CircuitBreaker); err != nil {
+			return err
+		}
+	}
 
 	// Create connection options
-	opts := []nats.Option{nats.NoReconnect(), nats.ClosedHandler(c.onClose)}
-	if c.Creds != nil {
-		opts = append(opts, nats.UserCredentials(*c.Creds))
+	opts := []nats.Option{nats.ClosedHandler(c.onClose)}
+	if c.MaxReconnect == 0 {
+		// No reconnects as all resources are instantly stale anyhow
+		opts = append(opts, nats.NoReconnect())
+	} else {
+		opts = append(opts,
+			nats.MaxReconnects(c.MaxReconnect),
+			nats.DisconnectErrHandler(c.onDisconnect),
+			nats.ReconnectHandler(c.onReconnect),
+		)
+		if c.ReconnectWait > 0 {
+			opts = append(opts, nats.ReconnectWait(c.ReconnectWait))
+		}
+	}
+
+	u := c.URL
+	if c.Server == nil {
+		authOpts, err := c.authOptions()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, authOpts...)
+	} else {
+		var err error
+		if u, err = c.startServer(); err != nil {
+			return err
+		}
+		if c.Server.Username != nil {
+			opts = append(opts, nats.UserInfo(*c.Server.Username, *c.Server.Password))
+		}
+		if c.Server.Token != nil {
+			opts = append(opts, nats.Token(*c.Server.Token))
+		}
 	}
 
-	// No reconnects as all resources are instantly stale anyhow
-	nc, err := nats.Connect(c.URL, opts...)
+	c.Logf("Connecting to NATS at %s", u)
+
+	nc, err := nats.Connect(u, opts...)
 	if err != nil {
+		c.stopServer()
 		return err
 	}
 
@@ -94,6 +206,50 @@ func (c *Client) Connect() error {
 	return nil
 }
 
+// authOptions returns the connection options for authentication and TLS.
+func (c *Client) authOptions() ([]nats.Option, error) {
+	var opts []nats.Option
+	if c.TLSServerName != nil {
+		opts = append(opts, nats.Secure(&tls.Config{
+			ServerName: *c.TLSServerName,
+			MinVersion: tls.VersionTLS12,
+		}))
+	}
+	if c.TLSCert != nil || c.TLSKey != nil {
+		if c.TLSCert == nil || c.TLSKey == nil {
+			return nil, errors.New("nats: both client certificate and key must be set")
+		}
+		opts = append(opts, nats.ClientCert(*c.TLSCert, *c.TLSKey))
+	}
+	if len(c.RootCAs) > 0 {
+		opts = append(opts, nats.RootCAs(c.RootCAs...))
+	}
+	if c.Creds != nil {
+		opts = append(opts, nats.UserCredentials(*c.Creds))
+	}
+	if c.NKeySeed != nil {
+		opt, err := nats.NkeyOptionFromSeed(*c.NKeySeed)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	if c.Username != nil || c.Password != nil {
+		var user, pass string
+		if c.Username != nil {
+			user = *c.Username
+		}
+		if c.Password != nil {
+			pass = *c.Password
+		}
+		opts = append(opts, nats.UserInfo(user, pass))
+	}
+	if c.Token != nil {
+		opts = append(opts, nats.Token(*c.Token))
+	}
+	return opts, nil
+}
+
 // IsClosed tests if the client connection has been closed.
 func (c *Client) IsClosed() bool {
 	c.mu.Lock()
@@ -114,6 +270,10 @@ func (c *Client) Close() {
 
 	<-stopped
 	c.Debugf("NATS listener stopped")
+
+	c.mu.Lock()
+	c.stopServer()
+	c.mu.Unlock()
 }
 
 func (c *Client) close() chan struct{} {
@@ -159,8 +319,51 @@ func (c *Client) onClose(conn *nats.Conn) {
 	}
 }
 
+func (c *Client) onDisconnect(_ *nats.Conn, err error) {
+	c.Logf("Disconnected from NATS: %s", err)
+}
+
+// onReconnect resets all resources and access by passing a system reset
+// event to the system event subscriptions, as events may have been missed
+// while disconnected.
+func (c *Client) onReconnect(conn *nats.Conn) {
+	c.Logf("Reconnected to NATS at %s", conn.ConnectedUrl())
+	c.mu.Lock()
+	var cbs []mq.Response
+	for sub, rc := range c.mqReqs {
+		if !rc.isReq && sub.Subject == systemSubject {
+			cbs = append(cbs, rc.f)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, cb := range cbs {
+		c.Tracef("=>> system.reset: %s", resetAllPayload)
+		cb("system.reset", resetAllPayload, nil)
+	}
+}
+
 // SendRequest sends a request to the MQ.
 func (c *Client) SendRequest(subj string, payload []byte, cb mq.Response) {
+	p := c.policy(subj)
+	if p == nil {
+		c.sendRequest(subj, payload, cb, 0)
+		return
+	}
+	if p.retryable(subj) {
+		cb = c.retryResponse(subj, payload, cb, p, 0)
+	}
+	c.sendRequest(subj, payload, cb, p.timeout)
+}
+
+// sendRequest sends a request with a timeout, or with the default request
+// timeout if zero.
+func (c *Client) sendRequest(subj string, payload []byte, cb mq.Response, timeout time.Duration) {
+	cb, ok := c.breakerAllow(subj, cb)
+	if !ok {
+		return
+	}
+
 	inbox := nats.NewInbox()
 
 	// Validate max control line size
@@ -177,17 +380,37 @@ func (c *Client) SendRequest(subj string, payload []byte, cb mq.Response) {
 		go cb("", nil, err)
 		return
 	}
-	c.Tracef("<== (%s) %s: %s", inboxSubstr(inbox), subj, payload)
-
-	err = c.mq.PublishRequest(subj, inbox, payload)
+	if c.mq.HeadersSupported() {
+		tp := newTraceParent()
+		c.Tracef("<== (%s) %s [%s]: %s", inboxSubstr(inbox), subj, tp, payload)
+		err = c.mq.PublishMsg(&nats.Msg{
+			Subject: subj,
+			Reply:   inbox,
+			Data:    payload,
+			Header: nats.Header{
+				HeaderContentType: []string{contentTypeJSON},
+				HeaderTraceParent: []string{tp},
+			},
+		})
+	} else {
+		c.Tracef("<== (%s) %s: %s", inboxSubstr(inbox), subj, payload)
+		err = c.mq.PublishRequest(subj, inbox, payload)
+	}
 	if err != nil {
 		sub.Unsubscribe()
 		go cb("", nil, err)
 		return
 	}
 
-	c.tq.Add(sub)
-	c.mqReqs[sub] = &responseCont{isReq: true, f: cb}
+	rc := &responseCont{isReq: true, f: cb}
+	if timeout > 0 {
+		rc.t = time.AfterFunc(timeout, func() {
+			c.onTimeout(sub)
+		})
+	} else {
+		c.tq.Add(sub)
+	}
+	c.mqReqs[sub] = rc
 }
 
 // Subscribe to all events on a resource namespace.
@@ -229,10 +452,28 @@ func (c *Client) listener(ch chan *nats.Msg, stopped chan struct{}) {
 	for msg := range ch {
 		c.mu.Lock()
 		rc, ok := c.mqReqs[msg.Sub]
+		var err error
 		if ok && rc.isReq {
-			// Is the first character a-z or A-Z?
-			// Then it is a meta response
-			if len(msg.Data) > 0 && (msg.Data[0]|32) >= 'a' && (msg.Data[0]|32) <= 'z' {
+			if len(msg.Header) > 0 {
+				if msg.Header.Get(HeaderStatus) == statusNoResponders {
+					// No service is subscribing to the subject
+					err = mq.ErrNoResponders
+				} else if len(msg.Data) == 0 {
+					// Meta response with headers only
+					c.parseHeaderMeta(msg, rc)
+					c.mu.Unlock()
+					c.Tracef("==> (%s): %s", inboxSubstr(msg.Subject), formatHeader(msg.Header))
+					continue
+				} else if ct := msg.Header.Get(HeaderContentType); ct != "" && !isJSONContentType(ct) {
+					err = fmt.Errorf("unsupported content type: %s", ct)
+				}
+			}
+			// Headers without meta, such as trace context, may be sent
+			// together with an inline meta response.
+			if err == nil && msg.Header.Get(HeaderTimeout) == "" && msg.Header.Get(HeaderStatus) == "" &&
+				len(msg.Data) > 0 && (msg.Data[0]|32) >= 'a' && (msg.Data[0]|32) <= 'z' {
+				// Is the first character a-z or A-Z?
+				// Then it is an inline meta response
 				c.parseMeta(msg, rc)
 				c.mu.Unlock()
 				c.Tracef("==> (%s): %s", inboxSubstr(msg.Subject), msg.Data)
@@ -248,38 +489,63 @@ func (c *Client) listener(ch chan *nats.Msg, stopped chan struct{}) {
 		}
 		c.mu.Unlock()
 
-		if ok {
+		if ok && c.Logger.IsTrace() {
+			var tp string
+			if v := msg.Header.Get(HeaderTraceParent); v != "" {
+				tp = " [" + v + "]"
+			}
 			if rc.isReq {
-				c.Tracef("==> (%s): %s", inboxSubstr(msg.Subject), msg.Data)
+				c.Tracef("==> (%s)%s: %s", inboxSubstr(msg.Subject), tp, msg.Data)
+			} else {
+				c.Tracef("=>> %s%s: %s", msg.Subject, tp, msg.Data)
+			}
+		}
+		if ok {
+			if err != nil {
+				rc.f("", nil, err)
 			} else {
-				c.Tracef("=>> %s: %s", msg.Subject, msg.Data)
+				rc.f(msg.Subject, msg.Data, nil)
 			}
-			rc.f(msg.Subject, msg.Data, nil)
 		}
 	}
 
 	close(stopped)
 }
 
+// parseMeta parses an inline meta response, used by services not
+// supporting headers.
 func (c *Client) parseMeta(msg *nats.Msg, rc *responseCont) {
 	tag := reflect.StructTag(msg.Data)
 
 	// timeout tag
 	if v, ok := tag.Lookup("timeout"); ok {
-		timeout, err := strconv.Atoi(v)
-		if err == nil {
-			var removed bool
-			if rc.t == nil {
-				removed = c.tq.Remove(msg.Sub)
-			} else {
-				removed = rc.t.Stop()
-			}
-			if removed {
-				rc.t = time.AfterFunc(time.Duration(timeout)*time.Millisecond, func() {
-					c.onTimeout(msg.Sub)
-				})
-			}
-		}
+		c.setTimeout(msg.Sub, rc, v)
+	}
+}
+
+// parseHeaderMeta parses the headers of a meta response.
+func (c *Client) parseHeaderMeta(msg *nats.Msg, rc *responseCont) {
+	if v := msg.Header.Get(HeaderTimeout); v != "" {
+		c.setTimeout(msg.Sub, rc, v)
+	}
+}
+
+// setTimeout sets a new timeout duration, in milliseconds, for a request.
+func (c *Client) setTimeout(sub *nats.Subscription, rc *responseCont, v string) {
+	timeout, err := strconv.Atoi(v)
+	if err != nil {
+		return
+	}
+	var removed bool
+	if rc.t == nil {
+		removed = c.tq.Remove(sub)
+	} else {
+		removed = rc.t.Stop()
+	}
+	if removed {
+		rc.t = time.AfterFunc(time.Duration(timeout)*time.Millisecond, func() {
+			c.onTimeout(sub)
+		})
 	}
 }
 
@@ -304,6 +570,42 @@ func (c *Client) onTimeout(v interface{}) {
 	rc.f("", nil, mq.ErrRequestTimeout)
 }
 
+// isJSONContentType reports if the content type is application/json,
+// ignoring any parameters such as charset.
+func isJSONContentType(ct string) bool {
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	return strings.EqualFold(strings.TrimSpace(ct), contentTypeJSON)
+}
+
+// formatHeader formats message headers for trace logging.
+func formatHeader(h nats.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(k)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(h[k], ", "))
+	}
+	return b.String()
+}
+
+// newTraceParent returns a W3C trace context traceparent header value with a
+// new random trace ID and parent ID.
+func newTraceParent() string {
+	var id [24]byte
+	rand.Read(id[:])
+	return "00-" + hex.EncodeToString(id[:16]) + "-" + hex.EncodeToString(id[16:]) + "-00"
+}
+
 func inboxSubstr(s string) string {
 	l := len(s)
 	if l <= 6 {
@@ -0,0 +1,423 @@
+package nats
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	server "github.com/nats-io/nats-server/v2/server"
+	nats "github.com/nats-io/nats.go"
+	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/server/mq"
+)
+
+const testTimeout = 5 * time.Second
+
+// runServer starts a NATS server on the port, or on a random port if -1.
+func runServer(t *testing.T, port int) *server.Server {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: port, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("error creating NATS server: %s", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(testTimeout) {
+		ns.Shutdown()
+		t.Fatal("NATS server not ready for connections")
+	}
+	return ns
+}
+
+func serverURL(ns *server.Server) string {
+	return "nats://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(ns.Addr().(*net.TCPAddr).Port))
+}
+
+// Test that a reconnect passes a system reset of all resources and access
+// to the system event subscription.
+func TestReconnect_SendsSystemReset(t *testing.T) {
+	ns := runServer(t, -1)
+	port := ns.Addr().(*net.TCPAddr).Port
+
+	c := &Client{
+		URL:            serverURL(ns),
+		RequestTimeout: time.Second,
+		MaxReconnect:   -1,
+		ReconnectWait:  10 * time.Millisecond,
+		Logger:         logger.NewMemLogger(false, false),
+	}
+	if err := c.Connect(); err != nil {
+		ns.Shutdown()
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer c.Close()
+
+	type event struct {
+		subj    string
+		payload string
+	}
+	ch := make(chan event, 1)
+	if _, err := c.Subscribe("system", func(subj string, payload []byte, _ error) {
+		ch <- event{subj, string(payload)}
+	}); err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+
+	ns.Shutdown()
+	ns = runServer(t, port)
+	defer ns.Shutdown()
+
+	select {
+	case ev := <-ch:
+		if ev.subj != "system.reset" || ev.payload != `{"resources":[">"],"access":[">"]}` {
+			t.Fatalf("expected a system reset of all resources and access, but got %s: %s", ev.subj, ev.payload)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("expected a system reset after reconnect, but got none")
+	}
+	if c.IsClosed() {
+		t.Fatal("expected the connection to be open")
+	}
+}
+
+// Test that the connection is closed on lost connection when reconnect is
+// disabled.
+func TestNoReconnect_ClosesConnection(t *testing.T) {
+	ns := runServer(t, -1)
+
+	c := &Client{
+		URL:            serverURL(ns),
+		RequestTimeout: time.Second,
+		Logger:         logger.NewMemLogger(false, false),
+	}
+	closed := make(chan error, 1)
+	c.SetClosedHandler(func(err error) { closed <- err })
+	if err := c.Connect(); err != nil {
+		ns.Shutdown()
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer c.Close()
+
+	ns.Shutdown()
+
+	select {
+	case <-closed:
+	case <-time.After(testTimeout):
+		t.Fatal("expected the closed handler to be called")
+	}
+}
+
+// connect starts a NATS server, and returns a connected client, a service
+// connection, and a function to close them. The configure callback, if not
+// nil, is called prior to connecting the client.
+func connect(t *testing.T, configure func(c *Client)) (*Client, *nats.Conn, func()) {
+	ns := runServer(t, -1)
+	c := &Client{
+		URL:            serverURL(ns),
+		RequestTimeout: time.Second,
+		Logger:         logger.NewMemLogger(false, true),
+	}
+	if configure != nil {
+		configure(c)
+	}
+	if err := c.Connect(); err != nil {
+		ns.Shutdown()
+		t.Fatalf("error connecting: %s", err)
+	}
+	nc, err := nats.Connect(serverURL(ns))
+	if err != nil {
+		c.Close()
+		ns.Shutdown()
+		t.Fatalf("error connecting service: %s", err)
+	}
+	return c, nc, func() {
+		nc.Close()
+		c.Close()
+		ns.Shutdown()
+	}
+}
+
+type response struct {
+	payload []byte
+	err     error
+}
+
+func sendRequest(c *Client, subj string, payload []byte) chan response {
+	ch := make(chan response, 1)
+	c.SendRequest(subj, payload, func(_ string, data []byte, err error) {
+		ch <- response{data, err}
+	})
+	return ch
+}
+
+func awaitResponse(t *testing.T, ch chan response) response {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(testTimeout):
+		t.Fatal("expected a response, but found none")
+	}
+	return response{}
+}
+
+// Test that requests are sent with a new trace context header
+func TestRequest_HasTraceParentHeader(t *testing.T) {
+	c, nc, done := connect(t, nil)
+	defer done()
+
+	tps := make(chan string, 2)
+	if _, err := nc.Subscribe("test.method", func(m *nats.Msg) {
+		tps <- m.Header.Get(HeaderTraceParent)
+		m.Respond([]byte(`{"result":null}`))
+	}); err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+	nc.Flush()
+
+	awaitResponse(t, sendRequest(c, "test.method", []byte(`{}`)))
+	awaitResponse(t, sendRequest(c, "test.method", []byte(`{}`)))
+	tp1, tp2 := <-tps, <-tps
+	re := regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-00$`)
+	if !re.MatchString(tp1) {
+		t.Fatalf("expected a valid traceparent header, but got %q", tp1)
+	}
+	if tp1 == tp2 {
+		t.Fatalf("expected a new traceparent for each request, but got %q twice", tp1)
+	}
+}
+
+// Test that the trace context of a response is included in the trace log
+func TestResponse_WithTraceParentHeader_IsTraced(t *testing.T) {
+	c, nc, done := connect(t, nil)
+	defer done()
+
+	tp := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	if _, err := nc.Subscribe("test.method", func(m *nats.Msg) {
+		nc.PublishMsg(&nats.Msg{
+			Subject: m.Reply,
+			Data:    []byte(`{"result":null}`),
+			Header:  nats.Header{HeaderTraceParent: []string{tp}},
+		})
+	}); err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+	nc.Flush()
+
+	r := awaitResponse(t, sendRequest(c, "test.method", []byte(`{}`)))
+	if r.err != nil {
+		t.Fatalf("expected no error, but got: %s", r.err)
+	}
+	if !regexp.MustCompile(`==> \([^)]+\) \[` + tp + `\]: \{"result":null\}`).MatchString(c.Logger.(*logger.MemLogger).String()) {
+		t.Fatalf("expected the response trace context to be logged, but got:\n%s", c.Logger.(*logger.MemLogger).String())
+	}
+}
+
+// Test that a request without responders fails with service unavailable
+func TestRequest_NoResponders_ReturnsServiceUnavailable(t *testing.T) {
+	c, _, done := connect(t, nil)
+	defer done()
+
+	start := time.Now()
+	r := awaitResponse(t, sendRequest(c, "test.method", []byte(`{}`)))
+	if r.err != mq.ErrNoResponders {
+		t.Fatalf("expected error %v, but got: %v", mq.ErrNoResponders, r.err)
+	}
+	if time.Since(start) >= c.RequestTimeout {
+		t.Fatal("expected the request to fail before the request timeout")
+	}
+}
+
+// Test that requests are sent with a JSON content type header
+func TestRequest_HasContentTypeHeader(t *testing.T) {
+	c, nc, done := connect(t, nil)
+	defer done()
+
+	hch := make(chan nats.Header, 1)
+	nc.Subscribe("test.method", func(m *nats.Msg) {
+		hch <- m.Header
+		m.Respond([]byte(`{"result":null}`))
+	})
+	nc.Flush()
+
+	r := awaitResponse(t, sendRequest(c, "test.method", []byte(`{}`)))
+	if r.err != nil {
+		t.Fatalf("expected no error, but got: %s", r.err)
+	}
+	if ct := (<-hch).Get(HeaderContentType); ct != "application/json" {
+		t.Errorf("expected content type %#v, but got %#v", "application/json", ct)
+	}
+}
+
+// Test that meta responses, using either a timeout header or inline meta,
+// extend the request timeout, also when inline meta is sent with headers
+// other than meta headers
+func TestMetaResponse_ExtendsTimeout(t *testing.T) {
+	tbl := []struct {
+		Meta *nats.Msg
+	}{
+		{&nats.Msg{Header: nats.Header{HeaderTimeout: []string{"1000"}}}},
+		{&nats.Msg{Data: []byte(`timeout:"1000"`)}},
+		{&nats.Msg{Data: []byte(`timeout:"1000"`), Header: nats.Header{HeaderTraceParent: []string{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}}}},
+	}
+
+	for i, l := range tbl {
+		c, nc, done := connect(t, func(c *Client) { c.RequestTimeout = 100 * time.Millisecond })
+		nc.Subscribe("test.method", func(m *nats.Msg) {
+			m.RespondMsg(l.Meta)
+			time.AfterFunc(300*time.Millisecond, func() {
+				m.Respond([]byte(`{"result":"foo"}`))
+			})
+		})
+		nc.Flush()
+
+		r := awaitResponse(t, sendRequest(c, "test.method", []byte(`{}`)))
+		if r.err != nil {
+			t.Errorf("#%d: expected no error, but got: %s", i+1, r.err)
+		} else if string(r.payload) != `{"result":"foo"}` {
+			t.Errorf("#%d: expected payload %s, but got %s", i+1, `{"result":"foo"}`, r.payload)
+		}
+		done()
+	}
+}
+
+// Test that requests time out without a meta response
+func TestNoMetaResponse_TimesOut(t *testing.T) {
+	c, nc, done := connect(t, func(c *Client) { c.RequestTimeout = 100 * time.Millisecond })
+	defer done()
+
+	nc.Subscribe("test.method", func(m *nats.Msg) {
+		time.AfterFunc(300*time.Millisecond, func() {
+			m.Respond([]byte(`{"result":"foo"}`))
+		})
+	})
+	nc.Flush()
+
+	r := awaitResponse(t, sendRequest(c, "test.method", []byte(`{}`)))
+	if r.err != mq.ErrRequestTimeout {
+		t.Fatalf("expected request timeout error, but got: %v", r.err)
+	}
+}
+
+// Test that the response content type header is validated
+func TestResponse_ContentTypeHeader_IsValidated(t *testing.T) {
+	tbl := []struct {
+		ContentType string
+		Valid       bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"Application/JSON", true},
+		{"text/plain", false},
+		{"application/msgpack", false},
+	}
+
+	for i, l := range tbl {
+		c, nc, done := connect(t, nil)
+		nc.Subscribe("test.method", func(m *nats.Msg) {
+			m.RespondMsg(&nats.Msg{
+				Data:   []byte(`{"result":"foo"}`),
+				Header: nats.Header{HeaderContentType: []string{l.ContentType}},
+			})
+		})
+		nc.Flush()
+
+		r := awaitResponse(t, sendRequest(c, "test.method", []byte(`{}`)))
+		if l.Valid && r.err != nil {
+			t.Errorf("#%d: expected no error, but got: %s", i+1, r.err)
+		} else if !l.Valid && r.err == nil {
+			t.Errorf("#%d: expected an error, but got payload: %s", i+1, r.payload)
+		}
+		done()
+	}
+}
+
+// unusedURL returns a NATS URL to a local port with no listener.
+func unusedURL(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	defer l.Close()
+	return "nats://" + l.Addr().String()
+}
+
+// Test that the client connects using user/password and token authentication
+func TestConnect_WithCredentials_Authenticates(t *testing.T) {
+	user := "foo"
+	pass := "bar"
+	wrong := "baz"
+	token := "secret"
+
+	tbl := []struct {
+		Server   ServerConfig
+		Client   *Client
+		Expected bool
+	}{
+		{ServerConfig{Username: &user, Password: &pass}, &Client{Username: &user, Password: &pass}, true},
+		{ServerConfig{Username: &user, Password: &pass}, &Client{Username: &user, Password: &wrong}, false},
+		{ServerConfig{Username: &user, Password: &pass}, &Client{}, false},
+		{ServerConfig{Token: &token}, &Client{Token: &token}, true},
+		{ServerConfig{Token: &token}, &Client{Token: &wrong}, false},
+	}
+
+	for i, l := range tbl {
+		u, stop := startServer(t, &l.Server)
+		c := l.Client
+		c.URL = u
+		c.RequestTimeout = time.Second
+		c.Logger = logger.NewMemLogger(false, false)
+		err := c.Connect()
+		if l.Expected && err != nil {
+			t.Errorf("#%d: expected connect to succeed, but got error: %s", i+1, err)
+		} else if !l.Expected && err == nil {
+			t.Errorf("#%d: expected connect to fail", i+1)
+		}
+		if err == nil {
+			c.Close()
+		}
+		stop()
+	}
+}
+
+// Test that the client tries each seed URL when connecting
+func TestConnect_MultipleURLs_ConnectsToAvailableServer(t *testing.T) {
+	ns := runServer(t, -1)
+	defer ns.Shutdown()
+
+	c := &Client{
+		URL:            unusedURL(t) + "," + serverURL(ns),
+		RequestTimeout: time.Second,
+		Logger:         logger.NewMemLogger(false, false),
+	}
+	// Try multiple times, as the seed URLs are randomized.
+	for i := 0; i < 5; i++ {
+		if err := c.Connect(); err != nil {
+			t.Fatalf("expected connect to succeed, but got error: %s", err)
+		}
+		c.Close()
+	}
+}
+
+// Test that invalid TLS and NKey settings fail on connect
+func TestConnect_InvalidFiles_ReturnsError(t *testing.T) {
+	ns := runServer(t, -1)
+	defer ns.Shutdown()
+
+	missing := "missing.file"
+	tbl := []*Client{
+		{TLSCert: &missing},
+		{TLSCert: &missing, TLSKey: &missing},
+		{RootCAs: []string{missing}},
+		{NKeySeed: &missing},
+	}
+
+	for i, c := range tbl {
+		c.URL = serverURL(ns)
+		c.RequestTimeout = time.Second
+		c.Logger = logger.NewMemLogger(false, false)
+		if err := c.Connect(); err == nil {
+			c.Close()
+			t.Errorf("#%d: expected connect to fail", i+1)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package nats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// RequestPolicy holds the timeout and retry settings for requests matching a
+// resource pattern and request type.
+type RequestPolicy struct {
+	// Resource name pattern, using NATS wildcards. Eg. "report.>"
+	// Empty matches all resources.
+	Pattern string `json:"pattern"`
+	// Request types the policy applies to: get, access, call, or auth.
+	// Empty applies to all types.
+	Types []string `json:"types"`
+	// Request timeout in milliseconds. Zero uses the client RequestTimeout.
+	Timeout int `json:"timeout"`
+	// Number of times to retry get and access requests that time out.
+	// Retries are not made for call and auth requests, as they are not
+	// idempotent.
+	Retries int `json:"retries"`
+	// Delay in milliseconds before the first retry. The delay is doubled
+	// for each subsequent retry.
+	Backoff int `json:"backoff"`
+}
+
+// requestPolicy is a validated RequestPolicy.
+type requestPolicy struct {
+	pattern *rescache.ResourcePattern
+	types   []string
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+}
+
+// parsePolicies validates the request policies.
+func parsePolicies(rps []RequestPolicy) ([]requestPolicy, error) {
+	ps := make([]requestPolicy, 0, len(rps))
+	for _, rp := range rps {
+		p := requestPolicy{
+			types:   rp.Types,
+			timeout: time.Duration(rp.Timeout) * time.Millisecond,
+			retries: rp.Retries,
+			backoff: time.Duration(rp.Backoff) * time.Millisecond,
+		}
+		if rp.Pattern != "" {
+			pattern := rescache.ParseResourcePattern(rp.Pattern)
+			if !pattern.IsValid() {
+				return nil, fmt.Errorf("invalid request policy pattern: %s", rp.Pattern)
+			}
+			p.pattern = &pattern
+		}
+		for _, t := range rp.Types {
+			if t != "get" && t != "access" && t != "call" && t != "auth" {
+				return nil, fmt.Errorf("invalid request policy type: %s", t)
+			}
+		}
+		if rp.Timeout < 0 || rp.Retries < 0 || rp.Backoff < 0 {
+			return nil, fmt.Errorf("invalid request policy for pattern %s: negative value", rp.Pattern)
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// policy returns the first policy matching a request subject, or nil if no
+// policy matches. The subject has the format "<type>.<resource>", or, for
+// call and auth requests, "<type>.<resource>.<method>".
+func (c *Client) policy(subj string) *requestPolicy {
+	if len(c.policies) == 0 {
+		return nil
+	}
+	typ, rname := requestResource(subj)
+	if rname == "" {
+		return nil
+	}
+	for i := range c.policies {
+		p := &c.policies[i]
+		if p.matches(typ, rname) {
+			return p
+		}
+	}
+	return nil
+}
+
+// requestResource splits a request subject into request type and resource
+// name, excluding any method name of call and auth requests.
+func requestResource(subj string) (typ string, rname string) {
+	idx := strings.IndexByte(subj, '.')
+	if idx < 0 {
+		return subj, ""
+	}
+	typ, rname = subj[:idx], subj[idx+1:]
+	if typ == "call" || typ == "auth" {
+		if idx = strings.LastIndexByte(rname, '.'); idx >= 0 {
+			rname = rname[:idx]
+		}
+	}
+	return typ, rname
+}
+
+func (p *requestPolicy) matches(typ, rname string) bool {
+	if p.pattern != nil && !p.pattern.Match(rname) {
+		return false
+	}
+	if len(p.types) == 0 {
+		return true
+	}
+	for _, t := range p.types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// retryable reports if timed out requests of the type should be retried.
+func (p *requestPolicy) retryable(subj string) bool {
+	return p.retries > 0 && (strings.HasPrefix(subj, "get.") || strings.HasPrefix(subj, "access."))
+}
+
+// retryResponse wraps a response callback to resend the request on timeout,
+// until the policy retries are exhausted.
+func (c *Client) retryResponse(subj string, payload []byte, cb mq.Response, p *requestPolicy, attempt int) mq.Response {
+	return func(rsubj string, data []byte, err error) {
+		if err != mq.ErrRequestTimeout || attempt >= p.retries {
+			cb(rsubj, data, err)
+			return
+		}
+		delay := p.backoff << uint(attempt)
+		c.Debugf("Retrying request %s in %s (%d/%d)", subj, delay, attempt+1, p.retries)
+		time.AfterFunc(delay, func() {
+			c.mu.Lock()
+			closed := c.mq == nil
+			c.mu.Unlock()
+			// Pending requests are dropped on close
+			if closed {
+				return
+			}
+			c.sendRequest(subj, payload, c.retryResponse(subj, payload, cb, p, attempt+1), p.timeout)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package nats
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/server/mq"
+)
+
+// Test that a matching request policy overrides the request timeout
+func TestRequestPolicy_MatchingPattern_UsesPolicyTimeout(t *testing.T) {
+	tbl := []struct {
+		Subject  string
+		Types    []string
+		Expected error
+	}{
+		{"get.report.model", nil, nil},
+		{"call.report.model.method", nil, nil},
+		{"call.report.model.method", []string{"call"}, nil},
+		{"call.report.model.method", []string{"get"}, mq.ErrRequestTimeout},
+		{"get.test.model", nil, mq.ErrRequestTimeout},
+	}
+
+	for i, l := range tbl {
+		c, nc, done := connect(t, func(c *Client) {
+			c.RequestTimeout = 100 * time.Millisecond
+			c.RequestPolicies = []RequestPolicy{{Pattern: "report.>", Types: l.Types, Timeout: 1000}}
+		})
+		nc.Subscribe(l.Subject, func(m *nats.Msg) {
+			time.Sleep(300 * time.Millisecond)
+			m.Respond([]byte(`{"result":null}`))
+		})
+		nc.Flush()
+
+		r := awaitResponse(t, sendRequest(c, l.Subject, []byte(`{}`)))
+		done()
+		if r.err != l.Expected {
+			t.Errorf("test #%d: expected error %v, but got %v", i+1, l.Expected, r.err)
+		}
+	}
+}
+
+// Test that timed out get and access requests are retried, but not call
+// requests
+func TestRequestPolicy_Retries_ResendsTimedOutRequests(t *testing.T) {
+	tbl := []struct {
+		Subject          string
+		Retries          int
+		ExpectedRequests int32
+		Expected         error
+	}{
+		{"get.test.model", 2, 3, nil},
+		{"access.test.model", 2, 3, nil},
+		{"get.test.model", 1, 2, mq.ErrRequestTimeout},
+		{"call.test.model.method", 2, 1, mq.ErrRequestTimeout},
+	}
+
+	for i, l := range tbl {
+		c, nc, done := connect(t, func(c *Client) {
+			c.RequestTimeout = 50 * time.Millisecond
+			c.RequestPolicies = []RequestPolicy{{Pattern: "test.>", Retries: l.Retries, Backoff: 10}}
+		})
+		var count int32
+		nc.Subscribe(l.Subject, func(m *nats.Msg) {
+			// Only respond to the third request
+			if atomic.AddInt32(&count, 1) == 3 {
+				m.Respond([]byte(`{"result":null}`))
+			}
+		})
+		nc.Flush()
+
+		r := awaitResponse(t, sendRequest(c, l.Subject, []byte(`{}`)))
+		done()
+		if r.err != l.Expected {
+			t.Errorf("test #%d: expected error %v, but got %v", i+1, l.Expected, r.err)
+		}
+		if n := atomic.LoadInt32(&count); n != l.ExpectedRequests {
+			t.Errorf("test #%d: expected %d requests, but got %d", i+1, l.ExpectedRequests, n)
+		}
+	}
+}
+
+// Test that connecting with an invalid request policy returns an error
+func TestRequestPolicy_InvalidPolicy_ReturnsError(t *testing.T) {
+	tbl := []RequestPolicy{
+		{Pattern: "test.>.foo"},
+		{Types: []string{"new"}},
+		{Timeout: -1},
+		{Retries: -1},
+	}
+
+	ns := runServer(t, -1)
+	defer ns.Shutdown()
+	for i, l := range tbl {
+		c := &Client{URL: serverURL(ns), RequestPolicies: []RequestPolicy{l}, Logger: logger.NewMemLogger(false, false)}
+		if err := c.Connect(); err == nil {
+			c.Close()
+			t.Errorf("test #%d: expected error, but got none", i+1)
+		}
+	}
+}
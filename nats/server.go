@@ -0,0 +1,193 @@
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	server "github.com/nats-io/nats-server/v2/server"
+)
+
+const (
+	// serverReadyTimeout is the duration to wait for an embedded server to
+	// accept client connections.
+	serverReadyTimeout = 10 * time.Second
+
+	// defaultClusterPort is the default port for route connections.
+	defaultClusterPort = 6222
+)
+
+// ServerConfig holds the settings for an embedded NATS server, started
+// in-process by the client on Connect.
+type ServerConfig struct {
+	// Host address to listen on for client connections.
+	// Defaults to 0.0.0.0.
+	Host string `json:"host"`
+	// Port to listen on for client connections. Defaults to 4222.
+	// Use -1 for a random port.
+	Port int `json:"port"`
+	// Username required by clients, including the resgate connection.
+	Username *string `json:"username"`
+	// Password required by clients, including the resgate connection.
+	Password *string `json:"password"`
+	// Token required by clients, including the resgate connection.
+	// Cannot be used together with username and password.
+	Token *string `json:"token"`
+	// Cluster settings. Missing value or null disables clustering.
+	Cluster *ClusterConfig `json:"cluster"`
+}
+
+// ClusterConfig holds the cluster settings for an embedded NATS server.
+type ClusterConfig struct {
+	// Host address to listen on for route connections.
+	// Defaults to 0.0.0.0.
+	Host string `json:"host"`
+	// Port to listen on for route connections. Defaults to 6222.
+	// Use -1 for a random port.
+	Port int `json:"port"`
+	// Username required by route connections.
+	Username *string `json:"username"`
+	// Password required by route connections.
+	Password *string `json:"password"`
+	// Routes to other cluster servers.
+	// Eg. "nats-route://10.0.0.2:6222"
+	Routes []string `json:"routes"`
+}
+
+// serverLogger implements the nats-server logger interface, writing log
+// messages to the client logger.
+type serverLogger struct {
+	c *Client
+}
+
+func (l serverLogger) Noticef(format string, v ...interface{}) {
+	l.c.Logf("[NATS] "+format, v...)
+}
+
+func (l serverLogger) Warnf(format string, v ...interface{}) {
+	l.c.Logf("[NATS] "+format, v...)
+}
+
+func (l serverLogger) Fatalf(format string, v ...interface{}) {
+	l.c.Logger.Error(fmt.Sprintf("[NATS] "+format, v...))
+}
+
+func (l serverLogger) Errorf(format string, v ...interface{}) {
+	l.c.Logger.Error(fmt.Sprintf("[NATS] "+format, v...))
+}
+
+func (l serverLogger) Debugf(format string, v ...interface{}) {
+	l.c.Debugf("[NATS] "+format, v...)
+}
+
+func (l serverLogger) Tracef(format string, v ...interface{}) {
+	l.c.Tracef("[NATS] "+format, v...)
+}
+
+// options returns the nats-server options for the embedded server.
+func (sc *ServerConfig) options() (*server.Options, error) {
+	if sc.Token != nil && (sc.Username != nil || sc.Password != nil) {
+		return nil, errors.New("token cannot be combined with username and password")
+	}
+	if (sc.Username == nil) != (sc.Password == nil) {
+		return nil, errors.New("username and password must be set together")
+	}
+
+	opts := &server.Options{
+		Host:   sc.Host,
+		Port:   sc.Port,
+		NoSigs: true,
+	}
+	if sc.Username != nil {
+		opts.Username = *sc.Username
+		opts.Password = *sc.Password
+	}
+	if sc.Token != nil {
+		opts.Authorization = *sc.Token
+	}
+
+	if cc := sc.Cluster; cc != nil {
+		if (cc.Username == nil) != (cc.Password == nil) {
+			return nil, errors.New("cluster username and password must be set together")
+		}
+		opts.Cluster = server.ClusterOpts{
+			Host: cc.Host,
+			Port: cc.Port,
+		}
+		if opts.Cluster.Host == "" {
+			opts.Cluster.Host = server.DEFAULT_HOST
+		}
+		if opts.Cluster.Port == 0 {
+			opts.Cluster.Port = defaultClusterPort
+		}
+		if cc.Username != nil {
+			opts.Cluster.Username = *cc.Username
+			opts.Cluster.Password = *cc.Password
+		}
+		for _, r := range cc.Routes {
+			u, err := url.Parse(r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cluster route %s: %s", strconv.Quote(r), err)
+			}
+			opts.Routes = append(opts.Routes, u)
+		}
+	}
+
+	return opts, nil
+}
+
+// startServer starts the embedded NATS server, and returns the URL to use
+// for connecting to it.
+func (c *Client) startServer() (string, error) {
+	opts, err := c.Server.options()
+	if err != nil {
+		return "", fmt.Errorf("invalid embedded NATS server config: %s", err)
+	}
+
+	ns, err := server.NewServer(opts)
+	if err != nil {
+		return "", err
+	}
+	ns.SetLogger(serverLogger{c: c}, c.Logger.IsDebug(), false)
+
+	c.Logf("Starting embedded NATS server")
+	go ns.Start()
+
+	if !ns.ReadyForConnections(serverReadyTimeout) {
+		ns.Shutdown()
+		return "", errors.New("embedded NATS server not ready for connections")
+	}
+	c.ns = ns
+
+	// Connect through the loopback interface when listening on all interfaces.
+	addr := ns.Addr().(*net.TCPAddr)
+	host := addr.IP.String()
+	if addr.IP.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	c.nsURL = "nats://" + net.JoinHostPort(host, strconv.Itoa(addr.Port))
+	return c.nsURL, nil
+}
+
+// ServerURL returns the URL for connecting to the embedded NATS server, or
+// an empty string if no embedded server is running.
+func (c *Client) ServerURL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nsURL
+}
+
+// stopServer shuts down the embedded NATS server, if started.
+func (c *Client) stopServer() {
+	if c.ns == nil {
+		return
+	}
+	c.Debugf("Shutting down embedded NATS server...")
+	c.ns.Shutdown()
+	c.ns = nil
+	c.nsURL = ""
+	c.Debugf("Embedded NATS server shut down")
+}
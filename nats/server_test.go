@@ -0,0 +1,113 @@
+package nats
+
+import (
+	"testing"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/resgateio/resgate/logger"
+)
+
+// startServer starts an embedded NATS server on a random port, and returns
+// its URL together with a function to shut it down.
+func startServer(t *testing.T, sc *ServerConfig) (string, func()) {
+	sc.Host = "127.0.0.1"
+	sc.Port = -1
+	c := &Client{RequestTimeout: time.Second, Server: sc, Logger: logger.NewMemLogger(false, false)}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("error starting NATS server: %s", err)
+	}
+	return c.ServerURL(), c.Close
+}
+
+// Test that services connect over TCP to the embedded NATS server, and that
+// requests are sent to them.
+func TestServer_ServiceOverTCP_ReceivesRequests(t *testing.T) {
+	token := "secret"
+	c := &Client{
+		RequestTimeout: time.Second,
+		Server:         &ServerConfig{Host: "127.0.0.1", Port: -1, Token: &token},
+		Logger:         logger.NewMemLogger(false, false),
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer c.Close()
+
+	nc, err := nats.Connect(c.ServerURL(), nats.Token(token))
+	if err != nil {
+		t.Fatalf("error connecting service to embedded NATS server: %s", err)
+	}
+	defer nc.Close()
+	nc.Subscribe("get.test.model", func(m *nats.Msg) {
+		m.Respond([]byte(`{"result":{"model":{"foo":"bar"}}}`))
+	})
+	nc.Flush()
+
+	r := awaitResponse(t, sendRequest(c, "get.test.model", []byte(`{}`)))
+	if r.err != nil {
+		t.Fatalf("expected no error, but got: %s", r.err)
+	}
+	if string(r.payload) != `{"result":{"model":{"foo":"bar"}}}` {
+		t.Fatalf("expected response payload %s, but got %s", `{"result":{"model":{"foo":"bar"}}}`, r.payload)
+	}
+}
+
+// Test that the embedded NATS server requires configured authentication.
+func TestServer_WithoutCredentials_RejectsConnection(t *testing.T) {
+	username := "foo"
+	password := "bar"
+	u, stop := startServer(t, &ServerConfig{Username: &username, Password: &password})
+	defer stop()
+
+	if nc, err := nats.Connect(u); err == nil {
+		nc.Close()
+		t.Fatalf("expected connection without credentials to fail")
+	}
+	nc, err := nats.Connect(u, nats.UserInfo(username, password))
+	if err != nil {
+		t.Fatalf("error connecting with credentials: %s", err)
+	}
+	nc.Close()
+}
+
+// Test that the embedded NATS server is shut down when the client is closed.
+func TestServer_OnClose_ShutsDownServer(t *testing.T) {
+	c := &Client{
+		RequestTimeout: time.Second,
+		Server:         &ServerConfig{Host: "127.0.0.1", Port: -1},
+		Logger:         logger.NewMemLogger(false, false),
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	u := c.ServerURL()
+	c.Close()
+
+	if c.ServerURL() != "" {
+		t.Errorf("expected empty server URL after close, but got %s", c.ServerURL())
+	}
+	if nc, err := nats.Connect(u); err == nil {
+		nc.Close()
+		t.Fatalf("expected connection to stopped server to fail")
+	}
+}
+
+// Test that invalid embedded NATS server settings fail on connect.
+func TestServer_InvalidConfig_FailsOnConnect(t *testing.T) {
+	username := "foo"
+	token := "secret"
+	tbl := []*ServerConfig{
+		{Port: -1, Username: &username},
+		{Port: -1, Username: &username, Password: &username, Token: &token},
+		{Port: -1, Cluster: &ClusterConfig{Port: -1, Password: &token}},
+	}
+
+	for i, sc := range tbl {
+		c := &Client{RequestTimeout: time.Second, Server: sc, Logger: logger.NewMemLogger(false, false)}
+		if err := c.Connect(); err == nil {
+			c.Close()
+			t.Errorf("#%d: expected connect to fail", i+1)
+		}
+	}
+}
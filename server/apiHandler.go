@@ -78,6 +78,19 @@ func (s *Service) apiHandler(w http.ResponseWriter, r *http.Request) {
 
 	apiPath := s.cfg.APIPath
 
+	// OpenAPI document. The path cannot conflict with any resource as dots
+	// are not allowed in resource paths.
+	if s.schema != nil && path == apiPath+OpenAPIFile && (r.Method == "GET" || r.Method == "HEAD") {
+		out, err := OpenAPI(s.schema, s.cfg)
+		if err != nil {
+			httpError(w, err, s.enc)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(out)
+		return
+	}
+
 	// NotFound on oaths with trailing slash (unless it is only the APIPath)
 	if len(path) > len(apiPath) && path[len(path)-1] == '/' {
 		notFoundHandler(w, r, s.enc)
@@ -71,6 +71,7 @@ type GetResult struct {
 	Model      map[string]Value `json:"model"`
 	Collection []Value          `json:"collection"`
 	Query      string           `json:"query"`
+	Refresh    int              `json:"refresh"` // Milliseconds
 }
 
 // AuthRequest represents a RES-service auth request
@@ -98,6 +99,7 @@ type Resource struct {
 // QueryEvent represents a RES-service query event
 type QueryEvent struct {
 	Subject string `json:"subject"`
+	Batch   bool   `json:"batch"`
 }
 
 // EventQueryRequest represents a RES-service query request
@@ -121,6 +123,32 @@ type EventQueryResult struct {
 	Collection []Value            `json:"collection"`
 }
 
+// EventQueryBatchRequest represents a RES-service batch query request
+// https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#batch-query-request
+type EventQueryBatchRequest struct {
+	Queries []string `json:"queries"`
+}
+
+// EventQueryBatchResponse represent the response of a RES-service batch query
+// request
+type EventQueryBatchResponse struct {
+	Result *EventQueryBatchResult `json:"result"`
+	Error  *reserr.Error          `json:"error"`
+}
+
+// EventQueryBatchResult represent the response's result part of a
+// RES-service batch query request
+type EventQueryBatchResult struct {
+	Queries map[string]*EventQueryBatchItem `json:"queries"`
+}
+
+// EventQueryBatchItem represents the result, or error, for a single query in
+// the response of a RES-service batch query request
+type EventQueryBatchItem struct {
+	EventQueryResult
+	Error *reserr.Error `json:"error"`
+}
+
 // EventQueryEvent represents an event in the response of a RES-server query request
 type EventQueryEvent struct {
 	Event string          `json:"event"`
@@ -152,6 +180,13 @@ type RemoveEvent struct {
 	Idx int `json:"idx"`
 }
 
+// MoveEvent represent a RES-server collection move event
+// https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#collection-move-event
+type MoveEvent struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
 // SystemReset represents a RES-server system reset event
 // https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#system-reset-event
 type SystemReset struct {
@@ -426,33 +461,81 @@ func DecodeEventQueryResponse(payload []byte) (*EventQueryResult, error) {
 		return nil, errMissingResult
 	}
 
-	// Assert we got either a model or a collection
-	res := r.Result
+	if err := validateEventQueryResult(r.Result); err != nil {
+		return nil, err
+	}
+	return r.Result, nil
+}
+
+// CreateEventQueryBatchRequest creates a JSON encoded RES-service batch query
+// request
+func CreateEventQueryBatchRequest(queries []string) []byte {
+	out, _ := json.Marshal(EventQueryBatchRequest{Queries: queries})
+	return out
+}
+
+// DecodeEventQueryBatchResponse decodes a JSON encoded RES-service batch query
+// response
+func DecodeEventQueryBatchResponse(payload []byte) (*EventQueryBatchResult, error) {
+	var r EventQueryBatchResponse
+	err := json.Unmarshal(payload, &r)
+	if err != nil {
+		return nil, reserr.RESError(err)
+	}
+
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	if r.Result == nil {
+		return nil, errMissingResult
+	}
+
+	for _, item := range r.Result.Queries {
+		if item == nil {
+			return nil, errInvalidResponse
+		}
+		if item.Error != nil {
+			if item.Events != nil || item.Model != nil || item.Collection != nil {
+				return nil, errInvalidResponse
+			}
+			continue
+		}
+		if err := validateEventQueryResult(&item.EventQueryResult); err != nil {
+			return nil, err
+		}
+	}
+	return r.Result, nil
+}
+
+// validateEventQueryResult asserts the result has either events, a model, or
+// a collection, with proper values.
+func validateEventQueryResult(res *EventQueryResult) error {
 	switch {
 	case res.Events != nil:
 		if res.Model != nil || res.Collection != nil {
-			return nil, errInvalidResponse
+			return errInvalidResponse
 		}
 	case res.Model != nil:
 		if res.Collection != nil {
-			return nil, errInvalidResponse
+			return errInvalidResponse
 		}
 		// Assert model only has proper values
 		for _, v := range res.Model {
 			if !v.IsProper() {
-				return nil, errInvalidResponse
+				return errInvalidResponse
 			}
 		}
 	case res.Collection != nil:
 		// Assert collection only has proper values
 		for _, v := range res.Collection {
 			if !v.IsProper() {
-				return nil, errInvalidResponse
+				return errInvalidResponse
 			}
 		}
 	}
 
-	return res, nil
+	return nil
 }
 
 // IsLegacyChangeEvent returns true if the model change event is detected as v1.0 legacy
@@ -549,6 +632,23 @@ func DecodeRemoveEvent(data json.RawMessage) (*RemoveEvent, error) {
 	return &d, nil
 }
 
+// EncodeMoveEvent creates a JSON encoded RES-service collection move event
+func EncodeMoveEvent(d *MoveEvent) json.RawMessage {
+	data, _ := json.Marshal(d)
+	return json.RawMessage(data)
+}
+
+// DecodeMoveEvent decodes a JSON encoded RES-service collection move event
+func DecodeMoveEvent(data json.RawMessage) (*MoveEvent, error) {
+	var d MoveEvent
+	err := json.Unmarshal(data, &d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
 // DecodeAccessResponse decodes a JSON encoded RES-service access response
 func DecodeAccessResponse(payload []byte) (*AccessResult, *reserr.Error) {
 	var r AccessResponse
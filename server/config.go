@@ -7,23 +7,30 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
 )
 
 // Config holds server configuration
 type Config struct {
-	Addr         *string `json:"addr"`
-	Port         uint16  `json:"port"`
-	WSPath       string  `json:"wsPath"`
-	APIPath      string  `json:"apiPath"`
-	APIEncoding  string  `json:"apiEncoding"`
-	HeaderAuth   *string `json:"headerAuth"`
-	AllowOrigin  *string `json:"allowOrigin"`
-	PUTMethod    *string `json:"putMethod"`
-	DELETEMethod *string `json:"deleteMethod"`
-	PATCHMethod  *string `json:"patchMethod"`
+	Addr           *string `json:"addr"`
+	Port           uint16  `json:"port"`
+	WSPath         string  `json:"wsPath"`
+	APIPath        string  `json:"apiPath"`
+	APIEncoding    string  `json:"apiEncoding"`
+	HeaderAuth     *string `json:"headerAuth"`
+	WSHeaderAuth   bool    `json:"wsHeaderAuth"`
+	WSRequireToken bool    `json:"wsRequireToken"`
+	AllowOrigin    *string `json:"allowOrigin"`
+	PUTMethod      *string `json:"putMethod"`
+	DELETEMethod   *string `json:"deleteMethod"`
+	PATCHMethod    *string `json:"patchMethod"`
+	GraphQLPath    *string `json:"graphqlPath"`
+	StatusPath     *string `json:"statusPath"`
+	Schema         *string `json:"schema"`
 
 	TLS     bool   `json:"tls"`
 	TLSCert string `json:"certFile"`
@@ -31,14 +38,91 @@ type Config struct {
 
 	WSCompression bool `json:"wsCompression"`
 
+	Bulkheads           []BulkheadConfig           `json:"bulkheads"`
+	CacheMaxSize        int64                      `json:"cacheMaxSize"`
+	CacheMaxQueries     int                        `json:"cacheMaxQueries"`
+	CacheWorkers        int                        `json:"cacheWorkers"`
+	UnsubscribeDelay    *int                       `json:"unsubscribeDelay"` // Milliseconds
+	UnsubscribeDelays   []UnsubscribeDelayConfig   `json:"unsubscribeDelays"`
+	RefreshIntervals    []RefreshIntervalConfig    `json:"refreshIntervals"`
+	PinnedResources     []string                   `json:"pinnedResources"`
+	PinnedRetry         *PinnedRetryConfig         `json:"pinnedRetry"`
+	StaleIfError        *StaleIfErrorConfig        `json:"staleIfError"`
+	GetRetry            *GetRetryConfig            `json:"getRetry"`
+	QueryNormalizations []QueryNormalizationConfig `json:"queryNormalizations"`
+
 	NoHTTP bool `json:"-"` // Disable start of the HTTP server. Used for testing
 
-	scheme           string
-	netAddr          string
-	headerAuthRID    string
-	headerAuthAction string
-	allowOrigin      []string
-	allowMethods     string
+	scheme              string
+	netAddr             string
+	headerAuthRID       string
+	headerAuthAction    string
+	allowOrigin         []string
+	allowMethods        string
+	bulkheads           []rescache.Bulkhead
+	unsubscribeDelay    time.Duration
+	delays              []rescache.UnsubscribeDelay
+	refreshIntervals    []rescache.RefreshInterval
+	pinRIDs             []string
+	pinPatterns         []rescache.ResourcePattern
+	pinRetry            *rescache.PinRetry
+	staleIfError        *rescache.StaleIfError
+	getRetry            *rescache.GetRetry
+	queryNormalizations []rescache.QueryNormalization
+}
+
+// UnsubscribeDelayConfig holds the unsubscribe delay for resources matching a
+// pattern.
+type UnsubscribeDelayConfig struct {
+	Pattern string `json:"pattern"`
+	Delay   int    `json:"delay"` // Milliseconds
+}
+
+// RefreshIntervalConfig holds the interval for refreshing resources matching a
+// pattern, for services unable to send events.
+type RefreshIntervalConfig struct {
+	Pattern  string `json:"pattern"`
+	Interval int    `json:"interval"` // Milliseconds
+}
+
+// PinnedRetryConfig holds the settings for retrying pinned resources failing
+// to load.
+type PinnedRetryConfig struct {
+	Backoff    int `json:"backoff"`    // Milliseconds
+	MaxBackoff int `json:"maxBackoff"` // Milliseconds
+}
+
+// StaleIfErrorConfig holds the settings for serving stale cached resources
+// while retrying failed reset get requests.
+type StaleIfErrorConfig struct {
+	MaxStale   int `json:"maxStale"`   // Milliseconds
+	Backoff    int `json:"backoff"`    // Milliseconds
+	MaxBackoff int `json:"maxBackoff"` // Milliseconds
+}
+
+// GetRetryConfig holds the settings for retrying get requests failing with a
+// temporary error.
+type GetRetryConfig struct {
+	Retries    int `json:"retries"`
+	Backoff    int `json:"backoff"`    // Milliseconds
+	MaxBackoff int `json:"maxBackoff"` // Milliseconds
+}
+
+// QueryNormalizationConfig holds the settings for normalizing the queries of
+// resources matching a pattern.
+type QueryNormalizationConfig struct {
+	Pattern       string `json:"pattern"`
+	SortParams    bool   `json:"sortParams"`
+	RemoveEmpty   bool   `json:"removeEmpty"`
+	LowercaseKeys bool   `json:"lowercaseKeys"`
+}
+
+// BulkheadConfig holds the limit of concurrent outstanding requests for
+// resources matching a pattern.
+type BulkheadConfig struct {
+	Pattern     string `json:"pattern"`
+	MaxRequests int    `json:"maxRequests"`
+	MaxWait     int    `json:"maxWait"` // Milliseconds
 }
 
 // SetDefault sets the default values
@@ -63,6 +147,13 @@ func (c *Config) SetDefault() {
 		origin := "*"
 		c.AllowOrigin = &origin
 	}
+	if c.CacheWorkers == 0 {
+		c.CacheWorkers = CacheWorkers
+	}
+	if c.UnsubscribeDelay == nil {
+		delay := int(UnsubscribeDelay / time.Millisecond)
+		c.UnsubscribeDelay = &delay
+	}
 }
 
 // prepare sets the unexported values
@@ -112,6 +203,13 @@ func (c *Config) prepare() error {
 		}
 	}
 
+	if c.WSHeaderAuth && c.HeaderAuth == nil {
+		return errors.New("invalid wsHeaderAuth setting\n\trequires headerAuth to be set")
+	}
+	if c.WSRequireToken && !c.WSHeaderAuth {
+		return errors.New("invalid wsRequireToken setting\n\trequires wsHeaderAuth to be enabled")
+	}
+
 	if c.AllowOrigin != nil {
 		c.allowOrigin = strings.Split(*c.AllowOrigin, ";")
 		if err := validateAllowOrigin(c.allowOrigin); err != nil {
@@ -142,6 +240,185 @@ func (c *Config) prepare() error {
 		c.allowMethods += ", PATCH"
 	}
 
+	if c.GraphQLPath != nil {
+		if !strings.HasPrefix(*c.GraphQLPath, "/") {
+			return fmt.Errorf("invalid graphqlPath setting (%s)\n\tmust start with /", *c.GraphQLPath)
+		}
+	}
+
+	if c.StatusPath != nil {
+		if !strings.HasPrefix(*c.StatusPath, "/") {
+			return fmt.Errorf("invalid statusPath setting (%s)\n\tmust start with /", *c.StatusPath)
+		}
+	}
+
+	if c.CacheMaxSize < 0 {
+		return fmt.Errorf("invalid cacheMaxSize setting (%d)\n\tmust not be negative", c.CacheMaxSize)
+	}
+
+	if c.CacheMaxQueries < 0 {
+		return fmt.Errorf("invalid cacheMaxQueries setting (%d)\n\tmust not be negative", c.CacheMaxQueries)
+	}
+
+	if c.CacheWorkers < 0 {
+		return fmt.Errorf("invalid cacheWorkers setting (%d)\n\tmust not be negative", c.CacheWorkers)
+	}
+	c.unsubscribeDelay = UnsubscribeDelay
+	if c.UnsubscribeDelay != nil {
+		if *c.UnsubscribeDelay < 0 {
+			return fmt.Errorf("invalid unsubscribeDelay setting (%d)\n\tmust not be negative", *c.UnsubscribeDelay)
+		}
+		c.unsubscribeDelay = time.Duration(*c.UnsubscribeDelay) * time.Millisecond
+	}
+	c.delays = make([]rescache.UnsubscribeDelay, len(c.UnsubscribeDelays))
+	for i, d := range c.UnsubscribeDelays {
+		p := rescache.ParseResourcePattern(d.Pattern)
+		if !p.IsValid() {
+			return fmt.Errorf("invalid unsubscribeDelays setting (%s)\n\tmust be a valid resource pattern", d.Pattern)
+		}
+		if d.Delay < 0 {
+			return fmt.Errorf("invalid unsubscribeDelays setting for %s (delay: %d)\n\tmust not be negative", d.Pattern, d.Delay)
+		}
+		c.delays[i] = rescache.UnsubscribeDelay{
+			Pattern: p,
+			Delay:   time.Duration(d.Delay) * time.Millisecond,
+		}
+	}
+
+	c.pinRIDs = nil
+	c.pinPatterns = nil
+	for _, rid := range c.PinnedResources {
+		if codec.IsValidRID(rid, true) {
+			c.pinRIDs = append(c.pinRIDs, rid)
+			continue
+		}
+		p := rescache.ParseResourcePattern(rid)
+		if !p.IsValid() {
+			return fmt.Errorf("invalid pinnedResources setting (%s)\n\tmust be a valid resource ID or resource pattern", rid)
+		}
+		c.pinPatterns = append(c.pinPatterns, p)
+	}
+
+	backoff, maxBackoff := DefaultPinnedBackoff, DefaultPinnedMaxBackoff
+	if r := c.PinnedRetry; r != nil {
+		if r.Backoff != 0 {
+			backoff = r.Backoff
+		}
+		if r.MaxBackoff != 0 {
+			maxBackoff = r.MaxBackoff
+		}
+		if backoff < 0 {
+			return fmt.Errorf("invalid pinnedRetry setting (backoff: %d)\n\tmust not be negative", r.Backoff)
+		}
+		if maxBackoff < backoff {
+			return fmt.Errorf("invalid pinnedRetry setting (maxBackoff: %d)\n\tmust not be less than backoff", r.MaxBackoff)
+		}
+	}
+	c.pinRetry = &rescache.PinRetry{
+		Backoff:    time.Duration(backoff) * time.Millisecond,
+		MaxBackoff: time.Duration(maxBackoff) * time.Millisecond,
+	}
+
+	c.staleIfError = nil
+	if s := c.StaleIfError; s != nil {
+		if s.MaxStale <= 0 {
+			return fmt.Errorf("invalid staleIfError setting (maxStale: %d)\n\tmust be greater than 0", s.MaxStale)
+		}
+		backoff := s.Backoff
+		if backoff == 0 {
+			backoff = DefaultStaleBackoff
+		}
+		maxBackoff := s.MaxBackoff
+		if maxBackoff == 0 {
+			maxBackoff = DefaultStaleMaxBackoff
+		}
+		if backoff < 0 {
+			return fmt.Errorf("invalid staleIfError setting (backoff: %d)\n\tmust not be negative", s.Backoff)
+		}
+		if maxBackoff < backoff {
+			return fmt.Errorf("invalid staleIfError setting (maxBackoff: %d)\n\tmust not be less than backoff", s.MaxBackoff)
+		}
+		c.staleIfError = &rescache.StaleIfError{
+			MaxStale:   time.Duration(s.MaxStale) * time.Millisecond,
+			Backoff:    time.Duration(backoff) * time.Millisecond,
+			MaxBackoff: time.Duration(maxBackoff) * time.Millisecond,
+		}
+	}
+
+	c.getRetry = nil
+	if r := c.GetRetry; r != nil {
+		if r.Retries <= 0 {
+			return fmt.Errorf("invalid getRetry setting (retries: %d)\n\tmust be greater than 0", r.Retries)
+		}
+		backoff := r.Backoff
+		if backoff == 0 {
+			backoff = DefaultGetRetryBackoff
+		}
+		maxBackoff := r.MaxBackoff
+		if maxBackoff == 0 {
+			maxBackoff = DefaultGetRetryMaxBackoff
+		}
+		if backoff < 0 {
+			return fmt.Errorf("invalid getRetry setting (backoff: %d)\n\tmust not be negative", r.Backoff)
+		}
+		if maxBackoff < backoff {
+			return fmt.Errorf("invalid getRetry setting (maxBackoff: %d)\n\tmust not be less than backoff", r.MaxBackoff)
+		}
+		c.getRetry = &rescache.GetRetry{
+			Retries:    r.Retries,
+			Backoff:    time.Duration(backoff) * time.Millisecond,
+			MaxBackoff: time.Duration(maxBackoff) * time.Millisecond,
+		}
+	}
+
+	c.queryNormalizations = make([]rescache.QueryNormalization, len(c.QueryNormalizations))
+	for i, qn := range c.QueryNormalizations {
+		p := rescache.ParseResourcePattern(qn.Pattern)
+		if !p.IsValid() {
+			return fmt.Errorf("invalid queryNormalizations setting (%s)\n\tmust be a valid resource pattern", qn.Pattern)
+		}
+		c.queryNormalizations[i] = rescache.QueryNormalization{
+			Pattern:       p,
+			SortParams:    qn.SortParams,
+			RemoveEmpty:   qn.RemoveEmpty,
+			LowercaseKeys: qn.LowercaseKeys,
+		}
+	}
+
+	c.refreshIntervals = make([]rescache.RefreshInterval, len(c.RefreshIntervals))
+	for i, ri := range c.RefreshIntervals {
+		p := rescache.ParseResourcePattern(ri.Pattern)
+		if !p.IsValid() {
+			return fmt.Errorf("invalid refreshIntervals setting (%s)\n\tmust be a valid resource pattern", ri.Pattern)
+		}
+		if ri.Interval <= 0 {
+			return fmt.Errorf("invalid refreshIntervals setting for %s (interval: %d)\n\tmust be greater than 0", ri.Pattern, ri.Interval)
+		}
+		c.refreshIntervals[i] = rescache.RefreshInterval{
+			Pattern:  p,
+			Interval: time.Duration(ri.Interval) * time.Millisecond,
+		}
+	}
+
+	c.bulkheads = make([]rescache.Bulkhead, len(c.Bulkheads))
+	for i, b := range c.Bulkheads {
+		p := rescache.ParseResourcePattern(b.Pattern)
+		if !p.IsValid() {
+			return fmt.Errorf("invalid bulkheads setting (%s)\n\tmust be a valid resource pattern", b.Pattern)
+		}
+		if b.MaxRequests <= 0 {
+			return fmt.Errorf("invalid bulkheads setting for %s (maxRequests: %d)\n\tmust be greater than 0", b.Pattern, b.MaxRequests)
+		}
+		if b.MaxWait < 0 {
+			return fmt.Errorf("invalid bulkheads setting for %s (maxWait: %d)\n\tmust not be negative", b.Pattern, b.MaxWait)
+		}
+		c.bulkheads[i] = rescache.Bulkhead{
+			Pattern:     p,
+			MaxRequests: b.MaxRequests,
+			MaxWait:     time.Duration(b.MaxWait) * time.Millisecond,
+		}
+	}
+
 	if c.WSPath == "" {
 		c.WSPath = "/"
 	}
@@ -149,6 +426,27 @@ func (c *Config) prepare() error {
 		c.APIPath = c.APIPath + "/"
 	}
 
+	if c.GraphQLPath != nil {
+		if *c.GraphQLPath == c.WSPath {
+			return fmt.Errorf("invalid graphqlPath setting (%s)\n\tmust not be the same as wsPath", *c.GraphQLPath)
+		}
+		if strings.HasPrefix(*c.GraphQLPath, c.APIPath) {
+			return fmt.Errorf("invalid graphqlPath setting (%s)\n\tmust not be within apiPath (%s)", *c.GraphQLPath, c.APIPath)
+		}
+	}
+
+	if c.StatusPath != nil {
+		if *c.StatusPath == c.WSPath {
+			return fmt.Errorf("invalid statusPath setting (%s)\n\tmust not be the same as wsPath", *c.StatusPath)
+		}
+		if c.GraphQLPath != nil && *c.StatusPath == *c.GraphQLPath {
+			return fmt.Errorf("invalid statusPath setting (%s)\n\tmust not be the same as graphqlPath", *c.StatusPath)
+		}
+		if strings.HasPrefix(*c.StatusPath, c.APIPath) {
+			return fmt.Errorf("invalid statusPath setting (%s)\n\tmust not be within apiPath (%s)", *c.StatusPath, c.APIPath)
+		}
+	}
+
 	return nil
 }
 
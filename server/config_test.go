@@ -32,6 +32,7 @@ func TestConfigPrepare(t *testing.T) {
 	ipv6Addr := "::1"
 	invalidAddr := "127.0.0"
 	invalidHeaderAuth := "test"
+	headerAuth := "test.method"
 	allowOriginAll := "*"
 	allowOriginSingle := "http://resgate.io"
 	allowOriginMultiple := "http://localhost;http://resgate.io"
@@ -42,6 +43,14 @@ func TestConfigPrepare(t *testing.T) {
 	allowOriginInvalidOrigin := "http://this.is/invalid"
 	method := "foo"
 	invalidMethod := "foo.bar"
+	negativeDelay := -1
+	graphqlPath := "/graphql"
+	graphqlPathInvalid := "graphql"
+	graphqlPathWS := "/ws"
+	graphqlPathAPI := "/api/graphql"
+	statusPath := "/status"
+	statusPathInvalid := "status"
+	statusPathAPI := "/api/status"
 	defaultCfg := Config{}
 	defaultCfg.SetDefault()
 
@@ -51,6 +60,8 @@ func TestConfigPrepare(t *testing.T) {
 		PrepareError bool
 	}{
 		// Valid config
+		{Config{GraphQLPath: &graphqlPath, WSPath: "/", APIPath: "/api"}, Config{Addr: nil, Port: 80, WSPath: "/", APIPath: "/api/", GraphQLPath: &graphqlPath, scheme: "http", netAddr: "0.0.0.0:80", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST"}, false},
+		{Config{StatusPath: &statusPath, WSPath: "/", APIPath: "/api"}, Config{Addr: nil, Port: 80, WSPath: "/", APIPath: "/api/", StatusPath: &statusPath, scheme: "http", netAddr: "0.0.0.0:80", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST"}, false},
 		{defaultCfg, Config{Addr: &defaultAddr, Port: 8080, WSPath: "/", APIPath: "/api/", APIEncoding: "json", scheme: "http", netAddr: "0.0.0.0:8080", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST"}, false},
 		{Config{WSPath: "/"}, Config{Addr: nil, Port: 80, WSPath: "/", APIPath: "/", scheme: "http", netAddr: "0.0.0.0:80", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST"}, false},
 		{Config{Addr: &emptyAddr, WSPath: "/"}, Config{Addr: &emptyAddr, Port: 80, WSPath: "/", APIPath: "/", scheme: "http", netAddr: ":80", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST"}, false},
@@ -76,6 +87,39 @@ func TestConfigPrepare(t *testing.T) {
 		{Config{PUTMethod: &invalidMethod, WSPath: "/"}, Config{}, true},
 		{Config{DELETEMethod: &invalidMethod, WSPath: "/"}, Config{}, true},
 		{Config{PATCHMethod: &invalidMethod, WSPath: "/"}, Config{}, true},
+		{Config{WSHeaderAuth: true, WSPath: "/"}, Config{}, true},
+		{Config{WSRequireToken: true, WSPath: "/"}, Config{}, true},
+		{Config{HeaderAuth: &headerAuth, WSRequireToken: true, WSPath: "/"}, Config{}, true},
+		{Config{Bulkheads: []BulkheadConfig{{Pattern: "test.>.foo", MaxRequests: 1}}, WSPath: "/"}, Config{}, true},
+		{Config{Bulkheads: []BulkheadConfig{{Pattern: "test.>", MaxRequests: 0}}, WSPath: "/"}, Config{}, true},
+		{Config{Bulkheads: []BulkheadConfig{{Pattern: "test.>", MaxRequests: 1, MaxWait: -1}}, WSPath: "/"}, Config{}, true},
+		{Config{GraphQLPath: &graphqlPathInvalid, WSPath: "/"}, Config{}, true},
+		{Config{GraphQLPath: &graphqlPathWS, WSPath: "/ws", APIPath: "/api"}, Config{}, true},
+		{Config{GraphQLPath: &graphqlPathAPI, WSPath: "/", APIPath: "/api"}, Config{}, true},
+		{Config{GraphQLPath: &graphqlPath, WSPath: "/"}, Config{}, true},
+		{Config{StatusPath: &statusPathInvalid, WSPath: "/"}, Config{}, true},
+		{Config{StatusPath: &statusPath, WSPath: "/status", APIPath: "/api"}, Config{}, true},
+		{Config{StatusPath: &graphqlPath, GraphQLPath: &graphqlPath, WSPath: "/", APIPath: "/api"}, Config{}, true},
+		{Config{StatusPath: &statusPathAPI, WSPath: "/", APIPath: "/api"}, Config{}, true},
+		{Config{CacheMaxSize: -1, WSPath: "/"}, Config{}, true},
+		{Config{CacheMaxQueries: -1, WSPath: "/"}, Config{}, true},
+		{Config{CacheWorkers: -1, WSPath: "/"}, Config{}, true},
+		{Config{UnsubscribeDelay: &negativeDelay, WSPath: "/"}, Config{}, true},
+		{Config{UnsubscribeDelays: []UnsubscribeDelayConfig{{Pattern: "test.>.foo", Delay: 1000}}, WSPath: "/"}, Config{}, true},
+		{Config{UnsubscribeDelays: []UnsubscribeDelayConfig{{Pattern: "test.>", Delay: -1}}, WSPath: "/"}, Config{}, true},
+		{Config{RefreshIntervals: []RefreshIntervalConfig{{Pattern: "test.>.foo", Interval: 1000}}, WSPath: "/"}, Config{}, true},
+		{Config{RefreshIntervals: []RefreshIntervalConfig{{Pattern: "test.>", Interval: 0}}, WSPath: "/"}, Config{}, true},
+		{Config{PinnedResources: []string{"test.>.foo"}, WSPath: "/"}, Config{}, true},
+		{Config{PinnedResources: []string{"test..model"}, WSPath: "/"}, Config{}, true},
+		{Config{PinnedRetry: &PinnedRetryConfig{Backoff: -1}, WSPath: "/"}, Config{}, true},
+		{Config{PinnedRetry: &PinnedRetryConfig{Backoff: 2000, MaxBackoff: 1000}, WSPath: "/"}, Config{}, true},
+		{Config{StaleIfError: &StaleIfErrorConfig{MaxStale: 0}, WSPath: "/"}, Config{}, true},
+		{Config{StaleIfError: &StaleIfErrorConfig{MaxStale: 1000, Backoff: -1}, WSPath: "/"}, Config{}, true},
+		{Config{StaleIfError: &StaleIfErrorConfig{MaxStale: 1000, Backoff: 2000, MaxBackoff: 1000}, WSPath: "/"}, Config{}, true},
+		{Config{GetRetry: &GetRetryConfig{Retries: 0}, WSPath: "/"}, Config{}, true},
+		{Config{GetRetry: &GetRetryConfig{Retries: 1, Backoff: -1}, WSPath: "/"}, Config{}, true},
+		{Config{GetRetry: &GetRetryConfig{Retries: 1, Backoff: 2000, MaxBackoff: 1000}, WSPath: "/"}, Config{}, true},
+		{Config{QueryNormalizations: []QueryNormalizationConfig{{Pattern: "test.>.foo", SortParams: true}}, WSPath: "/"}, Config{}, true},
 	}
 
 	for i, r := range tbl {
@@ -97,6 +141,7 @@ func TestConfigPrepare(t *testing.T) {
 		compareStringPtr(t, "PUTMethod", cfg.PUTMethod, r.Expected.PUTMethod, i)
 		compareStringPtr(t, "DELETEMethod", cfg.DELETEMethod, r.Expected.DELETEMethod, i)
 		compareStringPtr(t, "PATCHMethod", cfg.PATCHMethod, r.Expected.PATCHMethod, i)
+		compareStringPtr(t, "StatusPath", cfg.StatusPath, r.Expected.StatusPath, i)
 
 		if cfg.Port != r.Expected.Port {
 			t.Fatalf("expected Port to be:\n%d\nbut got:\n%d\nin test %d", r.Expected.Port, cfg.Port, i+1)
@@ -7,7 +7,7 @@ const (
 	Version = "1.6.3"
 
 	// ProtocolVersion is the implemented RES protocol version.
-	ProtocolVersion = "1.2.1"
+	ProtocolVersion = "1.2.2"
 
 	// DefaultAddr is the default host for client connections.
 	DefaultAddr = "0.0.0.0"
@@ -21,6 +21,10 @@ const (
 	// DefaultAPIPath is the default path to web resource.
 	DefaultAPIPath = "/api"
 
+	// OpenAPIFile is the file name, relative to the API path, serving the
+	// OpenAPI document when a resource schema is configured.
+	OpenAPIFile = "openapi.json"
+
 	// DefaultAPIEncoding is the default encoding for web resources.
 	DefaultAPIEncoding = "json"
 
@@ -44,4 +48,28 @@ const (
 
 	// UnsubscribeDelay is the delay for the cache to unsubscribe and evict resources no longer used.
 	UnsubscribeDelay = 5 * time.Second
+
+	// DefaultPinnedBackoff is the default delay in milliseconds before
+	// retrying a pinned resource failing to load.
+	DefaultPinnedBackoff = 1000
+
+	// DefaultPinnedMaxBackoff is the default maximum delay in milliseconds
+	// between retries of a pinned resource failing to load.
+	DefaultPinnedMaxBackoff = 60000
+
+	// DefaultStaleBackoff is the default delay in milliseconds before retrying
+	// a failed reset get request for a stale resource.
+	DefaultStaleBackoff = 1000
+
+	// DefaultStaleMaxBackoff is the default maximum delay in milliseconds
+	// between retries of failed reset get requests for a stale resource.
+	DefaultStaleMaxBackoff = 30000
+
+	// DefaultGetRetryBackoff is the default delay in milliseconds before
+	// retrying a get request failing with a temporary error.
+	DefaultGetRetryBackoff = 500
+
+	// DefaultGetRetryMaxBackoff is the default maximum delay in milliseconds
+	// between retries of get requests failing with a temporary error.
+	DefaultGetRetryMaxBackoff = 10000
 )
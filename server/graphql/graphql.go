@@ -0,0 +1,708 @@
+// Package graphql implements a parser for the subset of the GraphQL query
+// language needed to map GraphQL requests onto RES resources.
+//
+// Type system definitions and block strings are not supported.
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Operation types
+const (
+	OperationQuery        = "query"
+	OperationMutation     = "mutation"
+	OperationSubscription = "subscription"
+)
+
+// Document is a parsed GraphQL document.
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation is a query, mutation, or subscription operation.
+type Operation struct {
+	Type         string
+	Name         string
+	Variables    []*VariableDefinition
+	SelectionSet []*Selection
+}
+
+// VariableDefinition is an operation variable with an optional default value.
+type VariableDefinition struct {
+	Name    string
+	Type    string
+	Default *Value
+}
+
+// Fragment is a named fragment definition.
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	SelectionSet  []*Selection
+}
+
+// Selection is either a field, a fragment spread, or an inline fragment.
+type Selection struct {
+	Field          *Field
+	FragmentSpread string
+	InlineFragment []*Selection
+	Directives     []*Directive
+}
+
+// Field is a selected field.
+type Field struct {
+	Alias        string
+	Name         string
+	Arguments    []*Argument
+	SelectionSet []*Selection
+}
+
+// Argument is a named value passed to a field or directive.
+type Argument struct {
+	Name  string
+	Value *Value
+}
+
+// Directive is a directive, such as @skip or @include.
+type Directive struct {
+	Name      string
+	Arguments []*Argument
+}
+
+// ValueKind is the kind of an input value.
+type ValueKind uint8
+
+// Input value kinds
+const (
+	ValueVariable ValueKind = iota
+	ValueInt
+	ValueFloat
+	ValueString
+	ValueBoolean
+	ValueNull
+	ValueEnum
+	ValueList
+	ValueObject
+)
+
+// Value is an input value literal.
+type Value struct {
+	Kind   ValueKind
+	Raw    string      // Variable name, or literal value for scalars and enums
+	List   []*Value    // List items
+	Fields []*Argument // Object fields
+}
+
+// SyntaxError is returned when a document fails to parse.
+type SyntaxError struct {
+	Pos     int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Message)
+}
+
+// ResponseKey returns the key used for the field in the response.
+func (f *Field) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// Argument returns a named argument, or nil if not found.
+func (f *Field) Argument(name string) *Argument {
+	return findArgument(f.Arguments, name)
+}
+
+// CoerceVariables returns the variable values for the operation, with
+// default values applied. An error is returned if a non-null variable is
+// missing.
+func (op *Operation) CoerceVariables(vars map[string]interface{}) (map[string]interface{}, error) {
+	coerced := make(map[string]interface{}, len(op.Variables))
+	for _, vd := range op.Variables {
+		v, ok := vars[vd.Name]
+		if !ok && vd.Default != nil {
+			dv, err := vd.Default.Resolve(nil)
+			if err != nil {
+				return nil, err
+			}
+			v, ok = dv, true
+		}
+		if v == nil && strings.HasSuffix(vd.Type, "!") {
+			return nil, fmt.Errorf("variable $%s of type %s must not be null", vd.Name, vd.Type)
+		}
+		if ok {
+			coerced[vd.Name] = v
+		}
+	}
+	return coerced, nil
+}
+
+// Operation returns the operation with the given name. If name is empty, the
+// document must contain exactly one operation.
+func (d *Document) Operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(d.Operations) != 1 {
+			return nil, fmt.Errorf("must provide operation name if query contains multiple operations")
+		}
+		return d.Operations[0], nil
+	}
+	for _, op := range d.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation named %q", name)
+}
+
+// CollectFields returns the fields of a selection set, expanding fragments
+// and applying @skip and @include directives.
+func (d *Document) CollectFields(sels []*Selection, vars map[string]interface{}) ([]*Field, error) {
+	var fields []*Field
+	return d.collectFields(sels, vars, fields, nil)
+}
+
+func (d *Document) collectFields(sels []*Selection, vars map[string]interface{}, fields []*Field, visited []string) ([]*Field, error) {
+	for _, sel := range sels {
+		include, err := includeSelection(sel, vars)
+		if err != nil {
+			return nil, err
+		}
+		if !include {
+			continue
+		}
+		switch {
+		case sel.Field != nil:
+			fields = append(fields, sel.Field)
+		case sel.FragmentSpread != "":
+			name := sel.FragmentSpread
+			for _, v := range visited {
+				if v == name {
+					return nil, fmt.Errorf("cyclic fragment spread %q", name)
+				}
+			}
+			frag, ok := d.Fragments[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown fragment %q", name)
+			}
+			fields, err = d.collectFields(frag.SelectionSet, vars, fields, append(visited, name))
+		default:
+			fields, err = d.collectFields(sel.InlineFragment, vars, fields, visited)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+func includeSelection(sel *Selection, vars map[string]interface{}) (bool, error) {
+	for _, d := range sel.Directives {
+		if d.Name != "skip" && d.Name != "include" {
+			continue
+		}
+		arg := findArgument(d.Arguments, "if")
+		if arg == nil {
+			return false, fmt.Errorf("directive @%s requires argument \"if\"", d.Name)
+		}
+		v, err := arg.Resolve(vars)
+		if err != nil {
+			return false, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return false, fmt.Errorf("argument \"if\" of directive @%s must be a boolean", d.Name)
+		}
+		if b == (d.Name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func findArgument(args []*Argument, name string) *Argument {
+	for _, arg := range args {
+		if arg.Name == name {
+			return arg
+		}
+	}
+	return nil
+}
+
+// Resolve returns the value of the argument, resolving any variables.
+func (a *Argument) Resolve(vars map[string]interface{}) (interface{}, error) {
+	return a.Value.Resolve(vars)
+}
+
+// Resolve returns the Go representation of the value, resolving any
+// variables. Numbers are returned as json.Number, and objects as
+// map[string]interface{}.
+func (v *Value) Resolve(vars map[string]interface{}) (interface{}, error) {
+	switch v.Kind {
+	case ValueVariable:
+		val, ok := vars[v.Raw]
+		if !ok {
+			return nil, nil
+		}
+		return val, nil
+	case ValueInt, ValueFloat:
+		return json.Number(v.Raw), nil
+	case ValueString, ValueEnum:
+		return v.Raw, nil
+	case ValueBoolean:
+		return v.Raw == "true", nil
+	case ValueList:
+		l := make([]interface{}, len(v.List))
+		for i, item := range v.List {
+			iv, err := item.Resolve(vars)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = iv
+		}
+		return l, nil
+	case ValueObject:
+		m := make(map[string]interface{}, len(v.Fields))
+		for _, f := range v.Fields {
+			fv, err := f.Value.Resolve(vars)
+			if err != nil {
+				return nil, err
+			}
+			m[f.Name] = fv
+		}
+		return m, nil
+	}
+	return nil, nil
+}
+
+// Parse parses a GraphQL document containing operations and fragments.
+func Parse(src string) (*Document, error) {
+	p := &parser{lex: lexer{src: src}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return p.parseDocument()
+}
+
+type parser struct {
+	lex lexer
+	tok token
+}
+
+func (p *parser) advance() error {
+	t, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = t
+	return nil
+}
+
+func (p *parser) peek(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && (value == "" || p.tok.value == value)
+}
+
+func (p *parser) skip(kind tokenKind, value string) (bool, error) {
+	if !p.peek(kind, value) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *parser) expect(kind tokenKind, value string) (string, error) {
+	if !p.peek(kind, value) {
+		expected := value
+		if expected == "" {
+			expected = "name"
+		}
+		return "", p.errorf("expected %s, found %s", expected, p.tok)
+	}
+	v := p.tok.value
+	return v, p.advance()
+}
+
+func (p *parser) errorf(format string, v ...interface{}) error {
+	return &SyntaxError{Pos: p.tok.pos, Message: fmt.Sprintf(format, v...)}
+}
+
+func (p *parser) parseDocument() (*Document, error) {
+	d := &Document{Fragments: make(map[string]*Fragment)}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek(tokenPunctuator, "{"):
+			sels, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			d.Operations = append(d.Operations, &Operation{Type: OperationQuery, SelectionSet: sels})
+		case p.peek(tokenName, "fragment"):
+			f, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := d.Fragments[f.Name]; ok {
+				return nil, fmt.Errorf("duplicate fragment %q", f.Name)
+			}
+			d.Fragments[f.Name] = f
+		case p.peek(tokenName, OperationQuery), p.peek(tokenName, OperationMutation), p.peek(tokenName, OperationSubscription):
+			op, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			d.Operations = append(d.Operations, op)
+		default:
+			return nil, p.errorf("unexpected %s", p.tok)
+		}
+	}
+	if len(d.Operations) == 0 {
+		return nil, p.errorf("document contains no operations")
+	}
+	return d, nil
+}
+
+func (p *parser) parseOperation() (*Operation, error) {
+	op := &Operation{Type: p.tok.value}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokenName, "") {
+		op.Name = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if ok, err := p.skip(tokenPunctuator, "("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.peek(tokenPunctuator, ")") {
+			vd, err := p.parseVariableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.Variables = append(op.Variables, vd)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.SelectionSet = sels
+	return op, nil
+}
+
+func (p *parser) parseVariableDefinition() (*VariableDefinition, error) {
+	if _, err := p.expect(tokenPunctuator, "$"); err != nil {
+		return nil, err
+	}
+	name, err := p.expect(tokenName, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenPunctuator, ":"); err != nil {
+		return nil, err
+	}
+	typ, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	vd := &VariableDefinition{Name: name, Type: typ}
+	if ok, err := p.skip(tokenPunctuator, "="); err != nil {
+		return nil, err
+	} else if ok {
+		if vd.Default, err = p.parseValue(true); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	return vd, nil
+}
+
+func (p *parser) parseType() (string, error) {
+	var typ string
+	if ok, err := p.skip(tokenPunctuator, "["); err != nil {
+		return "", err
+	} else if ok {
+		inner, err := p.parseType()
+		if err != nil {
+			return "", err
+		}
+		if _, err := p.expect(tokenPunctuator, "]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.expect(tokenName, "")
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if ok, err := p.skip(tokenPunctuator, "!"); err != nil {
+		return "", err
+	} else if ok {
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *parser) parseFragment() (*Fragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokenName, "on") {
+		return nil, p.errorf("unexpected fragment name \"on\"")
+	}
+	name, err := p.expect(tokenName, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenName, "on"); err != nil {
+		return nil, err
+	}
+	typ, err := p.expect(tokenName, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &Fragment{Name: name, TypeCondition: typ, SelectionSet: sels}, nil
+}
+
+func (p *parser) parseSelectionSet() ([]*Selection, error) {
+	if _, err := p.expect(tokenPunctuator, "{"); err != nil {
+		return nil, err
+	}
+	var sels []*Selection
+	for !p.peek(tokenPunctuator, "}") {
+		sel, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, p.errorf("selection set must not be empty")
+	}
+	return sels, p.advance()
+}
+
+func (p *parser) parseSelection() (*Selection, error) {
+	ok, err := p.skip(tokenPunctuator, "...")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		f, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		sel := &Selection{Field: f}
+		if sel.Directives, err = p.parseDirectives(); err != nil {
+			return nil, err
+		}
+		if p.peek(tokenPunctuator, "{") {
+			if f.SelectionSet, err = p.parseSelectionSet(); err != nil {
+				return nil, err
+			}
+		}
+		return sel, nil
+	}
+
+	sel := &Selection{}
+	if p.peek(tokenName, "") && p.tok.value != "on" {
+		sel.FragmentSpread = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		sel.Directives, err = p.parseDirectives()
+		return sel, err
+	}
+	if ok, err := p.skip(tokenName, "on"); err != nil {
+		return nil, err
+	} else if ok {
+		if _, err := p.expect(tokenName, ""); err != nil {
+			return nil, err
+		}
+	}
+	if sel.Directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	sel.InlineFragment, err = p.parseSelectionSet()
+	return sel, err
+}
+
+func (p *parser) parseField() (*Field, error) {
+	name, err := p.expect(tokenName, "")
+	if err != nil {
+		return nil, err
+	}
+	f := &Field{Name: name}
+	if ok, err := p.skip(tokenPunctuator, ":"); err != nil {
+		return nil, err
+	} else if ok {
+		f.Alias = name
+		if f.Name, err = p.expect(tokenName, ""); err != nil {
+			return nil, err
+		}
+	}
+	f.Arguments, err = p.parseArguments(false)
+	return f, err
+}
+
+func (p *parser) parseArguments(isConst bool) ([]*Argument, error) {
+	ok, err := p.skip(tokenPunctuator, "(")
+	if err != nil || !ok {
+		return nil, err
+	}
+	var args []*Argument
+	for !p.peek(tokenPunctuator, ")") {
+		arg, err := p.parseArgument(isConst)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) == 0 {
+		return nil, p.errorf("argument list must not be empty")
+	}
+	return args, p.advance()
+}
+
+func (p *parser) parseArgument(isConst bool) (*Argument, error) {
+	name, err := p.expect(tokenName, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenPunctuator, ":"); err != nil {
+		return nil, err
+	}
+	v, err := p.parseValue(isConst)
+	if err != nil {
+		return nil, err
+	}
+	return &Argument{Name: name, Value: v}, nil
+}
+
+func (p *parser) parseDirectives() ([]*Directive, error) {
+	var ds []*Directive
+	for p.peek(tokenPunctuator, "@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.expect(tokenName, "")
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.parseArguments(false)
+		if err != nil {
+			return nil, err
+		}
+		ds = append(ds, &Directive{Name: name, Arguments: args})
+	}
+	return ds, nil
+}
+
+func (p *parser) parseValue(isConst bool) (*Value, error) {
+	t := p.tok
+	var v *Value
+	switch t.kind {
+	case tokenInt:
+		v = &Value{Kind: ValueInt, Raw: t.value}
+	case tokenFloat:
+		v = &Value{Kind: ValueFloat, Raw: t.value}
+	case tokenString:
+		v = &Value{Kind: ValueString, Raw: t.value}
+	case tokenName:
+		switch t.value {
+		case "true", "false":
+			v = &Value{Kind: ValueBoolean, Raw: t.value}
+		case "null":
+			v = &Value{Kind: ValueNull}
+		default:
+			v = &Value{Kind: ValueEnum, Raw: t.value}
+		}
+	case tokenPunctuator:
+		switch t.value {
+		case "$":
+			if isConst {
+				return nil, p.errorf("unexpected variable")
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			name, err := p.expect(tokenName, "")
+			if err != nil {
+				return nil, err
+			}
+			return &Value{Kind: ValueVariable, Raw: name}, nil
+		case "[":
+			return p.parseList(isConst)
+		case "{":
+			return p.parseObject(isConst)
+		}
+	}
+	if v == nil {
+		return nil, p.errorf("unexpected %s", t)
+	}
+	return v, p.advance()
+}
+
+func (p *parser) parseList(isConst bool) (*Value, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	v := &Value{Kind: ValueList, List: []*Value{}}
+	for !p.peek(tokenPunctuator, "]") {
+		item, err := p.parseValue(isConst)
+		if err != nil {
+			return nil, err
+		}
+		v.List = append(v.List, item)
+	}
+	return v, p.advance()
+}
+
+func (p *parser) parseObject(isConst bool) (*Value, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	v := &Value{Kind: ValueObject, Fields: []*Argument{}}
+	for !p.peek(tokenPunctuator, "}") {
+		f, err := p.parseArgument(isConst)
+		if err != nil {
+			return nil, err
+		}
+		v.Fields = append(v.Fields, f)
+	}
+	return v, p.advance()
+}
+
+// String returns the value as a string if it is a string literal, or a
+// variable resolving to a string.
+func (v *Value) String(vars map[string]interface{}) (string, bool) {
+	r, err := v.Resolve(vars)
+	if err != nil {
+		return "", false
+	}
+	s, ok := r.(string)
+	return s, ok
+}
@@ -0,0 +1,394 @@
+package graphql
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// mustParse parses the document, failing the test on error.
+func mustParse(t *testing.T, src string) *Document {
+	d, err := Parse(src)
+	if err != nil {
+		t.Fatalf("error parsing %s: %s", src, err)
+	}
+	return d
+}
+
+// collectKeys returns the response keys of the collected fields of the
+// operation selection set.
+func collectKeys(t *testing.T, d *Document, vars map[string]interface{}) []string {
+	op, err := d.Operation("")
+	if err != nil {
+		t.Fatalf("error getting operation: %s", err)
+	}
+	fields, err := d.CollectFields(op.SelectionSet, vars)
+	if err != nil {
+		t.Fatalf("error collecting fields: %s", err)
+	}
+	keys := make([]string, len(fields))
+	for i, f := range fields {
+		keys[i] = f.ResponseKey()
+	}
+	return keys
+}
+
+func TestParse_Operations(t *testing.T) {
+	d := mustParse(t, `
+		# Comment
+		query GetUser { user { name } }
+		mutation SetUser { setUser(name: "foo") { name } }
+		subscription { users { name } }
+	`)
+	tbl := []struct {
+		Type string
+		Name string
+	}{
+		{OperationQuery, "GetUser"},
+		{OperationMutation, "SetUser"},
+		{OperationSubscription, ""},
+	}
+	if len(d.Operations) != len(tbl) {
+		t.Fatalf("expected %d operations, but got %d", len(tbl), len(d.Operations))
+	}
+	for i, l := range tbl {
+		op := d.Operations[i]
+		if op.Type != l.Type || op.Name != l.Name {
+			t.Errorf("test #%d: expected %s %#v, but got %s %#v", i+1, l.Type, l.Name, op.Type, op.Name)
+		}
+	}
+	if _, err := d.Operation(""); err == nil {
+		t.Error("expected an error getting an unnamed operation in a document with multiple operations")
+	}
+	if op, err := d.Operation("SetUser"); err != nil || op != d.Operations[1] {
+		t.Errorf("expected operation SetUser, but got %v, %v", op, err)
+	}
+	if _, err := d.Operation("Missing"); err == nil {
+		t.Error("expected an error getting an unknown operation")
+	}
+}
+
+func TestParse_Aliases(t *testing.T) {
+	d := mustParse(t, `{ first: user(id: 1) { name } second: user(id: 2) { fullName: name } }`)
+	sels := d.Operations[0].SelectionSet
+	if len(sels) != 2 {
+		t.Fatalf("expected 2 selections, but got %d", len(sels))
+	}
+	for i, alias := range []string{"first", "second"} {
+		f := sels[i].Field
+		if f.Alias != alias || f.Name != "user" || f.ResponseKey() != alias {
+			t.Errorf("test #%d: expected alias %s of user, but got alias %#v of %s", i+1, alias, f.Alias, f.Name)
+		}
+	}
+	f := sels[1].Field.SelectionSet[0].Field
+	if f.ResponseKey() != "fullName" || f.Name != "name" {
+		t.Errorf("expected fullName alias of name, but got %s of %s", f.ResponseKey(), f.Name)
+	}
+	if f := sels[0].Field.SelectionSet[0].Field; f.ResponseKey() != "name" {
+		t.Errorf("expected response key name, but got %s", f.ResponseKey())
+	}
+}
+
+func TestParse_Fragments(t *testing.T) {
+	d := mustParse(t, `
+		query {
+			user {
+				...UserFields
+				... on User { email }
+				... { phone }
+			}
+		}
+		fragment UserFields on User { id name ...More }
+		fragment More on User { age }
+	`)
+	if f := d.Fragments["UserFields"]; f == nil || f.TypeCondition != "User" {
+		t.Fatalf("expected fragment UserFields on User, but got %+v", f)
+	}
+	user := d.Operations[0].SelectionSet[0].Field
+	fields, err := d.CollectFields(user.SelectionSet, nil)
+	if err != nil {
+		t.Fatalf("error collecting fields: %s", err)
+	}
+	var keys []string
+	for _, f := range fields {
+		keys = append(keys, f.ResponseKey())
+	}
+	if expected := []string{"id", "name", "age", "email", "phone"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected fields %v, but got %v", expected, keys)
+	}
+}
+
+func TestCollectFields_InvalidFragments_ReturnsError(t *testing.T) {
+	tbl := []string{
+		`{ ...Missing }`,
+		`{ ...A } fragment A on T { ...B } fragment B on T { ...A }`,
+		`{ ...A } fragment A on T { ...A }`,
+	}
+
+	for i, src := range tbl {
+		d := mustParse(t, src)
+		if _, err := d.CollectFields(d.Operations[0].SelectionSet, nil); err == nil {
+			t.Errorf("test #%d: expected an error collecting fields of %s, but got none", i+1, src)
+		}
+	}
+
+	if _, err := Parse(`{ a } fragment A on T { b } fragment A on T { c }`); err == nil {
+		t.Error("expected an error parsing duplicate fragments, but got none")
+	}
+}
+
+func TestParse_VariablesAndDefaults(t *testing.T) {
+	d := mustParse(t, `query ($id: ID!, $limit: Int = 10, $tags: [String!]! = ["a", "b"], $filter: Filter = {name: "foo", age: null}, $opt: String) { a }`)
+	op := d.Operations[0]
+
+	tbl := []struct {
+		Name string
+		Type string
+	}{
+		{"id", "ID!"},
+		{"limit", "Int"},
+		{"tags", "[String!]!"},
+		{"filter", "Filter"},
+		{"opt", "String"},
+	}
+	if len(op.Variables) != len(tbl) {
+		t.Fatalf("expected %d variables, but got %d", len(tbl), len(op.Variables))
+	}
+	for i, l := range tbl {
+		vd := op.Variables[i]
+		if vd.Name != l.Name || vd.Type != l.Type {
+			t.Errorf("test #%d: expected $%s: %s, but got $%s: %s", i+1, l.Name, l.Type, vd.Name, vd.Type)
+		}
+	}
+
+	vars, err := op.CoerceVariables(map[string]interface{}{"id": "42", "limit": json.Number("5")})
+	if err != nil {
+		t.Fatalf("error coercing variables: %s", err)
+	}
+	expected := map[string]interface{}{
+		"id":     "42",
+		"limit":  json.Number("5"),
+		"tags":   []interface{}{"a", "b"},
+		"filter": map[string]interface{}{"name": "foo", "age": nil},
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("expected variables %#v, but got %#v", expected, vars)
+	}
+
+	if _, err := op.CoerceVariables(map[string]interface{}{"id": nil}); err == nil {
+		t.Error("expected an error coercing a null non-null variable, but got none")
+	}
+	if _, err := op.CoerceVariables(nil); err == nil {
+		t.Error("expected an error coercing a missing non-null variable, but got none")
+	}
+}
+
+func TestParse_ArgumentValues(t *testing.T) {
+	d := mustParse(t, `{ a(int: -12, float: 1.5e-3, str: "foo", yes: true, no: false, nil: null, enum: ASC, list: [1, $v], obj: {a: {b: [$v]}}, var: $v) }`)
+	f := d.Operations[0].SelectionSet[0].Field
+	vars := map[string]interface{}{"v": "bar"}
+
+	tbl := []struct {
+		Name     string
+		Kind     ValueKind
+		Expected interface{}
+	}{
+		{"int", ValueInt, json.Number("-12")},
+		{"float", ValueFloat, json.Number("1.5e-3")},
+		{"str", ValueString, "foo"},
+		{"yes", ValueBoolean, true},
+		{"no", ValueBoolean, false},
+		{"nil", ValueNull, nil},
+		{"enum", ValueEnum, "ASC"},
+		{"list", ValueList, []interface{}{json.Number("1"), "bar"}},
+		{"obj", ValueObject, map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{"bar"}}}},
+		{"var", ValueVariable, "bar"},
+	}
+	for i, l := range tbl {
+		arg := f.Argument(l.Name)
+		if arg == nil {
+			t.Errorf("test #%d: expected argument %s, but found none", i+1, l.Name)
+			continue
+		}
+		if arg.Value.Kind != l.Kind {
+			t.Errorf("test #%d: expected argument %s of kind %d, but got %d", i+1, l.Name, l.Kind, arg.Value.Kind)
+		}
+		v, err := arg.Resolve(vars)
+		if err != nil {
+			t.Errorf("test #%d: error resolving argument %s: %s", i+1, l.Name, err)
+		} else if !reflect.DeepEqual(v, l.Expected) {
+			t.Errorf("test #%d: expected argument %s to resolve to %#v, but got %#v", i+1, l.Name, l.Expected, v)
+		}
+	}
+	if f.Argument("missing") != nil {
+		t.Error("expected no argument for missing name")
+	}
+}
+
+func TestParse_Directives(t *testing.T) {
+	src := `query ($skip: Boolean!) @op {
+		a @skip(if: true)
+		b @include(if: false)
+		c @skip(if: $skip)
+		d @include(if: $skip) @custom(x: 1)
+		... on T @skip(if: false) { e }
+		...F @include(if: true)
+		f @custom
+	}
+	fragment F on T @custom { g }`
+
+	tbl := []struct {
+		Skip     bool
+		Expected []string
+	}{
+		{true, []string{"d", "e", "g", "f"}},
+		{false, []string{"c", "e", "g", "f"}},
+	}
+	d := mustParse(t, src)
+	for i, l := range tbl {
+		keys := collectKeys(t, d, map[string]interface{}{"skip": l.Skip})
+		if !reflect.DeepEqual(keys, l.Expected) {
+			t.Errorf("test #%d: expected fields %v, but got %v", i+1, l.Expected, keys)
+		}
+	}
+
+	ds := d.Operations[0].SelectionSet[3].Directives
+	if len(ds) != 2 || ds[1].Name != "custom" || ds[1].Arguments[0].Name != "x" {
+		t.Errorf("expected directives @include and @custom(x:), but got %+v", ds)
+	}
+}
+
+func TestCollectFields_InvalidDirectives_ReturnsError(t *testing.T) {
+	tbl := []struct {
+		Src  string
+		Vars map[string]interface{}
+	}{
+		{`{ a @skip }`, nil},
+		{`{ a @include(if: "true") }`, nil},
+		{`query ($v: Boolean) { a @skip(if: $v) }`, nil},
+		{`query ($v: Boolean) { a @skip(if: $v) }`, map[string]interface{}{"v": 1}},
+	}
+
+	for i, l := range tbl {
+		d := mustParse(t, l.Src)
+		if _, err := d.CollectFields(d.Operations[0].SelectionSet, l.Vars); err == nil {
+			t.Errorf("test #%d: expected an error collecting fields of %s, but got none", i+1, l.Src)
+		}
+	}
+}
+
+func TestParse_StringEscapes(t *testing.T) {
+	tbl := []struct {
+		Src      string
+		Expected string
+	}{
+		{`"foo"`, "foo"},
+		{`""`, ""},
+		{`"\"\\\/"`, `"\/`},
+		{`"\b\f\n\r\t"`, "\b\f\n\r\t"},
+		{`"\u00e5\u2603"`, "å☃"},
+		{`"åäö ☃"`, "åäö ☃"},
+	}
+
+	for i, l := range tbl {
+		d, err := Parse(`{ a(s: ` + l.Src + `) }`)
+		if err != nil {
+			t.Errorf("test #%d: error parsing %s: %s", i+1, l.Src, err)
+			continue
+		}
+		v := d.Operations[0].SelectionSet[0].Field.Argument("s").Value
+		if v.Kind != ValueString || v.Raw != l.Expected {
+			t.Errorf("test #%d: expected string %#v, but got %#v", i+1, l.Expected, v.Raw)
+		}
+	}
+}
+
+func TestParse_InvalidDocument_ReturnsSyntaxError(t *testing.T) {
+	tbl := []struct {
+		Src string
+		Pos int
+	}{
+		{``, 0},
+		{`   # comment only`, 17},
+		{`{`, 1},
+		{`{ }`, 2},
+		{`{ a `, 4},
+		{`{ a( ) }`, 5},
+		{`{ a(b) }`, 5},
+		{`{ a(b: ) }`, 7},
+		{`{ a(b: 1 }`, 9},
+		{`{ a: }`, 5},
+		{`{ a @ }`, 6},
+		{`{ ... }`, 6},
+		{`{ ...on }`, 8},
+		{`query ($a) { b }`, 9},
+		{`query ($a: = 1) { b }`, 11},
+		{`query ($a: [Int) { b }`, 15},
+		{`query ($a: Int = $b) { b }`, 17},
+		{`fragment on on T { a }`, 9},
+		{`fragment F T { a }`, 11},
+		{`{ a } b`, 6},
+		{`type Query { a: Int }`, 0},
+		{`{ a(s: "foo) }`, 7},
+		{`{ a(s: "foo` + "\n" + `") }`, 7},
+		{`{ a(s: "\x") }`, 7},
+		{`{ a(s: "\u12") }`, 7},
+		{`{ a(s: "\u12G4") }`, 7},
+		{`{ a(s: "\`, 7},
+		{`{ a(s: """block""") }`, 7},
+		{`{ a(n: 01a) }`, 7},
+		{`{ a(n: 1.) }`, 7},
+		{`{ a(n: 1e) }`, 7},
+		{`{ a(n: -) }`, 7},
+		{`{ a(n: 1.5.5) }`, 7},
+		{`{ a(n: .5) }`, 7},
+		{`{ a ? }`, 4},
+	}
+
+	for i, l := range tbl {
+		d, err := Parse(l.Src)
+		if err == nil {
+			t.Errorf("test #%d: expected an error parsing %#v, but got %+v", i+1, l.Src, d)
+			continue
+		}
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("test #%d: expected a syntax error parsing %#v, but got %T: %s", i+1, l.Src, err, err)
+		} else if se.Pos != l.Pos {
+			t.Errorf("test #%d: expected a syntax error at position %d parsing %#v, but got: %s", i+1, l.Pos, l.Src, err)
+		}
+	}
+}
+
+// Test that parsing malformed input never panics, by parsing every prefix
+// and random mutations of a valid document.
+func TestParse_MalformedInput_DoesNotPanic(t *testing.T) {
+	src := `query Q($a: [Int!]! = [1, 2], $b: In = {x: "å\n"}) @d(x: 1.5e3) {
+		alias: f(a: $a, b: {c: [$b, ENUM, null, true]}) @skip(if: false) { ...F ... on T { g } }
+	} fragment F on T { h # comment
+	}`
+	chars := []byte(`{}()[]:=$@!."\#,. e-_1aZ` + "\n\uFEFF")
+
+	parse := func(s string) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("panic parsing %#v: %v", s, r)
+			}
+		}()
+		Parse(s)
+	}
+
+	for i := 0; i <= len(src); i++ {
+		parse(src[:i])
+		parse(src[i:])
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		b := []byte(src)
+		for j := rnd.Intn(4); j >= 0; j-- {
+			b[rnd.Intn(len(b))] = chars[rnd.Intn(len(chars))]
+		}
+		parse(string(b))
+	}
+}
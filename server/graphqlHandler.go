@@ -0,0 +1,458 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/graphql"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// graphqlRequest is a GraphQL request as sent over HTTP.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlError is an error as included in a GraphQL response.
+type graphqlError struct {
+	Message    string        `json:"message"`
+	Path       []interface{} `json:"path,omitempty"`
+	Extensions *reserr.Error `json:"extensions,omitempty"`
+}
+
+var (
+	errGraphQLSubscriptionNotSupported = &reserr.Error{Code: reserr.CodeInvalidRequest, Message: "GraphQL subscriptions are only supported over WebSocket"}
+	errGraphQLNotModel                 = &reserr.Error{Code: reserr.CodeInvalidRequest, Message: "Resource is not a model"}
+	errGraphQLNotCollection            = &reserr.Error{Code: reserr.CodeInvalidRequest, Message: "Resource is not a collection"}
+)
+
+// graphqlOperation is a parsed GraphQL operation, ready to be executed.
+type graphqlOperation struct {
+	doc    *graphql.Document
+	op     *graphql.Operation
+	vars   map[string]interface{}
+	fields []*graphql.Field
+}
+
+func (s *Service) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		s.graphqlWSHandler(w, r)
+		return
+	}
+
+	err := s.setCommonHeaders(w, r)
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS, POST")
+		reqHeaders := r.Header["Access-Control-Request-Headers"]
+		if len(reqHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(reqHeaders, ", "))
+		}
+		return
+	}
+	if err != nil {
+		httpError(w, err, s.enc)
+		return
+	}
+
+	var req graphqlRequest
+	switch r.Method {
+	case "HEAD":
+		fallthrough
+	case "GET":
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				httpError(w, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error decoding variables: " + err.Error()}, s.enc)
+				return
+			}
+		}
+	case "POST":
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			httpError(w, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error reading request body: " + err.Error()}, s.enc)
+			return
+		}
+		mimetype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mimetype == "application/graphql" {
+			req.Query = string(b)
+		} else {
+			dec := json.NewDecoder(bytes.NewReader(b))
+			dec.UseNumber()
+			if err := dec.Decode(&req); err != nil {
+				httpError(w, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error decoding request body: " + err.Error()}, s.enc)
+				return
+			}
+		}
+	default:
+		httpError(w, reserr.ErrMethodNotAllowed, s.enc)
+		return
+	}
+
+	gop, err := req.parse()
+	if err != nil {
+		graphqlErrorResponse(w, err)
+		return
+	}
+	if gop.op.Type == graphql.OperationSubscription {
+		graphqlErrorResponse(w, errGraphQLSubscriptionNotSupported)
+		return
+	}
+	// Mutations may only be sent using POST
+	if gop.op.Type == graphql.OperationMutation && r.Method != "POST" {
+		httpError(w, reserr.ErrMethodNotAllowed, s.enc)
+		return
+	}
+
+	s.temporaryConn(w, r, func(c *wsConn, cb func([]byte, error)) {
+		e := &graphqlExecutor{c: c, doc: gop.doc, vars: gop.vars, op: gop.op}
+		e.execute(gop.fields, cb)
+	})
+}
+
+// parse parses the request query, and returns the operation to execute.
+func (req *graphqlRequest) parse() (*graphqlOperation, error) {
+	doc, err := graphql.Parse(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	op, err := doc.Operation(req.OperationName)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := op.CoerceVariables(req.Variables)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := doc.CollectFields(op.SelectionSet, vars)
+	if err != nil {
+		return nil, err
+	}
+	return &graphqlOperation{doc: doc, op: op, vars: vars, fields: fields}, nil
+}
+
+// graphqlErrorResponse writes a GraphQL response containing a single
+// request error, without any data.
+func graphqlErrorResponse(w http.ResponseWriter, err error) {
+	rerr := reserr.RESError(err)
+	if _, ok := err.(*reserr.Error); !ok {
+		rerr = &reserr.Error{Code: reserr.CodeInvalidRequest, Message: err.Error()}
+	}
+	out, _ := json.Marshal(struct {
+		Errors []graphqlError `json:"errors"`
+	}{[]graphqlError{{Message: rerr.Message, Extensions: rerr}}})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(out)
+}
+
+// graphqlExecutor executes a GraphQL operation on a connection, writing the
+// response as resources are fetched.
+type graphqlExecutor struct {
+	c    *wsConn
+	doc  *graphql.Document
+	op   *graphql.Operation
+	vars map[string]interface{}
+	b    bytes.Buffer
+	path []interface{}
+	errs []graphqlError
+	// If hold is set, fetched resources are kept subscribed in held, to be
+	// unsubscribed by the caller once no longer needed.
+	hold bool
+	held []*Subscription
+}
+
+// execute resolves the root fields one at a time, in order, and calls cb
+// with the encoded response once done.
+func (e *graphqlExecutor) execute(fields []*graphql.Field, cb func([]byte, error)) {
+	e.b.WriteString(`{"data":{`)
+	var next func(i int)
+	next = func(i int) {
+		if i == len(fields) {
+			e.b.WriteByte('}')
+			if len(e.errs) > 0 {
+				e.b.WriteString(`,"errors":`)
+				dta, _ := json.Marshal(e.errs)
+				e.b.Write(dta)
+			}
+			e.b.WriteByte('}')
+			cb(e.b.Bytes(), nil)
+			return
+		}
+		if i > 0 {
+			e.b.WriteByte(',')
+		}
+		f := fields[i]
+		e.writeKey(f.ResponseKey())
+		e.path = []interface{}{f.ResponseKey()}
+		e.resolveRootField(f, func() { next(i + 1) })
+	}
+	next(0)
+}
+
+func (e *graphqlExecutor) resolveRootField(f *graphql.Field, done func()) {
+	if f.Name == "__typename" {
+		switch e.op.Type {
+		case graphql.OperationMutation:
+			e.writeJSON("Mutation")
+		case graphql.OperationSubscription:
+			e.writeJSON("Subscription")
+		default:
+			e.writeJSON("Query")
+		}
+		done()
+		return
+	}
+
+	if e.op.Type == graphql.OperationMutation {
+		if f.Name != "call" {
+			e.fieldError(&reserr.Error{Code: reserr.CodeInvalidRequest, Message: "Unknown mutation field: " + f.Name})
+			done()
+			return
+		}
+		e.resolveCall(f, done)
+		return
+	}
+
+	var kind string
+	switch f.Name {
+	case "model", "collection":
+		kind = f.Name
+	case "resource":
+	default:
+		e.fieldError(&reserr.Error{Code: reserr.CodeInvalidRequest, Message: "Unknown query field: " + f.Name})
+		done()
+		return
+	}
+
+	rid, err := e.stringArgument(f, "rid")
+	if err != nil {
+		e.fieldError(err)
+		done()
+		return
+	}
+	e.resolveResource(rid, kind, f.SelectionSet, done)
+}
+
+// resolveCall calls a method on a resource, writing the result.
+func (e *graphqlExecutor) resolveCall(f *graphql.Field, done func()) {
+	rid, err := e.stringArgument(f, "rid")
+	if err != nil {
+		e.fieldError(err)
+		done()
+		return
+	}
+	method, err := e.stringArgument(f, "method")
+	if err != nil {
+		e.fieldError(err)
+		done()
+		return
+	}
+	if !codec.IsValidRID(rid, true) || !codec.IsValidRIDPart(method) {
+		e.fieldError(reserr.ErrInvalidParams)
+		done()
+		return
+	}
+	var params interface{}
+	if arg := f.Argument("params"); arg != nil {
+		if params, err = arg.Resolve(e.vars); err != nil {
+			e.fieldError(err)
+			done()
+			return
+		}
+	}
+
+	e.c.call(rid, method, params, func(result json.RawMessage, refRID string, err error) {
+		if err != nil {
+			e.fieldError(err)
+			done()
+			return
+		}
+		if refRID != "" {
+			e.resolveResource(refRID, "", f.SelectionSet, done)
+			return
+		}
+		if result == nil {
+			result = nullBytes
+		}
+		e.b.Write(result)
+		done()
+	})
+}
+
+// resolveResource fetches a resource and writes it using the selection set.
+// If kind is set, the resource must be a "model" or "collection"
+// respectively.
+func (e *graphqlExecutor) resolveResource(rid string, kind string, sels []*graphql.Selection, done func()) {
+	if !codec.IsValidRID(rid, true) {
+		e.fieldError(reserr.ErrNotFound)
+		done()
+		return
+	}
+	e.getSubscription(rid, func(sub *Subscription, err error) {
+		if err == nil && sub.Error() == nil {
+			switch {
+			case kind == "model" && sub.ResourceType() != rescache.TypeModel:
+				err = errGraphQLNotModel
+			case kind == "collection" && sub.ResourceType() != rescache.TypeCollection:
+				err = errGraphQLNotCollection
+			}
+		}
+		if err != nil {
+			e.fieldError(err)
+		} else {
+			e.writeSubscription(sub, sels)
+		}
+		done()
+	})
+}
+
+// getSubscription gets a loaded subscription of a resource. If the executor
+// holds subscriptions, the subscription is added to held instead of being
+// unsubscribed once cb is called.
+func (e *graphqlExecutor) getSubscription(rid string, cb func(sub *Subscription, err error)) {
+	if !e.hold {
+		e.c.GetSubscription(rid, cb)
+		return
+	}
+
+	sub, err := e.c.Subscribe(rid, true)
+	if err != nil {
+		cb(nil, err)
+		return
+	}
+	e.held = append(e.held, sub)
+
+	sub.CanGet(func(err error) {
+		if err != nil {
+			cb(nil, err)
+			return
+		}
+		sub.OnReady(func() {
+			cb(sub, sub.Error())
+			// Mark as sent to have events passed to the connection
+			sub.ReleaseRPCResources()
+		})
+	})
+}
+
+// writeSubscription writes a loaded resource. Models are written as
+// objects containing the selected fields, while collections are written
+// as lists.
+func (e *graphqlExecutor) writeSubscription(sub *Subscription, sels []*graphql.Selection) {
+	if err := sub.Error(); err != nil {
+		e.fieldError(err)
+		return
+	}
+
+	switch sub.ResourceType() {
+	case rescache.TypeCollection:
+		e.b.WriteByte('[')
+		for i, v := range sub.CollectionValues() {
+			if i > 0 {
+				e.b.WriteByte(',')
+			}
+			e.path = append(e.path, i)
+			e.writeValue(sub, v, sels)
+			e.path = e.path[:len(e.path)-1]
+		}
+		e.b.WriteByte(']')
+
+	case rescache.TypeModel:
+		if sels == nil {
+			e.fieldError(&reserr.Error{Code: reserr.CodeInvalidRequest, Message: "Model requires a selection of fields"})
+			return
+		}
+		fields, err := e.doc.CollectFields(sels, e.vars)
+		if err != nil {
+			e.fieldError(err)
+			return
+		}
+		vals := sub.ModelValues()
+		e.b.WriteByte('{')
+		for i, f := range fields {
+			if i > 0 {
+				e.b.WriteByte(',')
+			}
+			key := f.ResponseKey()
+			e.writeKey(key)
+			switch f.Name {
+			case "_rid":
+				e.writeJSON(sub.RID())
+			case "__typename":
+				e.writeJSON("Model")
+			default:
+				v, ok := vals[f.Name]
+				if !ok {
+					e.b.Write(nullBytes)
+					continue
+				}
+				e.path = append(e.path, key)
+				e.writeValue(sub, v, f.SelectionSet)
+				e.path = e.path[:len(e.path)-1]
+			}
+		}
+		e.b.WriteByte('}')
+	}
+}
+
+// writeValue writes a model or collection value. Resource references with
+// a selection set are followed, while soft references, and references
+// without a selection set, are written as the resource ID.
+func (e *graphqlExecutor) writeValue(sub *Subscription, v codec.Value, sels []*graphql.Selection) {
+	switch v.Type {
+	case codec.ValueTypeReference:
+		if sels == nil {
+			e.writeJSON(v.RID)
+			return
+		}
+		e.writeSubscription(sub.Ref(v.RID), sels)
+	case codec.ValueTypeSoftReference:
+		e.writeJSON(v.RID)
+	case codec.ValueTypeData:
+		e.b.Write(v.Inner)
+	default:
+		e.b.Write(v.RawMessage)
+	}
+}
+
+// fieldError adds an error for the current path, and writes null as the
+// field value.
+func (e *graphqlExecutor) fieldError(err error) {
+	rerr := reserr.RESError(err)
+	if _, ok := err.(*reserr.Error); !ok {
+		rerr = &reserr.Error{Code: reserr.CodeInvalidRequest, Message: err.Error()}
+	}
+	path := make([]interface{}, len(e.path))
+	copy(path, e.path)
+	e.errs = append(e.errs, graphqlError{Message: rerr.Message, Path: path, Extensions: rerr})
+	e.b.Write(nullBytes)
+}
+
+func (e *graphqlExecutor) stringArgument(f *graphql.Field, name string) (string, error) {
+	if arg := f.Argument(name); arg != nil {
+		if s, ok := arg.Value.String(e.vars); ok {
+			return s, nil
+		}
+	}
+	return "", &reserr.Error{Code: reserr.CodeInvalidParams, Message: "Argument \"" + name + "\" must be a string"}
+}
+
+func (e *graphqlExecutor) writeKey(key string) {
+	e.writeJSON(key)
+	e.b.WriteByte(':')
+}
+
+func (e *graphqlExecutor) writeJSON(v interface{}) {
+	dta, _ := json.Marshal(v)
+	e.b.Write(dta)
+}
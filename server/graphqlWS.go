@@ -0,0 +1,314 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server/graphql"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// graphqlWSProtocol is the WebSocket subprotocol for GraphQL over WebSocket.
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const graphqlWSProtocol = "graphql-transport-ws"
+
+// GraphQL over WebSocket close codes.
+const (
+	graphqlWSCloseBadRequest      = 4400
+	graphqlWSCloseUnauthorized    = 4401
+	graphqlWSCloseDuplicateID     = 4409
+	graphqlWSCloseTooManyInitReqs = 4429
+)
+
+// graphqlWSMessage is a GraphQL over WebSocket message.
+type graphqlWSMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// graphqlWSSession is a GraphQL over WebSocket connection. It is only
+// accessed by the connection worker goroutine.
+type graphqlWSSession struct {
+	c        *wsConn
+	ws       *websocket.Conn
+	inited   bool
+	acked    bool
+	ops      map[string]*graphqlWSOperation
+	pending  bool // A render of the subscriptions is queued
+	disposed bool
+}
+
+// graphqlWSOperation is an active GraphQL subscription operation.
+type graphqlWSOperation struct {
+	id        string
+	gop       *graphqlOperation
+	held      []*Subscription // Subscriptions held by the last execution
+	last      []byte          // Last sent result
+	executing bool
+	dirty     bool // Resources changed while executing
+}
+
+func (s *Service) graphqlWSHandler(w http.ResponseWriter, r *http.Request) {
+	supported := false
+	for _, p := range websocket.Subprotocols(r) {
+		if p == graphqlWSProtocol {
+			supported = true
+		}
+	}
+	if !supported {
+		httpError(w, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Unsupported WebSocket subprotocol"}, s.enc)
+		return
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, http.Header{"Sec-Websocket-Protocol": {graphqlWSProtocol}})
+	if err != nil {
+		s.Debugf("Failed to upgrade GraphQL connection from %s: %s", r.RemoteAddr, err.Error())
+		return
+	}
+
+	c := s.newWSConn(ws, r, versionLatest)
+	if c == nil {
+		ws.Close()
+		return
+	}
+	gs := &graphqlWSSession{
+		c:   c,
+		ws:  ws,
+		ops: make(map[string]*graphqlWSOperation),
+	}
+	// Resource events are not sent to the client, but trigger a new
+	// execution of the active subscriptions.
+	c.onEvent = gs.handleEvent
+
+	c.Tracef("Connected to GraphQL: %s", ws.RemoteAddr())
+	gs.listen()
+}
+
+func (gs *graphqlWSSession) listen() {
+	var in []byte
+	var err error
+	for {
+		if _, in, err = gs.ws.ReadMessage(); err != nil {
+			break
+		}
+		gs.c.Tracef("G-> %s", in)
+		in := in
+		gs.c.Enqueue(func() {
+			gs.handleMessage(in)
+		})
+	}
+
+	gs.c.Enqueue(func() {
+		gs.dispose()
+	})
+	gs.c.Dispose()
+	gs.c.Tracef("Disconnected: %s", err)
+}
+
+func (gs *graphqlWSSession) handleMessage(in []byte) {
+	if gs.disposed {
+		return
+	}
+	var m graphqlWSMessage
+	if err := json.Unmarshal(in, &m); err != nil {
+		gs.close(graphqlWSCloseBadRequest, "Invalid message received")
+		return
+	}
+
+	switch m.Type {
+	case "connection_init":
+		if gs.inited {
+			gs.close(graphqlWSCloseTooManyInitReqs, "Too many initialisation requests")
+			return
+		}
+		gs.inited = true
+		gs.init()
+	case "ping":
+		gs.send(&graphqlWSMessage{Type: "pong", Payload: m.Payload})
+	case "pong":
+	case "subscribe":
+		if !gs.acked {
+			gs.close(graphqlWSCloseUnauthorized, "Unauthorized")
+			return
+		}
+		if m.ID == "" {
+			gs.close(graphqlWSCloseBadRequest, "Invalid message received")
+			return
+		}
+		if _, ok := gs.ops[m.ID]; ok {
+			gs.close(graphqlWSCloseDuplicateID, "Subscriber for "+m.ID+" already exists")
+			return
+		}
+		var req graphqlRequest
+		dec := json.NewDecoder(bytes.NewReader(m.Payload))
+		dec.UseNumber()
+		if err := dec.Decode(&req); err != nil {
+			gs.close(graphqlWSCloseBadRequest, "Invalid message received")
+			return
+		}
+		gs.subscribe(m.ID, &req)
+	case "complete":
+		gs.complete(m.ID)
+	default:
+		gs.close(graphqlWSCloseBadRequest, "Invalid message received")
+	}
+}
+
+// init acknowledges the connection, after any header authentication.
+func (gs *graphqlWSSession) init() {
+	cfg := gs.c.serv.cfg
+	if cfg.HeaderAuth == nil {
+		gs.ack()
+		return
+	}
+	gs.c.AuthResource(cfg.headerAuthRID, cfg.headerAuthAction, nil, func(_ interface{}, err error) {
+		if err != nil {
+			gs.c.Debugf("Header auth error: %s", err)
+		}
+		gs.ack()
+	})
+}
+
+func (gs *graphqlWSSession) ack() {
+	if gs.disposed {
+		return
+	}
+	gs.acked = true
+	gs.send(&graphqlWSMessage{Type: "connection_ack"})
+}
+
+// subscribe executes an operation. Queries and mutations are completed
+// after a single result, while subscriptions send a new result each time
+// the resources change.
+func (gs *graphqlWSSession) subscribe(id string, req *graphqlRequest) {
+	gop, err := req.parse()
+	if err != nil {
+		gs.sendError(id, err)
+		return
+	}
+
+	if gop.op.Type != graphql.OperationSubscription {
+		// Reserve the ID until completed
+		gs.ops[id] = nil
+		e := &graphqlExecutor{c: gs.c, doc: gop.doc, vars: gop.vars, op: gop.op}
+		e.execute(gop.fields, func(out []byte, _ error) {
+			if _, ok := gs.ops[id]; !ok || gs.disposed {
+				return
+			}
+			delete(gs.ops, id)
+			gs.send(&graphqlWSMessage{ID: id, Type: "next", Payload: out})
+			gs.send(&graphqlWSMessage{ID: id, Type: "complete"})
+		})
+		return
+	}
+
+	op := &graphqlWSOperation{id: id, gop: gop}
+	gs.ops[id] = op
+	gs.execute(op)
+}
+
+// execute executes a subscription operation, holding the fetched resources
+// until the next execution. The result is sent if it differs from the
+// previous one.
+func (gs *graphqlWSSession) execute(op *graphqlWSOperation) {
+	op.executing = true
+	op.dirty = false
+	e := &graphqlExecutor{c: gs.c, doc: op.gop.doc, vars: op.gop.vars, op: op.gop.op, hold: true}
+	e.execute(op.gop.fields, func(out []byte, _ error) {
+		op.executing = false
+		if gs.ops[op.id] != op || gs.disposed {
+			gs.release(e.held)
+			return
+		}
+		gs.release(op.held)
+		op.held = e.held
+		if !bytes.Equal(out, op.last) {
+			op.last = append([]byte(nil), out...)
+			gs.send(&graphqlWSMessage{ID: op.id, Type: "next", Payload: out})
+		}
+		if op.dirty {
+			gs.execute(op)
+		}
+	})
+}
+
+// handleEvent is called by the connection on any resource event, and
+// queues a new execution of the active subscriptions.
+func (gs *graphqlWSSession) handleEvent(data []byte) {
+	if gs.pending {
+		return
+	}
+	gs.pending = true
+	// Queue the execution to let any events already queued be applied
+	gs.c.Enqueue(func() {
+		gs.pending = false
+		if gs.disposed {
+			return
+		}
+		for _, op := range gs.ops {
+			if op == nil {
+				continue
+			}
+			if op.executing {
+				op.dirty = true
+				continue
+			}
+			gs.execute(op)
+		}
+	})
+}
+
+// complete stops an operation.
+func (gs *graphqlWSSession) complete(id string) {
+	op, ok := gs.ops[id]
+	if !ok {
+		return
+	}
+	delete(gs.ops, id)
+	if op != nil {
+		gs.release(op.held)
+		op.held = nil
+	}
+}
+
+// release unsubscribes held subscriptions. Subscriptions may already be
+// unsubscribed, such as when access is revoked.
+func (gs *graphqlWSSession) release(subs []*Subscription) {
+	for _, sub := range subs {
+		if sub.direct > 0 {
+			gs.c.Unsubscribe(sub, true, 1, true)
+		}
+	}
+}
+
+func (gs *graphqlWSSession) dispose() {
+	gs.disposed = true
+	gs.ops = nil
+}
+
+func (gs *graphqlWSSession) sendError(id string, err error) {
+	rerr := reserr.RESError(err)
+	if _, ok := err.(*reserr.Error); !ok {
+		rerr = &reserr.Error{Code: reserr.CodeInvalidRequest, Message: err.Error()}
+	}
+	payload, _ := json.Marshal([]graphqlError{{Message: rerr.Message, Extensions: rerr}})
+	gs.send(&graphqlWSMessage{ID: id, Type: "error", Payload: payload})
+}
+
+func (gs *graphqlWSSession) send(m *graphqlWSMessage) {
+	data, _ := json.Marshal(m)
+	gs.c.Tracef("<-G %s", data)
+	gs.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+// close closes the WebSocket connection with a GraphQL over WebSocket close
+// code.
+func (gs *graphqlWSSession) close(code int, reason string) {
+	gs.c.Tracef("Closing GraphQL connection - %d: %s", code, reason)
+	gs.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(WSTimeout))
+	gs.ws.Close()
+}
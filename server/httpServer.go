@@ -71,6 +71,10 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch {
 	case r.URL.Path == s.cfg.WSPath:
 		s.wsHandler(w, r)
+	case s.cfg.GraphQLPath != nil && r.URL.Path == *s.cfg.GraphQLPath:
+		s.graphqlHandler(w, r)
+	case s.cfg.StatusPath != nil && r.URL.Path == *s.cfg.StatusPath:
+		s.statusHandler(w, r)
 	case strings.HasPrefix(r.URL.Path, s.cfg.APIPath):
 		s.apiHandler(w, r)
 	default:
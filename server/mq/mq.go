@@ -38,6 +38,11 @@ type Client interface {
 // when a call to SendRequest times out
 var ErrRequestTimeout = reserr.ErrTimeout
 
+// ErrNoResponders is the error the client should pass to the Response when
+// the messaging system reports that no service is subscribing to the
+// request subject
+var ErrNoResponders = reserr.ErrServiceUnavailable
+
 // ErrSubjectTooLong is the error the client should pass to the Response when
 // the subject exceeds the maximum control line size
 var ErrSubjectTooLong = reserr.ErrSubjectTooLong
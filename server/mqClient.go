@@ -7,7 +7,21 @@ import (
 )
 
 func (s *Service) initMQClient() {
-	s.cache = rescache.NewCache(s.mq, CacheWorkers, UnsubscribeDelay, s.logger)
+	workers := s.cfg.CacheWorkers
+	if workers == 0 {
+		workers = CacheWorkers
+	}
+	s.cache = rescache.NewCache(s.mq, workers, s.cfg.unsubscribeDelay, s.logger)
+	s.cache.SetUnsubscribeDelays(s.cfg.delays)
+	s.cache.SetRefreshIntervals(s.cfg.refreshIntervals)
+	s.cache.SetBulkheads(s.cfg.bulkheads)
+	s.cache.SetMaxSize(s.cfg.CacheMaxSize)
+	s.cache.SetMaxQueries(s.cfg.CacheMaxQueries)
+	s.cache.SetPinned(s.cfg.pinRIDs, s.cfg.pinPatterns)
+	s.cache.SetPinRetry(s.cfg.pinRetry)
+	s.cache.SetStaleIfError(s.cfg.staleIfError)
+	s.cache.SetGetRetry(s.cfg.getRetry)
+	s.cache.SetQueryNormalizations(s.cfg.queryNormalizations)
 }
 
 // startMQClients creates a connection to the messaging system.
@@ -49,3 +63,14 @@ func (s *Service) stopMQClient() {
 func (s *Service) handleClosedMQ(err error) {
 	s.Stop(err)
 }
+
+// CacheStats returns the resource cache totals.
+func (s *Service) CacheStats() rescache.Stats {
+	return s.cache.Stats()
+}
+
+// PinnedResources returns the load status of the pinned resources fetched on
+// start.
+func (s *Service) PinnedResources() []rescache.PinStatus {
+	return s.cache.Pinned()
+}
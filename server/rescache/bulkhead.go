@@ -0,0 +1,125 @@
+package rescache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Bulkhead limits the number of concurrent outstanding requests for
+// resources matching a pattern, so that a slow service cannot take up the
+// capacity used for other resources.
+type Bulkhead struct {
+	// Pattern matching the resource names.
+	Pattern ResourcePattern
+	// Maximum number of outstanding requests.
+	MaxRequests int
+	// Maximum duration for an excess request to wait for an outstanding
+	// request to complete. Zero fails excess requests immediately.
+	MaxWait time.Duration
+}
+
+// bulkhead holds the outstanding requests and waiting requests of a
+// Bulkhead.
+type bulkhead struct {
+	Bulkhead
+	mu       sync.Mutex
+	inflight int
+	waiting  *list.List
+}
+
+// waiter is a request waiting for an outstanding request to complete.
+type waiter struct {
+	f func(error)
+	t *time.Timer
+}
+
+func newBulkhead(b Bulkhead) *bulkhead {
+	return &bulkhead{Bulkhead: b, waiting: list.New()}
+}
+
+// acquire calls f once the request may be sent, or with
+// reserr.ErrTooManyRequests if the wait times out. If the request may be
+// sent immediately, f is called synchronously.
+func (b *bulkhead) acquire(f func(error)) {
+	b.mu.Lock()
+	if b.inflight < b.MaxRequests {
+		b.inflight++
+		b.mu.Unlock()
+		f(nil)
+		return
+	}
+	if b.MaxWait <= 0 {
+		b.mu.Unlock()
+		go f(reserr.ErrTooManyRequests)
+		return
+	}
+	w := &waiter{f: f}
+	el := b.waiting.PushBack(w)
+	w.t = time.AfterFunc(b.MaxWait, func() {
+		b.mu.Lock()
+		if el.Value == nil {
+			// Already granted by release
+			b.mu.Unlock()
+			return
+		}
+		b.waiting.Remove(el)
+		el.Value = nil
+		b.mu.Unlock()
+		f(reserr.ErrTooManyRequests)
+	})
+	b.mu.Unlock()
+}
+
+// release completes an outstanding request, passing its place on to the
+// first waiting request, if any.
+func (b *bulkhead) release() {
+	b.mu.Lock()
+	el := b.waiting.Front()
+	if el == nil {
+		b.inflight--
+		b.mu.Unlock()
+		return
+	}
+	w := el.Value.(*waiter)
+	b.waiting.Remove(el)
+	el.Value = nil
+	b.mu.Unlock()
+
+	w.t.Stop()
+	go w.f(nil)
+}
+
+// bulkhead returns the first bulkhead matching the resource name, or nil if
+// no bulkhead matches.
+func (c *Cache) bulkhead(rname string) *bulkhead {
+	for _, b := range c.bulkheads {
+		if b.Pattern.Match(rname) {
+			return b
+		}
+	}
+	return nil
+}
+
+// mqSendRequest sends a request for a resource, limited by any bulkhead
+// matching the resource name.
+func (c *Cache) mqSendRequest(rname, subj string, payload []byte, cb mq.Response) {
+	b := c.bulkhead(rname)
+	if b == nil {
+		c.mq.SendRequest(subj, payload, cb)
+		return
+	}
+	b.acquire(func(err error) {
+		if err != nil {
+			cb("", nil, err)
+			return
+		}
+		c.mq.SendRequest(subj, payload, func(s string, data []byte, err error) {
+			b.release()
+			cb(s, data, err)
+		})
+	})
+}
@@ -0,0 +1,415 @@
+package rescache
+
+import (
+	"github.com/resgateio/resgate/server/codec"
+)
+
+// diffMaxEdits is the maximum number of edits, removed and added values,
+// for which a collection diff is computed. Beyond it, the diff falls back to
+// a single reset event replacing all values, bounding the time spent in a
+// cache worker. See BenchmarkDiff.
+const diffMaxEdits = 2000
+
+// differ computes the differences between two collections using Myers'
+// linear space diff algorithm, in O((N+M)D) time and O(N+M) space.
+// http://www.xmailserver.org/diff2.pdf
+type differ struct {
+	a, b   []codec.Value
+	v1, v2 []int
+	// Removed values in a, and added values in b
+	del, ins []bool
+}
+
+// diff returns the remove, move, and add events transforming collection a
+// into collection b. The removes are returned first, in descending index
+// order, followed by the moves of values both removed and added, and the adds
+// in ascending index order. If the edits exceed diffMaxEdits, a single reset
+// event with the values of b is returned instead.
+func diff(a, b []codec.Value) []*ResourceEvent {
+	s := 0
+	m := len(a)
+	n := len(b)
+
+	// Trim of matches at the start and end
+	for s < m && s < n && a[s].Equal(b[s]) {
+		s++
+	}
+
+	if s == m && s == n {
+		return nil
+	}
+
+	for s < m && s < n && a[m-1].Equal(b[n-1]) {
+		m--
+		n--
+	}
+
+	aa := a[s:m]
+	bb := b[s:n]
+	d := &differ{
+		a:   aa,
+		b:   bb,
+		del: make([]bool, len(aa)),
+		ins: make([]bool, len(bb)),
+	}
+	if !d.compare(0, len(aa), 0, len(bb), diffMaxEdits/2) {
+		return []*ResourceEvent{{Event: "reset", Values: b}}
+	}
+
+	return d.events(s, d.moves())
+}
+
+// valueKey is used to match equal values.
+type valueKey struct {
+	t codec.ValueType
+	s string
+}
+
+// keyOf returns a key that is equal for values considered equal by Value.Equal.
+func keyOf(v codec.Value) valueKey {
+	if v.Type == codec.ValueTypeReference || v.Type == codec.ValueTypeSoftReference {
+		return valueKey{t: v.Type, s: v.RID}
+	}
+	return valueKey{t: v.Type, s: string(v.RawMessage)}
+}
+
+// moves pairs removed values with equal added values. It returns the index
+// in a of the value moved to each index in b, or -1 if the value is not moved.
+// Returns nil if there are no moves.
+func (d *differ) moves() []int {
+	var removed map[valueKey][]int
+	for i, del := range d.del {
+		if del {
+			if removed == nil {
+				removed = make(map[valueKey][]int)
+			}
+			k := keyOf(d.a[i])
+			removed[k] = append(removed[k], i)
+		}
+	}
+
+	var mv []int
+	for j, ins := range d.ins {
+		if !ins || removed == nil {
+			continue
+		}
+		k := keyOf(d.b[j])
+		idxs := removed[k]
+		if len(idxs) == 0 {
+			continue
+		}
+		if mv == nil {
+			mv = make([]int, len(d.b))
+			for i := range mv {
+				mv[i] = -1
+			}
+		}
+		mv[j] = idxs[0]
+		removed[k] = idxs[1:]
+	}
+	return mv
+}
+
+// events returns the remove, move, and add events, with indexes offset by s.
+// The mv slice is the index in a of the value moved to each index in b, as
+// returned by moves.
+func (d *differ) events(s int, mv []int) []*ResourceEvent {
+	moved := make([]bool, len(d.a))
+	for _, i := range mv {
+		if i >= 0 {
+			moved[i] = true
+		}
+	}
+
+	var steps []*ResourceEvent
+	for i := len(d.del) - 1; i >= 0; i-- {
+		if d.del[i] && !moved[i] {
+			steps = append(steps, &ResourceEvent{
+				Event: "remove",
+				Payload: codec.EncodeRemoveEvent(&codec.RemoveEvent{
+					Idx: s + i,
+				}),
+			})
+		}
+	}
+
+	if mv != nil {
+		steps = d.moveEvents(steps, s, mv, moved)
+	}
+
+	for j, ins := range d.ins {
+		if ins && (mv == nil || mv[j] < 0) {
+			steps = append(steps, &ResourceEvent{
+				Event: "add",
+				Payload: codec.EncodeAddEvent(&codec.AddEvent{
+					Value: d.b[j],
+					Idx:   s + j,
+				}),
+			})
+		}
+	}
+	return steps
+}
+
+// moveEvents appends the move events to steps, applied after the removes. Each
+// value is moved, in ascending order of its index in b, to directly after the
+// closest preceding value in b that is either kept or already moved. After the
+// moves, the values are in the order of b, with only the adds remaining.
+//
+// The current indexes are counted in a position tree of slots, ordered as the
+// collection: one slot for each value of b that is kept or moved, preceded by
+// slots for the values yet to be moved that lie before that kept value.
+func (d *differ) moveEvents(steps []*ResourceEvent, s int, mv []int, moved []bool) []*ResourceEvent {
+	// Index in b, without the adds, of the kept and moved values in a
+	rank := make([]int, len(d.a))
+	i := 0
+	l := 0
+	for j, ins := range d.ins {
+		switch {
+		case mv[j] >= 0:
+			rank[mv[j]] = l
+		case ins:
+			continue
+		default:
+			for d.del[i] {
+				i++
+			}
+			rank[i] = l
+			i++
+		}
+		l++
+	}
+
+	// Rank of the kept value following each moved value in a, or l if none,
+	// and the number of moved values before each kept value.
+	next := make([]int, len(d.a))
+	cnt := make([]int, l+1)
+	k := l
+	for i := len(d.a) - 1; i >= 0; i-- {
+		switch {
+		case moved[i]:
+			next[i] = k
+			cnt[k]++
+		case !d.del[i]:
+			k = rank[i]
+		}
+	}
+	slot := make([]int, l+1)
+	n := 0
+	for r, c := range cnt {
+		n += c
+		slot[r] = r + n
+	}
+
+	pos := make(positions, slot[l]+1)
+	from := make([]int, len(d.a))
+	for i, del := range d.del {
+		switch {
+		case moved[i]:
+			r := next[i]
+			from[i] = slot[r] - cnt[r]
+			cnt[r]--
+			pos.add(from[i], 1)
+		case !del:
+			pos.add(slot[rank[i]], 1)
+		}
+	}
+
+	for _, ai := range mv {
+		if ai < 0 {
+			continue
+		}
+		f := pos.count(from[ai])
+		pos.add(from[ai], -1)
+		t := pos.count(slot[rank[ai]])
+		pos.add(slot[rank[ai]], 1)
+		if f != t {
+			steps = append(steps, &ResourceEvent{
+				Event: "move",
+				Payload: codec.EncodeMoveEvent(&codec.MoveEvent{
+					From: s + f,
+					To:   s + t,
+				}),
+			})
+		}
+	}
+	return steps
+}
+
+// positions is a binary indexed tree counting the values held in a sequence
+// of slots.
+type positions []int
+
+// add adds d values to slot i.
+func (p positions) add(i int, d int) {
+	for i++; i < len(p); i += i & -i {
+		p[i] += d
+	}
+}
+
+// count returns the number of values in the slots before slot i.
+func (p positions) count(i int) int {
+	n := 0
+	for ; i > 0; i -= i & -i {
+		n += p[i]
+	}
+	return n
+}
+
+// compare marks the removed values in a[a0:a1], and the added values in
+// b[b0:b1]. It returns false, without marking, if the middle snake requires
+// more than limit edits on each side.
+func (d *differ) compare(a0, a1, b0, b1, limit int) bool {
+	// Trim of matches at the start and end
+	for a0 < a1 && b0 < b1 && d.a[a0].Equal(d.b[b0]) {
+		a0++
+		b0++
+	}
+	for a0 < a1 && b0 < b1 && d.a[a1-1].Equal(d.b[b1-1]) {
+		a1--
+		b1--
+	}
+
+	switch {
+	case a0 == a1:
+		for j := b0; j < b1; j++ {
+			d.ins[j] = true
+		}
+		return true
+	case b0 == b1:
+		for i := a0; i < a1; i++ {
+			d.del[i] = true
+		}
+		return true
+	}
+
+	x, y, ok := d.bisect(a0, a1, b0, b1, limit)
+	if !ok {
+		return false
+	}
+	if x < 0 {
+		// No common values
+		for i := a0; i < a1; i++ {
+			d.del[i] = true
+		}
+		for j := b0; j < b1; j++ {
+			d.ins[j] = true
+		}
+		return true
+	}
+
+	// The sub-problems have fewer edits, and need no limit
+	d.compare(a0, x, b0, y, -1)
+	d.compare(x, a1, y, b1, -1)
+	return true
+}
+
+// bisect finds the middle snake of a[a0:a1] and b[b0:b1], returning the
+// point where to split the problem. The point is -1 if there are no common
+// values. If limit is not negative, ok is false if the middle snake requires
+// more than limit edits on each side.
+func (d *differ) bisect(a0, a1, b0, b1, limit int) (x, y int, ok bool) {
+	n := a1 - a0
+	m := b1 - b0
+	maxD := (n + m + 1) / 2
+	if limit >= 0 && limit < maxD {
+		maxD = limit
+	}
+	off := (n+m+1)/2 + 1
+	vlen := 2*off + 1
+	if cap(d.v1) < vlen {
+		d.v1 = make([]int, vlen)
+		d.v2 = make([]int, vlen)
+	}
+	v1 := d.v1[:vlen]
+	v2 := d.v2[:vlen]
+	for i := range v1 {
+		v1[i] = -1
+		v2[i] = -1
+	}
+	v1[off+1] = 0
+	v2[off+1] = 0
+
+	delta := n - m
+	// If the total number of values is odd, the front path collides with the
+	// reverse path.
+	front := delta&1 != 0
+	// Offsets for start and end of k loops, preventing mapping of space
+	// beyond the grid.
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+
+	for e := 0; e < maxD; e++ {
+		// Walk the front path one step
+		for k1 := -e + k1start; k1 <= e-k1end; k1 += 2 {
+			k1off := off + k1
+			var x1 int
+			if k1 == -e || (k1 != e && v1[k1off-1] < v1[k1off+1]) {
+				x1 = v1[k1off+1]
+			} else {
+				x1 = v1[k1off-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && d.a[a0+x1].Equal(d.b[b0+y1]) {
+				x1++
+				y1++
+			}
+			v1[k1off] = x1
+			switch {
+			case x1 > n:
+				// Ran off the right of the graph
+				k1end += 2
+			case y1 > m:
+				// Ran off the bottom of the graph
+				k1start += 2
+			case front:
+				k2off := off + delta - k1
+				if k2off >= 0 && k2off < vlen && v2[k2off] != -1 {
+					// Mirror x2 onto top-left coordinate system
+					if x1 >= n-v2[k2off] {
+						return a0 + x1, b0 + y1, true
+					}
+				}
+			}
+		}
+
+		// Walk the reverse path one step
+		for k2 := -e + k2start; k2 <= e-k2end; k2 += 2 {
+			k2off := off + k2
+			var x2 int
+			if k2 == -e || (k2 != e && v2[k2off-1] < v2[k2off+1]) {
+				x2 = v2[k2off+1]
+			} else {
+				x2 = v2[k2off-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && d.a[a1-x2-1].Equal(d.b[b1-y2-1]) {
+				x2++
+				y2++
+			}
+			v2[k2off] = x2
+			switch {
+			case x2 > n:
+				// Ran off the left of the graph
+				k2end += 2
+			case y2 > m:
+				// Ran off the top of the graph
+				k2start += 2
+			case !front:
+				k1off := off + delta - k2
+				if k1off >= 0 && k1off < vlen && v1[k1off] != -1 {
+					x1 := v1[k1off]
+					y1 := off + x1 - k1off
+					// Mirror x2 onto top-left coordinate system
+					if x1 >= n-x2 {
+						return a0 + x1, b0 + y1, true
+					}
+				}
+			}
+		}
+	}
+
+	if limit >= 0 && maxD == limit && limit < (n+m+1)/2 {
+		return 0, 0, false
+	}
+	return -1, -1, true
+}
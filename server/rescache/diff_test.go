@@ -0,0 +1,287 @@
+package rescache
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/resgateio/resgate/server/codec"
+)
+
+// testValues returns a collection of primitive number values.
+func testValues(t testing.TB, nums []int) []codec.Value {
+	var vs []codec.Value
+	dta, _ := json.Marshal(nums)
+	if err := json.Unmarshal(dta, &vs); err != nil {
+		t.Fatal(err)
+	}
+	return vs
+}
+
+// applyEvents applies remove, move, and add events to a copy of the collection.
+func applyEvents(t testing.TB, a []codec.Value, evs []*ResourceEvent) []codec.Value {
+	c := append([]codec.Value(nil), a...)
+	for _, ev := range evs {
+		var p struct {
+			Idx   int         `json:"idx"`
+			From  int         `json:"from"`
+			To    int         `json:"to"`
+			Value codec.Value `json:"value"`
+		}
+		if err := json.Unmarshal(ev.Payload, &p); err != nil {
+			t.Fatal(err)
+		}
+		switch ev.Event {
+		case "remove":
+			c = append(c[:p.Idx], c[p.Idx+1:]...)
+		case "add":
+			c = append(c, codec.Value{})
+			copy(c[p.Idx+1:], c[p.Idx:])
+			c[p.Idx] = p.Value
+		case "move":
+			v := c[p.From]
+			c = append(c[:p.From], c[p.From+1:]...)
+			c = append(c, codec.Value{})
+			copy(c[p.To+1:], c[p.To:])
+			c[p.To] = v
+		default:
+			t.Fatalf("unexpected event %s", ev.Event)
+		}
+	}
+	return c
+}
+
+// lcsLength returns the length of the longest common subsequence, using a
+// dynamic programming matrix.
+func lcsLength(a, b []codec.Value) int {
+	w := len(a) + 1
+	c := make([]int, w*(len(b)+1))
+	for j := 0; j < len(b); j++ {
+		for i := 0; i < len(a); i++ {
+			switch {
+			case a[i].Equal(b[j]):
+				c[(i+1)+w*(j+1)] = c[i+w*j] + 1
+			case c[(i+1)+w*j] > c[i+w*(j+1)]:
+				c[(i+1)+w*(j+1)] = c[(i+1)+w*j]
+			default:
+				c[(i+1)+w*(j+1)] = c[i+w*(j+1)]
+			}
+		}
+	}
+	return c[len(c)-1]
+}
+
+// randomNums returns n random numbers in the range [0, max).
+func randomNums(r *rand.Rand, n, max int) []int {
+	nums := make([]int, n)
+	for i := range nums {
+		nums[i] = r.Intn(max)
+	}
+	return nums
+}
+
+// editNums returns a copy of nums with edits random removes and adds.
+func editNums(r *rand.Rand, nums []int, edits int) []int {
+	out := append([]int(nil), nums...)
+	for i := 0; i < edits; i++ {
+		if len(out) > 0 && r.Intn(2) == 0 {
+			idx := r.Intn(len(out))
+			out = append(out[:idx], out[idx+1:]...)
+		} else {
+			idx := r.Intn(len(out) + 1)
+			out = append(out, 0)
+			copy(out[idx+1:], out[idx:])
+			out[idx] = -1 - i
+		}
+	}
+	return out
+}
+
+func TestDiff_RandomCollections_ReturnsMinimalEvents(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		a := testValues(t, randomNums(r, r.Intn(30), 5))
+		b := testValues(t, randomNums(r, r.Intn(30), 5))
+
+		evs := diff(a, b)
+		got := applyEvents(t, a, evs)
+		if len(got) != len(b) {
+			t.Fatalf("test #%d: expected %d values, but got %d", i+1, len(b), len(got))
+		}
+		for j := range b {
+			if !got[j].Equal(b[j]) {
+				t.Fatalf("test #%d: expected value %s at %d, but got %s", i+1, b[j].RawMessage, j, got[j].RawMessage)
+			}
+		}
+		// Each move replaces a remove and an add
+		moves := 0
+		for _, ev := range evs {
+			if ev.Event == "move" {
+				moves++
+			}
+		}
+		if expected := len(a) + len(b) - 2*lcsLength(a, b) - moves; len(evs) != expected {
+			t.Fatalf("test #%d: expected %d events, but got %d", i+1, expected, len(evs))
+		}
+	}
+}
+
+func TestDiff_ReorderedCollections_ReturnsMoveEvents(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		nums := r.Perm(r.Intn(30) + 2)
+		a := testValues(t, nums)
+		b := testValues(t, r.Perm(len(nums)))
+
+		evs := diff(a, b)
+		got := applyEvents(t, a, evs)
+		for j := range b {
+			if !got[j].Equal(b[j]) {
+				t.Fatalf("test #%d: expected value %s at %d, but got %s", i+1, b[j].RawMessage, j, got[j].RawMessage)
+			}
+		}
+		if expected := len(a) - lcsLength(a, b); len(evs) != expected {
+			t.Fatalf("test #%d: expected %d move events, but got %d", i+1, expected, len(evs))
+		}
+		for _, ev := range evs {
+			if ev.Event != "move" {
+				t.Fatalf("test #%d: expected move events, but got %s", i+1, ev.Event)
+			}
+		}
+	}
+}
+
+func TestDiff_SingleValueMoved_ReturnsSingleMoveEvent(t *testing.T) {
+	tbl := []struct {
+		A        []int
+		B        []int
+		Expected string
+	}{
+		{[]int{1, 2, 3, 4}, []int{2, 3, 4, 1}, `{"from":0,"to":3}`},
+		{[]int{1, 2, 3, 4}, []int{4, 1, 2, 3}, `{"from":3,"to":0}`},
+		{[]int{1, 2, 3, 4}, []int{1, 3, 2, 4}, `{"from":1,"to":2}`},
+		{[]int{1, 2, 3, 4, 5}, []int{1, 4, 2, 3, 5}, `{"from":3,"to":1}`},
+	}
+
+	for i, l := range tbl {
+		evs := diff(testValues(t, l.A), testValues(t, l.B))
+		if len(evs) != 1 || evs[0].Event != "move" || string(evs[0].Payload) != l.Expected {
+			t.Fatalf("test #%d: expected single move event %s, but got %d events", i+1, l.Expected, len(evs))
+		}
+	}
+}
+
+func TestDiff_EqualCollections_ReturnsNoEvents(t *testing.T) {
+	a := testValues(t, []int{1, 2, 3})
+	if evs := diff(a, testValues(t, []int{1, 2, 3})); len(evs) != 0 {
+		t.Fatalf("expected no events, but got %d", len(evs))
+	}
+}
+
+func TestDiff_TooManyEdits_ResetsValues(t *testing.T) {
+	nums := make([]int, diffMaxEdits*2)
+	for i := range nums {
+		nums[i] = i
+	}
+	rev := make([]int, len(nums))
+	for i, n := range nums {
+		rev[len(nums)-1-i] = n
+	}
+	// Keep common start and end values
+	a := testValues(t, append(append([]int{-1}, nums...), -2))
+	b := testValues(t, append(append([]int{-1}, rev...), -2))
+
+	evs := diff(a, b)
+	if len(evs) != 1 || evs[0].Event != "reset" {
+		t.Fatalf("expected a single reset event, but got %d events", len(evs))
+	}
+	if len(evs[0].Values) != len(b) {
+		t.Fatalf("expected %d reset values, but got %d", len(b), len(evs[0].Values))
+	}
+	for j := range b {
+		if !evs[0].Values[j].Equal(b[j]) {
+			t.Fatalf("expected value %s at %d, but got %s", b[j].RawMessage, j, evs[0].Values[j].RawMessage)
+		}
+	}
+}
+
+// BenchmarkDiff measures diffing collections with a number of random edits.
+// Time grows with the collection size times the edits, while memory grows
+// with the collection size only.
+func BenchmarkDiff(b *testing.B) {
+	for _, size := range []int{1000, 20000} {
+		for _, edits := range []int{10, 100, 1000, diffMaxEdits} {
+			r := rand.New(rand.NewSource(1))
+			nums := randomNums(r, size, size)
+			a := testValues(b, nums)
+			c := testValues(b, editNums(r, nums, edits))
+			b.Run(fmt.Sprintf("size=%d/edits=%d", size, edits), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					diff(a, c)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkDiff_Replace measures diffing collections with no common values,
+// exceeding diffMaxEdits and falling back to a reset of all values.
+func BenchmarkDiff_Replace(b *testing.B) {
+	for _, size := range []int{1000, 20000} {
+		r := rand.New(rand.NewSource(1))
+		a := testValues(b, randomNums(r, size, size))
+		c := testValues(b, editNums(r, nil, size))
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				diff(a, c)
+			}
+		})
+	}
+}
+
+// BenchmarkDiff_Moves measures diffing collections of unique values, with
+// values moved to random indexes, where each moved value results in a move
+// event.
+func BenchmarkDiff_Moves(b *testing.B) {
+	for _, size := range []int{1000, 20000} {
+		for _, moves := range []int{10, 100, diffMaxEdits / 2} {
+			r := rand.New(rand.NewSource(1))
+			nums := r.Perm(size)
+			a := testValues(b, nums)
+			moved := append([]int(nil), nums...)
+			for i := 0; i < moves; i++ {
+				from, to := r.Intn(size), r.Intn(size)
+				v := moved[from]
+				moved = append(moved[:from], moved[from+1:]...)
+				moved = append(moved[:to], append([]int{v}, moved[to:]...)...)
+			}
+			c := testValues(b, moved)
+			b.Run(fmt.Sprintf("size=%d/moves=%d", size, moves), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					diff(a, c)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkLCSMatrix measures the dynamic programming matrix approach,
+// previously used for diffing, for comparison.
+func BenchmarkLCSMatrix(b *testing.B) {
+	for _, size := range []int{1000, 5000} {
+		r := rand.New(rand.NewSource(1))
+		nums := randomNums(r, size, size)
+		a := testValues(b, nums)
+		c := testValues(b, editNums(r, nums, 100))
+		b.Run(fmt.Sprintf("size=%d/edits=100", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				lcsLength(a, c)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package rescache
 
 import (
+	"container/list"
 	"sync"
 
+	"github.com/jirenius/timerqueue"
 	"github.com/resgateio/resgate/server/codec"
 	"github.com/resgateio/resgate/server/mq"
 	"github.com/resgateio/resgate/server/reserr"
@@ -23,20 +25,25 @@ type EventSubscription struct {
 	// Immutable
 	ResourceName string
 	cache        *Cache
+	unsubQueue   *timerqueue.Queue
 
 	// Protected by cache mutex
 	mqSub mq.Unsubscriber
 	count int64
+	lruEl *list.Element // Protected by cache lruMu
 
 	// Protected by single goroutine
 	base    *ResourceSubscription
 	queries map[string]*ResourceSubscription
 	links   map[string]*ResourceSubscription
+	learned map[string]string
+	size    int64
 
 	// Mutex protected
-	mu    sync.Mutex
-	queue []func()
-	locks []func()
+	mu     sync.Mutex
+	pinned bool
+	queue  []func()
+	locks  []func()
 }
 
 func (e *EventSubscription) getResourceSubscription(q string) (rs *ResourceSubscription) {
@@ -69,7 +76,14 @@ func (e *EventSubscription) getResourceSubscription(q string) (rs *ResourceSubsc
 func (e *EventSubscription) addSubscriber(sub Subscriber) {
 	e.Enqueue(func() {
 		var rs *ResourceSubscription
-		q := sub.ResourceQuery()
+		q := e.normalizeQuery(sub.ResourceQuery())
+		if e.isQueryLimitReached(q) {
+			e.removeCount(1)
+			e.mu.Unlock()
+			defer e.mu.Lock()
+			sub.Loaded(nil, reserr.ErrTooManyQueries)
+			return
+		}
 		rs = e.getResourceSubscription(q)
 
 		if rs.state != stateError {
@@ -82,12 +96,7 @@ func (e *EventSubscription) addSubscriber(sub Subscriber) {
 		case stateSubscribed:
 			// Progress state
 			rs.state = stateRequested
-			// Create request
-			subj := "get." + e.ResourceName
-			payload := codec.CreateGetRequest(q)
-			e.cache.mq.SendRequest(subj, payload, func(_ string, data []byte, err error) {
-				rs.enqueueGetResponse(data, err)
-			})
+			rs.sendGetRequest()
 
 		// If a request has already been sent
 		// In that case the subscriber will be handled
@@ -199,18 +208,29 @@ func (e *EventSubscription) addCount() {
 	defer e.mu.Unlock()
 
 	if e.count == 0 {
-		e.cache.unsubQueue.Remove(e)
+		e.unsubQueue.Remove(e)
+		e.cache.lruRemove(e)
 	}
 	e.count++
 }
 
 // removeCount decreases the subscription count, and puts the event subscription
-// in the unsubscribe queue if count reaches zero.
+// in the unsubscribe queue if count reaches zero, unless it is pinned with a
+// loaded base resource.
 func (e *EventSubscription) removeCount(n int64) {
 	e.count -= n
-	if e.count == 0 && n != 0 {
-		e.cache.unsubQueue.Add(e)
+	if e.count != 0 || (n == 0 && !e.pinned) {
+		return
 	}
+	if e.pinned {
+		if e.base != nil {
+			return
+		}
+		// The pinned resource failed to load or was deleted
+		e.pinned = false
+	}
+	e.unsubQueue.Add(e)
+	e.cache.lruPush(e)
 }
 
 func (e *EventSubscription) enqueueEvent(subj string, payload []byte) {
@@ -233,6 +253,12 @@ func (e *EventSubscription) enqueueEvent(subj string, payload []byte) {
 				return
 			}
 
+			// The reset event is reserved, and only generated by the cache
+			if event == "reset" {
+				e.cache.Errorf("Error processing event %s: reserved event name", subj)
+				return
+			}
+
 			ev, err := codec.DecodeEvent(payload)
 			if err != nil {
 				e.cache.Errorf("Error processing event %s: malformed payload %s", subj, payload)
@@ -261,6 +287,11 @@ func (e *EventSubscription) handleQueryEvent(subj string, payload []byte) {
 		return
 	}
 
+	if qe.Batch {
+		e.handleQueryEventBatch(qe.Subject)
+		return
+	}
+
 	// We lock events from being handled until all event queries has been handled first
 	e.lockEvents(l)
 
@@ -272,51 +303,65 @@ func (e *EventSubscription) handleQueryEvent(subj string, payload []byte) {
 		}
 		payload := codec.CreateEventQueryRequest(q)
 		rs := rs
-		e.cache.mq.SendRequest(qe.Subject, payload, func(subj string, data []byte, err error) {
+		e.cache.mqSendRequest(e.ResourceName, qe.Subject, payload, func(subj string, data []byte, err error) {
 			e.enqueueUnlock(func() {
 				if err != nil {
 					return
 				}
 
 				result, err := codec.DecodeEventQueryResponse(data)
-				if err != nil {
-					// In case of a system.notFound error,
-					// a delete event is generated. Otherwise we
-					// just log the error.
-					if reserr.IsError(err, reserr.CodeNotFound) {
-						rs.handleEvent(&ResourceEvent{Event: "delete"})
-					} else {
-						e.cache.Errorf("Error processing query event for %s?%s: %s", e.ResourceName, rs.query, err)
-					}
-					return
-				}
-
-				switch {
-				// Handle array of events
-				case result.Events != nil:
-					for _, ev := range result.Events {
-						rs.handleEvent(&ResourceEvent{Event: ev.Event, Payload: ev.Data})
-					}
-				// Handle model response
-				case result.Model != nil:
-					if rs.state != stateModel {
-						e.cache.Errorf("Error processing query event for %s?%s: non-model payload on model %s", e.ResourceName, rs.query, data)
-						return
-					}
-					rs.processResetModel(result.Model)
-				// Handle collection response
-				case result.Collection != nil:
-					if rs.state != stateCollection {
-						e.cache.Errorf("Error processing query event for %s?%s: non-model payload on model %s", e.ResourceName, rs.query, data)
-						return
-					}
-					rs.processResetCollection(result.Collection)
-				}
+				rs.handleEventQueryResult(result, err, data)
 			})
 		})
 	}
 }
 
+// handleQueryEventBatch sends a single batch query request for all loaded
+// queries, locking events from being handled until the response is handled.
+func (e *EventSubscription) handleQueryEventBatch(subj string) {
+	queries := make([]string, 0, len(e.queries))
+	rss := make([]*ResourceSubscription, 0, len(e.queries))
+	for q, rs := range e.queries {
+		// Do not include queries still being requested
+		if rs.state > stateRequested {
+			queries = append(queries, q)
+			rss = append(rss, rs)
+		}
+	}
+	if len(queries) == 0 {
+		return
+	}
+
+	e.lockEvents(1)
+	payload := codec.CreateEventQueryBatchRequest(queries)
+	e.cache.mqSendRequest(e.ResourceName, subj, payload, func(_ string, data []byte, err error) {
+		e.enqueueUnlock(func() {
+			if err != nil {
+				return
+			}
+
+			result, err := codec.DecodeEventQueryBatchResponse(data)
+			if err != nil {
+				e.cache.Errorf("Error processing batch query event for %s: %s", e.ResourceName, err)
+				return
+			}
+
+			for i, q := range queries {
+				item := result.Queries[q]
+				if item == nil {
+					continue
+				}
+				rs := rss[i]
+				if item.Error != nil {
+					rs.handleEventQueryResult(nil, item.Error, data)
+				} else {
+					rs.handleEventQueryResult(&item.EventQueryResult, nil, data)
+				}
+			}
+		})
+	})
+}
+
 // mqUnsubscribe unsubscribes to the MQ.
 // Returns true on success, otherwise false.
 // It may fail if the subscription count is not zero, or an error
@@ -342,6 +387,12 @@ func (e *EventSubscription) mqUnsubscribe() bool {
 			return false
 		}
 	}
+	e.cache.lruRemove(e)
+	e.stopRefresh()
+	e.stopStaleRetry()
+	e.stopGetRetry()
+	// Release the size of the cached resources
+	e.addSize(-e.size)
 	return true
 }
 
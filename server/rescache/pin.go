@@ -0,0 +1,205 @@
+package rescache
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// PinStatus holds the load status of a pinned resource.
+type PinStatus struct {
+	// Resource ID, including any query.
+	RID string
+	// Loaded is true if the resource is loaded and kept in the cache.
+	Loaded bool
+	// Error is set if the resource failed to load, or was deleted.
+	Error error
+}
+
+// PinRetry holds the settings for retrying pinned resources failing to load.
+type PinRetry struct {
+	// Delay before the first retry, doubled for each failed retry.
+	Backoff time.Duration
+	// Maximum delay between retries.
+	MaxBackoff time.Duration
+}
+
+// pinSubscriber is a subscriber keeping a pinned resource cached,
+// independent of client subscriptions.
+type pinSubscriber struct {
+	c     *Cache
+	rid   string
+	name  string
+	query string
+
+	mu      sync.Mutex
+	loaded  bool
+	err     error
+	backoff time.Duration
+	timer   *time.Timer
+	stopped bool
+}
+
+// SetPinned sets the resources to fetch on Start and keep cached and
+// subscribed for events, and the patterns of resources to keep cached once
+// loaded, independent of client subscriptions. It must be called before
+// Start.
+func (c *Cache) SetPinned(rids []string, patterns []ResourcePattern) {
+	c.pinRIDs = rids
+	c.pinPatterns = patterns
+}
+
+// SetPinRetry sets the settings for retrying pinned resources that fail to
+// load, or are deleted or unsubscribed. If nil, the resources are not
+// retried. It must be called before Start.
+func (c *Cache) SetPinRetry(r *PinRetry) {
+	c.pinRetry = r
+}
+
+// Pinned returns the load status of the resources fetched on Start.
+func (c *Cache) Pinned() []PinStatus {
+	c.mu.Lock()
+	pins := c.pins
+	c.mu.Unlock()
+
+	st := make([]PinStatus, len(pins))
+	for i, p := range pins {
+		p.mu.Lock()
+		st[i] = PinStatus{RID: p.rid, Loaded: p.loaded, Error: p.err}
+		p.mu.Unlock()
+	}
+	return st
+}
+
+// prewarm subscribes to the pinned resources, sending get requests.
+func (c *Cache) prewarm() {
+	pins := make([]*pinSubscriber, len(c.pinRIDs))
+	for i, rid := range c.pinRIDs {
+		name, query := rid, ""
+		if idx := strings.IndexByte(rid, '?'); idx >= 0 {
+			name, query = rid[:idx], rid[idx+1:]
+		}
+		pins[i] = &pinSubscriber{c: c, rid: rid, name: name, query: query}
+	}
+	c.mu.Lock()
+	c.pins = pins
+	c.mu.Unlock()
+
+	for _, p := range pins {
+		c.Subscribe(p)
+	}
+}
+
+// stopPins stops any scheduled retries of the pinned resources.
+func (c *Cache) stopPins() {
+	c.mu.Lock()
+	pins := c.pins
+	c.mu.Unlock()
+
+	for _, p := range pins {
+		p.mu.Lock()
+		p.stopped = true
+		if p.timer != nil {
+			p.timer.Stop()
+			p.timer = nil
+		}
+		p.mu.Unlock()
+	}
+}
+
+// isPinnedPattern reports whether the resource name matches any of the
+// pinned patterns.
+func (c *Cache) isPinnedPattern(name string) bool {
+	for _, p := range c.pinPatterns {
+		if p.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pinSubscriber) CID() string           { return "" }
+func (p *pinSubscriber) ResourceName() string  { return p.name }
+func (p *pinSubscriber) ResourceQuery() string { return p.query }
+func (p *pinSubscriber) Reaccess()             {}
+
+func (p *pinSubscriber) Loaded(rs *ResourceSubscription, err error) {
+	if err != nil {
+		p.fail(err)
+		return
+	}
+
+	p.mu.Lock()
+	retried := p.backoff > 0
+	p.loaded = true
+	p.err = nil
+	p.backoff = 0
+	p.mu.Unlock()
+
+	if retried {
+		p.c.Logf("Pinned resource %s loaded", p.rid)
+		return
+	}
+	p.c.Debugf("Prewarmed %s", p.rid)
+}
+
+func (p *pinSubscriber) Event(ev *ResourceEvent) {
+	switch ev.Event {
+	case "delete":
+		p.c.Logf("Pinned resource %s deleted", p.rid)
+		p.fail(reserr.ErrNotFound)
+	case "unsubscribe":
+		p.fail(ev.Error)
+	}
+}
+
+// fail sets the error of the pinned resource, no longer subscribed, and
+// schedules a new subscription with backoff. Only the first failure is logged
+// as an error.
+func (p *pinSubscriber) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	first := p.backoff == 0
+	p.loaded = false
+	p.err = err
+
+	r := p.c.pinRetry
+	if r == nil || p.stopped {
+		if first {
+			p.c.Errorf("Error prewarming %s: %s", p.rid, err)
+		}
+		return
+	}
+
+	if first {
+		p.backoff = r.Backoff
+		p.c.Errorf("Error prewarming %s: %s. Retrying in %s", p.rid, err, p.backoff)
+	} else {
+		p.backoff *= 2
+		if p.backoff > r.MaxBackoff {
+			p.backoff = r.MaxBackoff
+		}
+		p.c.Debugf("Error prewarming %s: %s. Retrying in %s", p.rid, err, p.backoff)
+	}
+
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.timer = time.AfterFunc(p.backoff, p.retry)
+}
+
+// retry subscribes to the pinned resource again, sending a new get request.
+func (p *pinSubscriber) retry() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.timer = nil
+	p.mu.Unlock()
+
+	p.c.Subscribe(p)
+}
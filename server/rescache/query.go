@@ -0,0 +1,131 @@
+package rescache
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxLearnedQueries is the maximum number of service normalized queries
+// remembered per resource, before the learned queries are cleared.
+const maxLearnedQueries = 256
+
+// QueryNormalization holds the settings for normalizing the queries of
+// resources matching a pattern, letting equivalent queries share cache
+// entries.
+type QueryNormalization struct {
+	Pattern ResourcePattern
+	// Sort query parameters by key, keeping the order of repeated keys.
+	SortParams bool
+	// Remove query parameters with empty values.
+	RemoveEmpty bool
+	// Lowercase query parameter keys.
+	LowercaseKeys bool
+}
+
+// SetQueryNormalizations sets the query normalizations, matched in order by
+// resource name. For matching resources, normalized queries returned by the
+// service are also remembered, linking equivalent queries before sending any
+// get request. It must be called before Start.
+func (c *Cache) SetQueryNormalizations(qns []QueryNormalization) {
+	c.queryCfgs = qns
+}
+
+// queryNormalization returns the query normalization of the first pattern
+// matching the resource name, or nil if no pattern matches.
+func (c *Cache) queryNormalization(name string) *QueryNormalization {
+	for i := range c.queryCfgs {
+		if c.queryCfgs[i].Pattern.Match(name) {
+			return &c.queryCfgs[i]
+		}
+	}
+	return nil
+}
+
+// Normalize returns the normalized query. If all parameters are removed, the
+// query is returned unchanged.
+func (qn *QueryNormalization) Normalize(q string) string {
+	params := strings.Split(q, "&")
+	n := params[:0]
+	for _, p := range params {
+		k, rest := p, ""
+		if i := strings.IndexByte(p, '='); i >= 0 {
+			k, rest = p[:i], p[i:]
+		}
+		if qn.RemoveEmpty && len(rest) <= 1 {
+			continue
+		}
+		if qn.LowercaseKeys {
+			k = strings.ToLower(k)
+		}
+		n = append(n, k+rest)
+	}
+	if len(n) == 0 {
+		return q
+	}
+	if qn.SortParams {
+		sort.SliceStable(n, func(i, j int) bool {
+			return queryKey(n[i]) < queryKey(n[j])
+		})
+	}
+	return strings.Join(n, "&")
+}
+
+// queryKey returns the key of a query parameter.
+func queryKey(p string) string {
+	if i := strings.IndexByte(p, '='); i >= 0 {
+		return p[:i]
+	}
+	return p
+}
+
+// normalizeQuery returns the query used as key for the resource
+// subscription, normalized and replaced by any learned service normalized
+// query.
+func (e *EventSubscription) normalizeQuery(q string) string {
+	if q == "" {
+		return q
+	}
+	qn := e.cache.queryNormalization(e.ResourceName)
+	if qn == nil {
+		return q
+	}
+	q = qn.Normalize(q)
+	if nq, ok := e.learned[q]; ok {
+		return nq
+	}
+	return q
+}
+
+// learnQuery remembers the service normalized query for a query, if query
+// normalization applies to the resource.
+func (e *EventSubscription) learnQuery(q, nq string) {
+	if q == "" || e.cache.queryNormalization(e.ResourceName) == nil {
+		return
+	}
+	if e.learned == nil || len(e.learned) >= maxLearnedQueries {
+		e.learned = make(map[string]string)
+	}
+	e.learned[q] = nq
+}
+
+// SetMaxQueries sets the maximum number of query variants cached per
+// resource. Subscribing to a new query variant beyond the limit fails with
+// reserr.ErrTooManyQueries. Zero means no limit. It must be called before
+// Start.
+func (c *Cache) SetMaxQueries(n int) {
+	c.maxQueries = n
+}
+
+// isQueryLimitReached reports whether the query is a new query variant that
+// would exceed the maximum number of cached query variants.
+func (e *EventSubscription) isQueryLimitReached(q string) bool {
+	max := e.cache.maxQueries
+	if q == "" || max <= 0 || len(e.queries) < max {
+		return false
+	}
+	if _, ok := e.queries[q]; ok {
+		return false
+	}
+	_, ok := e.links[q]
+	return !ok
+}
@@ -0,0 +1,31 @@
+package rescache_test
+
+import (
+	"testing"
+
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+func TestQueryNormalization_Normalize(t *testing.T) {
+	tbl := []struct {
+		QN       rescache.QueryNormalization
+		Query    string
+		Expected string
+	}{
+		{rescache.QueryNormalization{}, "b=2&a=1", "b=2&a=1"},
+		{rescache.QueryNormalization{SortParams: true}, "b=2&a=1", "a=1&b=2"},
+		{rescache.QueryNormalization{SortParams: true}, "b=2&a=1&b=1", "a=1&b=2&b=1"},
+		{rescache.QueryNormalization{SortParams: true}, "b&a=1", "a=1&b"},
+		{rescache.QueryNormalization{RemoveEmpty: true}, "b=&a=1&c", "a=1"},
+		{rescache.QueryNormalization{RemoveEmpty: true}, "a=&b", "a=&b"},
+		{rescache.QueryNormalization{LowercaseKeys: true}, "B=Foo&a=1", "b=Foo&a=1"},
+		{rescache.QueryNormalization{SortParams: true, RemoveEmpty: true, LowercaseKeys: true}, "C=3&b=&A=1", "a=1&c=3"},
+	}
+
+	for i, r := range tbl {
+		got := r.QN.Normalize(r.Query)
+		if got != r.Expected {
+			t.Errorf("test #%d: expected %#v, but got %#v", i+1, r.Expected, got)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package rescache
+
+import (
+	"time"
+)
+
+// RefreshInterval is the interval for the cache to refresh resources matching
+// a pattern, for services unable to send events.
+type RefreshInterval struct {
+	Pattern  ResourcePattern
+	Interval time.Duration
+}
+
+// SetRefreshIntervals sets the refresh intervals, matched in order by resource
+// name. A refresh interval in a get response overrides the matching
+// interval. It must be called before Start.
+func (c *Cache) SetRefreshIntervals(ris []RefreshInterval) {
+	c.refreshCfgs = ris
+}
+
+// refreshInterval returns the refresh interval of the first pattern matching
+// the resource name, or 0 if no pattern matches.
+func (c *Cache) refreshInterval(name string) time.Duration {
+	for _, ri := range c.refreshCfgs {
+		if ri.Pattern.Match(name) {
+			return ri.Interval
+		}
+	}
+	return 0
+}
+
+// startRefresh sets the refresh interval, in milliseconds, from a get
+// response, falling back to any configured interval, and schedules the
+// first refresh.
+func (rs *ResourceSubscription) startRefresh(refresh int) {
+	if refresh > 0 {
+		rs.refreshInterval = time.Duration(refresh) * time.Millisecond
+	} else {
+		rs.refreshInterval = rs.e.cache.refreshInterval(rs.e.ResourceName)
+	}
+	rs.scheduleRefresh()
+}
+
+// scheduleRefresh starts a timer for the next refresh, replacing any
+// previous timer.
+func (rs *ResourceSubscription) scheduleRefresh() {
+	rs.stopRefresh()
+	if rs.refreshInterval <= 0 {
+		return
+	}
+	gen := rs.refreshGen
+	rs.refreshTimer = time.AfterFunc(rs.refreshInterval, func() {
+		rs.e.Enqueue(func() {
+			rs.handleRefresh(gen)
+		})
+	})
+}
+
+// stopRefresh stops any scheduled refresh. Refreshes already enqueued are
+// discarded.
+func (rs *ResourceSubscription) stopRefresh() {
+	rs.refreshGen++
+	if rs.refreshTimer != nil {
+		rs.refreshTimer.Stop()
+		rs.refreshTimer = nil
+	}
+}
+
+// handleRefresh sends a get request if the resource has subscribers, and
+// schedules the next refresh. The response is handled as for a system reset,
+// sending events to the subscribers for any difference.
+func (rs *ResourceSubscription) handleRefresh(gen uint) {
+	if gen != rs.refreshGen {
+		return
+	}
+	rs.refreshTimer = nil
+	if len(rs.subs) > 0 {
+		rs.handleResetResource()
+	}
+	rs.scheduleRefresh()
+}
+
+// stopRefresh stops the scheduled refreshes of all the resources of the
+// event subscription.
+func (e *EventSubscription) stopRefresh() {
+	if e.base != nil {
+		e.base.stopRefresh()
+	}
+	for _, rs := range e.queries {
+		rs.stopRefresh()
+	}
+	for _, rs := range e.links {
+		rs.stopRefresh()
+	}
+}
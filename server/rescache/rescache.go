@@ -1,10 +1,12 @@
 package rescache
 
 import (
+	"container/list"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jirenius/timerqueue"
@@ -20,13 +22,36 @@ type Cache struct {
 	logger           logger.Logger
 	workers          int
 	unsubscribeDelay time.Duration
+	delayCfgs        []UnsubscribeDelay
+	refreshCfgs      []RefreshInterval
+	bulkheadCfgs     []Bulkhead
+	maxSize          int64
+	stale            *StaleIfError
+	getRetry         *GetRetry
+	queryCfgs        []QueryNormalization
+	maxQueries       int
+	pinRIDs          []string
+	pinPatterns      []ResourcePattern
+	pinRetry         *PinRetry
 
 	mu         sync.Mutex
 	started    bool
 	eventSubs  map[string]*EventSubscription
 	inCh       chan *EventSubscription
 	unsubQueue *timerqueue.Queue
-	resetSub   mq.Unsubscriber
+	// Queues for the unsubscribe delays matched by resource pattern
+	delayQueues []*timerqueue.Queue
+	resetSub    mq.Unsubscriber
+	bulkheads   []*bulkhead
+	overLimit   bool
+	pins        []*pinSubscriber
+
+	// Size accounting
+	size        int64 // Atomic
+	resetErrors int64 // Atomic
+	evicting    int32 // Atomic
+	lruMu       sync.Mutex
+	lru         *list.List
 
 	// Deprecated behavior logging
 	depMutex  sync.Mutex
@@ -48,9 +73,18 @@ type ResourceEvent struct {
 	Event     string
 	Payload   json.RawMessage
 	Idx       int
+	From      int
+	To        int
 	Value     codec.Value
+	Values    []codec.Value
 	Changed   map[string]codec.Value
 	OldValues map[string]codec.Value
+	Error     error
+	// Collection is the immutable collection resulting from an add, remove,
+	// move, or reset event.
+	Collection *Collection
+	// Model is the immutable model resulting from a change event.
+	Model *Model
 }
 
 // NewCache creates a new Cache instance
@@ -69,6 +103,26 @@ func (c *Cache) SetLogger(l logger.Logger) {
 	c.logger = l
 }
 
+// UnsubscribeDelay is the delay for the cache to unsubscribe and evict
+// resources matching a pattern, once no longer used.
+type UnsubscribeDelay struct {
+	Pattern ResourcePattern
+	Delay   time.Duration
+}
+
+// SetUnsubscribeDelays sets the unsubscribe delays, matched in order by
+// resource name, overriding the default delay. It must be called before
+// Start.
+func (c *Cache) SetUnsubscribeDelays(ds []UnsubscribeDelay) {
+	c.delayCfgs = ds
+}
+
+// SetBulkheads sets the limits of concurrent outstanding requests, matched in
+// order by resource name. It must be called before Start.
+func (c *Cache) SetBulkheads(bs []Bulkhead) {
+	c.bulkheadCfgs = bs
+}
+
 // Start will initialize the cache, subscribing to global events
 // It is assumed mq.Connect has already been called
 func (c *Cache) Start() error {
@@ -78,7 +132,19 @@ func (c *Cache) Start() error {
 	inCh := make(chan *EventSubscription, 100)
 	c.eventSubs = make(map[string]*EventSubscription)
 	c.unsubQueue = timerqueue.New(c.mqUnsubscribe, c.unsubscribeDelay)
+	c.delayQueues = make([]*timerqueue.Queue, len(c.delayCfgs))
+	for i, d := range c.delayCfgs {
+		c.delayQueues[i] = timerqueue.New(c.mqUnsubscribe, d.Delay)
+	}
 	c.inCh = inCh
+	c.lruMu.Lock()
+	c.lru = list.New()
+	c.lruMu.Unlock()
+	atomic.StoreInt64(&c.size, 0)
+	c.bulkheads = make([]*bulkhead, len(c.bulkheadCfgs))
+	for i, b := range c.bulkheadCfgs {
+		c.bulkheads[i] = newBulkhead(b)
+	}
 
 	for i := 0; i < c.workers; i++ {
 		go c.startWorker(inCh)
@@ -98,6 +164,7 @@ func (c *Cache) Start() error {
 
 	c.resetSub = resetSub
 	c.started = true
+	c.prewarm()
 	return nil
 }
 
@@ -106,6 +173,13 @@ func (c *Cache) Logf(format string, v ...interface{}) {
 	c.logger.Log(fmt.Sprintf(format, v...))
 }
 
+// Debugf writes a formatted debug message
+func (c *Cache) Debugf(format string, v ...interface{}) {
+	if c.logger.IsDebug() {
+		c.logger.Debug(fmt.Sprintf(format, v...))
+	}
+}
+
 // Errorf writes a formatted log message
 func (c *Cache) Errorf(format string, v ...interface{}) {
 	c.logger.Error(fmt.Sprintf(format, v...))
@@ -184,7 +258,7 @@ func (c *Cache) Auth(req codec.AuthRequester, rname, query, action string, token
 
 func (c *Cache) sendRequest(rname, subj string, payload []byte, cb func(data []byte, err error)) {
 	eventSub, _ := c.getSubscription(rname, false)
-	c.mq.SendRequest(subj, payload, func(_ string, data []byte, err error) {
+	c.mqSendRequest(rname, subj, payload, func(_ string, data []byte, err error) {
 		eventSub.Enqueue(func() {
 			cb(data, err)
 			eventSub.removeCount(1)
@@ -203,6 +277,7 @@ func (c *Cache) getSubscription(name string, subscribe bool) (*EventSubscription
 		eventSub = &EventSubscription{
 			ResourceName: name,
 			cache:        c,
+			unsubQueue:   c.unsubscribeQueue(name),
 			count:        1,
 		}
 
@@ -222,6 +297,12 @@ func (c *Cache) getSubscription(name string, subscribe bool) (*EventSubscription
 		eventSub.mqSub = mqSub
 	}
 
+	if subscribe && c.isPinnedPattern(name) {
+		eventSub.mu.Lock()
+		eventSub.pinned = true
+		eventSub.mu.Unlock()
+	}
+
 	return eventSub, nil
 }
 
@@ -231,12 +312,40 @@ func (c *Cache) Stop() {
 	if !c.started {
 		return
 	}
+	c.mu.Lock()
+	for _, e := range c.eventSubs {
+		e.mu.Lock()
+		e.stopRefresh()
+		e.stopStaleRetry()
+		e.stopGetRetry()
+		e.mu.Unlock()
+	}
+	c.mu.Unlock()
+	c.stopPins()
 	close(c.inCh)
 	c.unsubQueue.Clear()
+	for _, q := range c.delayQueues {
+		q.Clear()
+	}
+	c.lruMu.Lock()
+	c.lru = nil
+	c.lruMu.Unlock()
 	c.resetSub = nil
 	c.started = false
 }
 
+// unsubscribeQueue returns the unsubscribe queue with the delay of the first
+// pattern matching the resource name, or the default queue if no pattern
+// matches.
+func (c *Cache) unsubscribeQueue(name string) *timerqueue.Queue {
+	for i, d := range c.delayCfgs {
+		if d.Pattern.Match(name) {
+			return c.delayQueues[i]
+		}
+	}
+	return c.unsubQueue
+}
+
 func (c *Cache) startWorker(ch chan *EventSubscription) {
 	for eventSub := range ch {
 		eventSub.processQueue()
@@ -3,6 +3,7 @@ package rescache
 import (
 	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/resgateio/resgate/server/codec"
 	"github.com/resgateio/resgate/server/reserr"
@@ -64,6 +65,20 @@ type ResourceSubscription struct {
 	subs      map[Subscriber]struct{}
 	resetting bool
 	links     []string
+	size      int64 // Approximate size in bytes
+	// Refresh of resources from services not sending events
+	refreshInterval time.Duration
+	refreshTimer    *time.Timer
+	refreshGen      uint
+	// Stale state while retrying failed reset get requests
+	staleSince   time.Time
+	staleBackoff time.Duration
+	staleTimer   *time.Timer
+	staleGen     uint
+	// Retries of get requests failing with a temporary error
+	getRetries int
+	retryTimer *time.Timer
+	retryGen   uint
 	// Three types of values stored
 	model      *Model
 	collection *Collection
@@ -149,6 +164,16 @@ func (rs *ResourceSubscription) handleEvent(r *ResourceEvent) {
 		if rs.resetting || !rs.handleEventRemove(r) {
 			return
 		}
+	case "move":
+		// Move events on models are passed on as custom events, as move
+		// was not a reserved event name prior to protocol version 1.2.2.
+		if rs.state != stateModel && (rs.resetting || !rs.handleEventMove(r)) {
+			return
+		}
+	case "reset":
+		if rs.resetting || !rs.handleEventReset(r) {
+			return
+		}
 	case "delete":
 		if !rs.resetting {
 			rs.handleEventDelete(r)
@@ -192,18 +217,27 @@ func (rs *ResourceSubscription) handleEventChange(r *ResourceEvent) bool {
 	}
 
 	// Update model properties
+	var delta int64
 	for k, v := range props {
 		if v.Type == codec.ValueTypeDelete {
-			if _, ok := m[k]; ok {
+			if ov, ok := m[k]; ok {
 				delete(m, k)
+				delta -= int64(len(k)) + valueSize(ov)
 			} else {
 				delete(props, k)
 			}
 		} else {
-			if m[k].Equal(v) {
+			ov, ok := m[k]
+			if ov.Equal(v) {
 				delete(props, k)
 			} else {
 				m[k] = v
+				delta += valueSize(v)
+				if ok {
+					delta -= valueSize(ov)
+				} else {
+					delta += int64(len(k))
+				}
 			}
 		}
 	}
@@ -212,10 +246,12 @@ func (rs *ResourceSubscription) handleEventChange(r *ResourceEvent) bool {
 	if len(props) == 0 {
 		return false
 	}
+	rs.addSize(delta)
 
 	r.Changed = props
 	r.OldValues = rs.model.Values
 	rs.model = &Model{Values: m}
+	r.Model = rs.model
 	return true
 }
 
@@ -248,6 +284,8 @@ func (rs *ResourceSubscription) handleEventAdd(r *ResourceEvent) bool {
 	col[idx] = params.Value
 
 	rs.collection = &Collection{Values: col}
+	rs.addSize(valueSize(params.Value))
+	r.Collection = rs.collection
 	r.Idx = params.Idx
 	r.Value = params.Value
 
@@ -282,11 +320,71 @@ func (rs *ResourceSubscription) handleEventRemove(r *ResourceEvent) bool {
 	copy(col, old[0:idx])
 	copy(col[idx:], old[idx+1:])
 	rs.collection = &Collection{Values: col}
+	rs.addSize(-valueSize(r.Value))
+	r.Collection = rs.collection
 	r.Idx = params.Idx
 
 	return true
 }
 
+func (rs *ResourceSubscription) handleEventMove(r *ResourceEvent) bool {
+	params, err := codec.DecodeMoveEvent(r.Payload)
+	if err != nil {
+		rs.e.cache.Errorf("Error processing event %s.%s: %s", rs.e.ResourceName, r.Event, err)
+		return false
+	}
+
+	from := params.From
+	to := params.To
+	old := rs.collection.Values
+	l := len(old)
+
+	if from < 0 || from >= l {
+		rs.e.cache.Errorf("Error processing event %s.%s: from %d is out of bounds", rs.e.ResourceName, r.Event, from)
+		return false
+	}
+	if to < 0 || to >= l {
+		rs.e.cache.Errorf("Error processing event %s.%s: to %d is out of bounds", rs.e.ResourceName, r.Event, to)
+		return false
+	}
+
+	v := old[from]
+	// Copy collection as the old slice might have been
+	// passed to a Subscriber and should be considered immutable
+	col := make([]codec.Value, l)
+	copy(col, old)
+	if from < to {
+		copy(col[from:], old[from+1:to+1])
+	} else {
+		copy(col[to+1:], old[to:from])
+	}
+	col[to] = v
+
+	// The collection size is unchanged
+	rs.collection = &Collection{Values: col}
+	r.Collection = rs.collection
+	r.From = from
+	r.To = to
+	r.Value = v
+
+	return true
+}
+
+// handleEventReset replaces all collection values. The event is only
+// generated by the cache, when a collection differs too much to be updated
+// by events.
+func (rs *ResourceSubscription) handleEventReset(r *ResourceEvent) bool {
+	if rs.state != stateCollection {
+		rs.e.cache.Errorf("Error processing event %s.%s: reset event on model", rs.e.ResourceName, r.Event)
+		return false
+	}
+
+	rs.collection = &Collection{Values: r.Values}
+	rs.setLoadedSize()
+	r.Collection = rs.collection
+	return true
+}
+
 func (rs *ResourceSubscription) handleEventDelete(r *ResourceEvent) {
 	subs := rs.subs
 	c := int64(len(subs))
@@ -335,6 +433,10 @@ func (rs *ResourceSubscription) unregister() {
 		}
 	}
 	rs.links = nil
+	rs.addSize(-rs.size)
+	rs.stopRefresh()
+	rs.stopStaleRetry()
+	rs.stopGetRetry()
 }
 
 func (rs *ResourceSubscription) processGetResponse(payload []byte, err error) (nrs *ResourceSubscription, sublist []Subscriber) {
@@ -347,6 +449,12 @@ func (rs *ResourceSubscription) processGetResponse(payload []byte, err error) (n
 
 	// Get request failed
 	if err != nil {
+		// Let subscribers wait while retrying temporary errors
+		if rs.retryGet(err) {
+			nrs = rs
+			return
+		}
+
 		// Set state and store the error in case any other
 		// subscriber are waiting on the Lock to subscribe
 		rs.state = stateError
@@ -372,8 +480,9 @@ func (rs *ResourceSubscription) processGetResponse(payload []byte, err error) (n
 	// Is the normalized query in the response different from the
 	// one requested by the Subscriber?
 	// Then we should create a link to the normalized query
-	if result.Query != rs.query {
-		nrs = rs.e.getResourceSubscription(result.Query)
+	if nq := rs.e.normalizeQuery(result.Query); nq != rs.query {
+		rs.e.learnQuery(rs.query, nq)
+		nrs = rs.e.getResourceSubscription(nq)
 		if rs.query == "" {
 			rs.e.base = nrs
 		} else {
@@ -420,21 +529,66 @@ func (rs *ResourceSubscription) processGetResponse(payload []byte, err error) (n
 		nrs.collection = &Collection{Values: result.Collection}
 		nrs.state = stateCollection
 	}
+	nrs.setLoadedSize()
+	nrs.startRefresh(result.Refresh)
 	return
 }
 
+// handleEventQueryResult applies the result of a query request in response to
+// a query event. In case of a system.notFound error, a delete event is
+// generated. Otherwise errors are logged.
+func (rs *ResourceSubscription) handleEventQueryResult(result *codec.EventQueryResult, err error, data []byte) {
+	if err != nil {
+		if reserr.IsError(err, reserr.CodeNotFound) {
+			rs.handleEvent(&ResourceEvent{Event: "delete"})
+		} else {
+			rs.e.cache.Errorf("Error processing query event for %s?%s: %s", rs.e.ResourceName, rs.query, err)
+		}
+		return
+	}
+
+	switch {
+	// Handle array of events
+	case result.Events != nil:
+		for _, ev := range result.Events {
+			rs.handleEvent(&ResourceEvent{Event: ev.Event, Payload: ev.Data})
+		}
+	// Handle model response
+	case result.Model != nil:
+		if rs.state != stateModel {
+			rs.e.cache.Errorf("Error processing query event for %s?%s: non-model payload on model %s", rs.e.ResourceName, rs.query, data)
+			return
+		}
+		rs.processResetModel(result.Model)
+	// Handle collection response
+	case result.Collection != nil:
+		if rs.state != stateCollection {
+			rs.e.cache.Errorf("Error processing query event for %s?%s: non-model payload on model %s", rs.e.ResourceName, rs.query, data)
+			return
+		}
+		rs.processResetCollection(result.Collection)
+	}
+}
+
 func (rs *ResourceSubscription) handleResetResource() {
 	// Are we already resetting. Then quick exit
 	if rs.resetting {
 		return
 	}
 
+	// Is the resource not yet loaded. Then any scheduled get request retry
+	// is sent directly, letting waiting subscribers get the resource.
+	if rs.state <= stateRequested {
+		rs.retryGetNow()
+		return
+	}
+
 	rs.resetting = true
 
 	// Create request
 	subj := "get." + rs.e.ResourceName
 	payload := codec.CreateGetRequest(rs.query)
-	rs.e.cache.mq.SendRequest(subj, payload, func(_ string, data []byte, err error) {
+	rs.e.cache.mqSendRequest(rs.e.ResourceName, subj, payload, func(_ string, data []byte, err error) {
 		rs.e.Enqueue(func() {
 			rs.resetting = false
 			rs.processResetGetResponse(data, err)
@@ -467,10 +621,16 @@ func (rs *ResourceSubscription) processResetGetResponse(payload []byte, err erro
 		if reserr.IsError(err, reserr.CodeNotFound) {
 			rs.handleEvent(&ResourceEvent{Event: "delete"})
 		} else {
-			rs.e.cache.Errorf("Subscription %s: Reset get error - %s", rs.e.ResourceName, err)
+			rs.handleResetError(err)
 		}
 		return
 	}
+	rs.handleResetSuccess()
+
+	// Apply any changed refresh interval on the next refresh
+	if result.Refresh > 0 {
+		rs.refreshInterval = time.Duration(result.Refresh) * time.Millisecond
+	}
 
 	switch rs.state {
 	case stateModel:
@@ -510,120 +670,9 @@ func (rs *ResourceSubscription) processResetModel(props map[string]codec.Value)
 }
 
 func (rs *ResourceSubscription) processResetCollection(collection []codec.Value) {
-	events := lcs(rs.collection.Values, collection)
+	events := diff(rs.collection.Values, collection)
 
 	for _, r := range events {
 		rs.handleEvent(r)
 	}
 }
-
-func lcs(a, b []codec.Value) []*ResourceEvent {
-	var i, j int
-	// Do a LCS matric calculation
-	// https://en.wikipedia.org/wiki/Longest_common_subsequence_problem
-	s := 0
-	m := len(a)
-	n := len(b)
-
-	// Trim of matches at the start and end
-	for s < m && s < n && a[s].Equal(b[s]) {
-		s++
-	}
-
-	if s == m && s == n {
-		return nil
-	}
-
-	for s < m && s < n && a[m-1].Equal(b[n-1]) {
-		m--
-		n--
-	}
-
-	var aa, bb []codec.Value
-	if s > 0 || m < len(a) {
-		aa = a[s:m]
-		m = m - s
-	} else {
-		aa = a
-	}
-	if s > 0 || n < len(b) {
-		bb = b[s:n]
-		n = n - s
-	} else {
-		bb = b
-	}
-
-	// Create matrix and initialize it
-	w := m + 1
-	c := make([]int, w*(n+1))
-
-	for i = 0; i < m; i++ {
-		for j = 0; j < n; j++ {
-			if aa[i].Equal(bb[j]) {
-				c[(i+1)+w*(j+1)] = c[i+w*j] + 1
-			} else {
-				v1 := c[(i+1)+w*j]
-				v2 := c[i+w*(j+1)]
-				if v2 > v1 {
-					c[(i+1)+w*(j+1)] = v2
-				} else {
-					c[(i+1)+w*(j+1)] = v1
-				}
-			}
-		}
-	}
-
-	steps := make([]*ResourceEvent, 0, m+n-2*c[w*(n+1)-1])
-
-	idx := m + s
-	i = m
-	j = n
-	r := 0
-
-	var adds [][3]int
-	addCount := n - c[w*(n+1)-1]
-	if addCount > 0 {
-		adds = make([][3]int, 0, addCount)
-	}
-Loop:
-	for {
-		m = i - 1
-		n = j - 1
-		switch {
-		case i > 0 && j > 0 && aa[m].Equal(bb[n]):
-			idx--
-			i--
-			j--
-		case j > 0 && (i == 0 || c[i+w*n] >= c[m+w*j]):
-			adds = append(adds, [3]int{n, idx, r})
-			j--
-		case i > 0 && (j == 0 || c[i+w*n] < c[m+w*j]):
-			idx--
-			steps = append(steps, &ResourceEvent{
-				Event: "remove",
-				Payload: codec.EncodeRemoveEvent(&codec.RemoveEvent{
-					Idx: idx,
-				}),
-			})
-			r++
-			i--
-		default:
-			break Loop
-		}
-	}
-
-	// Do the adds
-	l := len(adds) - 1
-	for i := l; i >= 0; i-- {
-		add := adds[i]
-		steps = append(steps, &ResourceEvent{
-			Event: "add",
-			Payload: codec.EncodeAddEvent(&codec.AddEvent{
-				Value: bb[add[0]],
-				Idx:   add[1] - r + add[2] + l - i,
-			}),
-		})
-	}
-
-	return steps
-}
@@ -0,0 +1,105 @@
+package rescache
+
+import (
+	"time"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// GetRetry holds the settings for retrying get requests failing with a
+// temporary error.
+type GetRetry struct {
+	// Maximum number of retries.
+	Retries int
+	// Delay before the first retry, doubled for each failed retry.
+	Backoff time.Duration
+	// Maximum delay between retries.
+	MaxBackoff time.Duration
+}
+
+// SetGetRetry sets the settings for retrying get requests failing with a
+// temporary error, such as a timeout or an internal error. Subscribers wait
+// for the resource until loaded, or until the retries are exhausted. If nil,
+// subscribers get the error directly. It must be called before Start.
+func (c *Cache) SetGetRetry(r *GetRetry) {
+	c.getRetry = r
+}
+
+// isTemporary reports whether a get request error is temporary, and the
+// request worth retrying.
+func isTemporary(err error) bool {
+	return reserr.IsError(err, reserr.CodeTimeout) || reserr.IsError(err, reserr.CodeInternalError)
+}
+
+// sendGetRequest sends a get request for the resource, handling the response
+// on the event subscription queue.
+func (rs *ResourceSubscription) sendGetRequest() {
+	subj := "get." + rs.e.ResourceName
+	payload := codec.CreateGetRequest(rs.query)
+	rs.e.cache.mqSendRequest(rs.e.ResourceName, subj, payload, func(_ string, data []byte, err error) {
+		rs.enqueueGetResponse(data, err)
+	})
+}
+
+// retryGet schedules a new get request after a failed one, if the error is
+// temporary and there are retries left. Returns false if no retry is made.
+func (rs *ResourceSubscription) retryGet(err error) bool {
+	r := rs.e.cache.getRetry
+	if r == nil || rs.getRetries >= r.Retries || !isTemporary(err) {
+		return false
+	}
+
+	backoff := r.Backoff << uint(rs.getRetries)
+	if backoff > r.MaxBackoff || backoff < r.Backoff {
+		backoff = r.MaxBackoff
+	}
+	rs.getRetries++
+	rs.e.cache.Debugf("Subscription %s: Get error - %s. Retrying in %s", rs.e.ResourceName, err, backoff)
+
+	rs.stopGetRetry()
+	gen := rs.retryGen
+	rs.retryTimer = time.AfterFunc(backoff, func() {
+		rs.e.Enqueue(func() {
+			if gen != rs.retryGen || rs.state != stateRequested {
+				return
+			}
+			rs.retryTimer = nil
+			rs.sendGetRequest()
+		})
+	})
+	return true
+}
+
+// retryGetNow sends any scheduled get request retry directly.
+func (rs *ResourceSubscription) retryGetNow() {
+	if rs.retryTimer == nil || rs.state != stateRequested {
+		return
+	}
+	rs.stopGetRetry()
+	rs.sendGetRequest()
+}
+
+// stopGetRetry stops any scheduled get request retry. Retries already
+// enqueued are discarded.
+func (rs *ResourceSubscription) stopGetRetry() {
+	rs.retryGen++
+	if rs.retryTimer != nil {
+		rs.retryTimer.Stop()
+		rs.retryTimer = nil
+	}
+}
+
+// stopGetRetry stops the scheduled get request retries of all the resources
+// of the event subscription.
+func (e *EventSubscription) stopGetRetry() {
+	if e.base != nil {
+		e.base.stopGetRetry()
+	}
+	for _, rs := range e.queries {
+		rs.stopGetRetry()
+	}
+	for _, rs := range e.links {
+		rs.stopGetRetry()
+	}
+}
@@ -0,0 +1,204 @@
+package rescache
+
+import (
+	"sync/atomic"
+
+	"github.com/resgateio/resgate/server/codec"
+)
+
+// Approximate memory overhead, in bytes, of cached structures.
+const (
+	// resourceSizeOverhead is the overhead of a ResourceSubscription with
+	// its Model or Collection.
+	resourceSizeOverhead = 256
+	// valueSizeOverhead is the overhead of a codec.Value, including its map
+	// entry or slice element.
+	valueSizeOverhead = 88
+)
+
+// Stats holds the cache totals.
+type Stats struct {
+	// Number of cached resources, including unsubscribed resources.
+	Resources int
+	// Number of cached resources without subscriptions, awaiting eviction.
+	Unsubscribed int
+	// Approximate total size in bytes of the cached resources.
+	Size int64
+	// Maximum size in bytes, or 0 if unlimited.
+	MaxSize int64
+	// Number of failed reset get requests.
+	ResetErrors int64
+}
+
+// SetMaxSize sets the maximum approximate size in bytes of the cached
+// resources. When exceeded, unsubscribed resources are evicted in least
+// recently used order without waiting for the unsubscribe delay.
+// Zero means no limit. It must be called before Start.
+func (c *Cache) SetMaxSize(size int64) {
+	c.maxSize = size
+}
+
+// Stats returns the cache totals.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	resources := len(c.eventSubs)
+	c.mu.Unlock()
+
+	c.lruMu.Lock()
+	unsubscribed := 0
+	if c.lru != nil {
+		unsubscribed = c.lru.Len()
+	}
+	c.lruMu.Unlock()
+
+	return Stats{
+		Resources:    resources,
+		Unsubscribed: unsubscribed,
+		Size:         atomic.LoadInt64(&c.size),
+		MaxSize:      c.maxSize,
+		ResetErrors:  atomic.LoadInt64(&c.resetErrors),
+	}
+}
+
+// valueSize returns the approximate size in bytes of a value.
+func valueSize(v codec.Value) int64 {
+	return int64(valueSizeOverhead + len(v.RawMessage) + len(v.RID) + len(v.Inner))
+}
+
+// modelSize returns the approximate size in bytes of model values.
+func modelSize(m map[string]codec.Value) int64 {
+	var size int64
+	for k, v := range m {
+		size += int64(len(k)) + valueSize(v)
+	}
+	return size
+}
+
+// collectionSize returns the approximate size in bytes of collection
+// values.
+func collectionSize(c []codec.Value) int64 {
+	var size int64
+	for _, v := range c {
+		size += valueSize(v)
+	}
+	return size
+}
+
+// setLoadedSize sets the size of a resource subscription with a loaded
+// model or collection.
+func (rs *ResourceSubscription) setLoadedSize() {
+	size := int64(resourceSizeOverhead + len(rs.query))
+	switch rs.state {
+	case stateModel:
+		size += modelSize(rs.model.Values)
+	case stateCollection:
+		size += collectionSize(rs.collection.Values)
+	}
+	rs.addSize(size - rs.size)
+}
+
+// addSize adds to the size of the resource subscription.
+func (rs *ResourceSubscription) addSize(delta int64) {
+	rs.size += delta
+	rs.e.addSize(delta)
+}
+
+// addSize adds to the size of the event subscription and the cache total.
+// The event subscription must be locked.
+func (e *EventSubscription) addSize(delta int64) {
+	if delta == 0 {
+		return
+	}
+	e.size += delta
+	total := atomic.AddInt64(&e.cache.size, delta)
+	if delta > 0 && e.cache.maxSize > 0 && total > e.cache.maxSize {
+		e.cache.triggerEvict()
+	}
+}
+
+// lruPush adds an unsubscribed event subscription as the most recently
+// used.
+func (c *Cache) lruPush(e *EventSubscription) {
+	c.lruMu.Lock()
+	defer c.lruMu.Unlock()
+	if c.lru == nil || e.lruEl != nil {
+		return
+	}
+	e.lruEl = c.lru.PushBack(e)
+	if c.maxSize > 0 && atomic.LoadInt64(&c.size) > c.maxSize {
+		c.triggerEvict()
+	}
+}
+
+// lruRemove removes an event subscription from the unsubscribed list.
+func (c *Cache) lruRemove(e *EventSubscription) {
+	c.lruMu.Lock()
+	defer c.lruMu.Unlock()
+	if e.lruEl != nil {
+		c.lru.Remove(e.lruEl)
+		e.lruEl = nil
+	}
+}
+
+// lruPop removes and returns the least recently used unsubscribed event
+// subscription, or nil if there is none.
+func (c *Cache) lruPop() *EventSubscription {
+	c.lruMu.Lock()
+	defer c.lruMu.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	el := c.lru.Front()
+	if el == nil {
+		return nil
+	}
+	e := c.lru.Remove(el).(*EventSubscription)
+	e.lruEl = nil
+	return e
+}
+
+// triggerEvict starts evicting resources, unless eviction is already in
+// progress.
+func (c *Cache) triggerEvict() {
+	if atomic.CompareAndSwapInt32(&c.evicting, 0, 1) {
+		go func() {
+			c.evict()
+			atomic.StoreInt32(&c.evicting, 0)
+		}()
+	}
+}
+
+// evict evicts unsubscribed resources in least recently used order until
+// the cache size is within the limit.
+func (c *Cache) evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lruMu.Lock()
+	stopped := c.lru == nil
+	c.lruMu.Unlock()
+	if stopped {
+		return
+	}
+
+	evicted := 0
+	for atomic.LoadInt64(&c.size) > c.maxSize {
+		e := c.lruPop()
+		if e == nil {
+			if !c.overLimit {
+				c.overLimit = true
+				c.Logf("Cache size %d exceeds limit %d with no unsubscribed resources to evict", atomic.LoadInt64(&c.size), c.maxSize)
+			}
+			return
+		}
+		e.unsubQueue.Remove(e)
+		if e.mqUnsubscribe() {
+			delete(c.eventSubs, e.ResourceName)
+			evicted++
+		}
+	}
+	if evicted > 0 {
+		c.Debugf("Evicted %d resources to keep cache size within limit %d", evicted, c.maxSize)
+	}
+	c.overLimit = false
+}
@@ -0,0 +1,121 @@
+package rescache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// StaleIfError holds the settings for serving stale cached resources while
+// retrying failed reset get requests.
+type StaleIfError struct {
+	// Duration to serve a stale resource before unsubscribing its
+	// subscribers with the error.
+	MaxStale time.Duration
+	// Delay before the first retry, doubled for each failed retry.
+	Backoff time.Duration
+	// Maximum delay between retries.
+	MaxBackoff time.Duration
+}
+
+// SetStaleIfError sets the settings for serving stale cached resources when
+// reset get requests fail. If nil, failures are logged and the cached
+// resource is kept without retrying. It must be called before Start.
+func (c *Cache) SetStaleIfError(s *StaleIfError) {
+	c.stale = s
+}
+
+// handleResetError logs and counts a failed reset get request. If stale
+// resources are served, the request is retried with backoff until the
+// staleness limit is reached, after which the subscribers are unsubscribed
+// with the error.
+func (rs *ResourceSubscription) handleResetError(err error) {
+	c := rs.e.cache
+	atomic.AddInt64(&c.resetErrors, 1)
+	c.Errorf("Subscription %s: Reset get error - %s", rs.e.ResourceName, err)
+
+	s := c.stale
+	if s == nil {
+		return
+	}
+
+	now := time.Now()
+	if rs.staleSince.IsZero() {
+		rs.staleSince = now
+		rs.staleBackoff = s.Backoff
+	} else {
+		rs.staleBackoff *= 2
+		if rs.staleBackoff > s.MaxBackoff {
+			rs.staleBackoff = s.MaxBackoff
+		}
+	}
+
+	if now.Sub(rs.staleSince) >= s.MaxStale {
+		rs.handleStaleExpired(err)
+		return
+	}
+
+	rs.stopStaleRetry()
+	gen := rs.staleGen
+	rs.staleTimer = time.AfterFunc(rs.staleBackoff, func() {
+		rs.e.Enqueue(func() {
+			if gen != rs.staleGen {
+				return
+			}
+			rs.staleTimer = nil
+			rs.handleResetResource()
+		})
+	})
+}
+
+// handleResetSuccess clears any stale state after a successful reset get
+// request.
+func (rs *ResourceSubscription) handleResetSuccess() {
+	if rs.staleSince.IsZero() {
+		return
+	}
+	rs.stopStaleRetry()
+	rs.staleSince = time.Time{}
+	rs.staleBackoff = 0
+}
+
+// handleStaleExpired unregisters a resource that has been stale for too long,
+// and unsubscribes its subscribers with the error.
+func (rs *ResourceSubscription) handleStaleExpired(err error) {
+	rs.e.cache.Errorf("Subscription %s: Stale resource expired", rs.e.ResourceName)
+	subs := rs.subs
+	c := int64(len(subs))
+	rs.subs = nil
+	rs.unregister()
+	rs.e.removeCount(c)
+
+	r := &ResourceEvent{Event: "unsubscribe", Error: err}
+	rs.e.mu.Unlock()
+	for sub := range subs {
+		sub.Event(r)
+	}
+	rs.e.mu.Lock()
+}
+
+// stopStaleRetry stops any scheduled retry. Retries already enqueued are
+// discarded.
+func (rs *ResourceSubscription) stopStaleRetry() {
+	rs.staleGen++
+	if rs.staleTimer != nil {
+		rs.staleTimer.Stop()
+		rs.staleTimer = nil
+	}
+}
+
+// stopStaleRetry stops the scheduled retries of all the resources of the
+// event subscription.
+func (e *EventSubscription) stopStaleRetry() {
+	if e.base != nil {
+		e.base.stopStaleRetry()
+	}
+	for _, rs := range e.queries {
+		rs.stopStaleRetry()
+	}
+	for _, rs := range e.links {
+		rs.stopStaleRetry()
+	}
+}
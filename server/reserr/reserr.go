@@ -74,5 +74,7 @@ var (
 	ErrBadRequest         = &Error{Code: CodeBadRequest, Message: "Bad request"}
 	ErrMethodNotAllowed   = &Error{Code: CodeMethodNotAllowed, Message: "Method not allowed"}
 	ErrServiceUnavailable = &Error{Code: CodeServiceUnavailable, Message: "Service unavailable"}
+	ErrTooManyRequests    = &Error{Code: CodeServiceUnavailable, Message: "Service unavailable: too many pending requests"}
+	ErrTooManyQueries     = &Error{Code: CodeServiceUnavailable, Message: "Service unavailable: too many query variants"}
 	ErrForbiddenOrigin    = &Error{Code: CodeForbidden, Message: "Forbidden origin"}
 )
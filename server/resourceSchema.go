@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/schema"
+)
+
+func (s *Service) initSchema() error {
+	if s.cfg.Schema == nil {
+		return nil
+	}
+	sch, err := schema.Load(*s.cfg.Schema)
+	if err != nil {
+		return err
+	}
+	s.schema = sch
+	return nil
+}
+
+// OpenAPI generates an OpenAPI document for the HTTP API from the
+// configured resource schema.
+func OpenAPI(sch *schema.Schema, cfg Config) ([]byte, error) {
+	if err := cfg.prepare(); err != nil {
+		return nil, err
+	}
+	return sch.OpenAPI(schema.OpenAPIOptions{
+		APIPath:      cfg.APIPath,
+		PUTMethod:    cfg.PUTMethod,
+		DELETEMethod: cfg.DELETEMethod,
+		PATCHMethod:  cfg.PATCHMethod,
+	})
+}
+
+// validateParams validates call params against the params schema of the
+// resource method, if any. A system.invalidParams error is returned if
+// validation fails, with the validation errors set as data.
+func (s *Service) validateParams(rname, action string, params interface{}) error {
+	if s.schema == nil {
+		return nil
+	}
+	var data []byte
+	switch v := params.(type) {
+	case json.RawMessage:
+		data = v
+	case nil:
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return reserr.InternalError(err)
+		}
+	}
+	errs := s.schema.ValidateParams(rname, action, data)
+	if len(errs) == 0 {
+		return nil
+	}
+	return &reserr.Error{
+		Code:    reserr.CodeInvalidParams,
+		Message: "Invalid parameters: " + errs[0].String(),
+		Data:    errs,
+	}
+}
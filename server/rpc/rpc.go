@@ -14,7 +14,7 @@ import (
 type Requester interface {
 	Reply(data []byte)
 	GetResource(rid string, callback func(data *Resources, err error))
-	SubscribeResource(rid string, callback func(data *Resources, err error))
+	SubscribeResource(rid string, params *SubscribeRequest, callback func(data *Resources, err error))
 	UnsubscribeResource(rid string, count int, callback func(ok bool))
 	CallResource(rid, action string, params interface{}, callback func(result interface{}, err error))
 	AuthResource(rid, action string, params interface{}, callback func(result interface{}, err error))
@@ -75,6 +75,20 @@ type AddEvent struct {
 	*Resources
 }
 
+// MoveEvent represents a RES-client collection move event
+// https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#collection-move-event
+type MoveEvent struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// ResetEvent represents a RES-client collection reset event
+// https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#collection-reset-event
+type ResetEvent struct {
+	Values interface{} `json:"values"`
+	*Resources
+}
+
 // ChangeEvent represents a RES-client model change event
 // https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#model-change-event
 type ChangeEvent struct {
@@ -99,6 +113,13 @@ type CallResourceResult struct {
 	*Resources
 }
 
+// SubscribeRequest represents the params of a subscribe request
+type SubscribeRequest struct {
+	Offset *int     `json:"offset"`
+	Limit  *int     `json:"limit"`
+	Fields []string `json:"fields"`
+}
+
 // UnsubscribeRequest represents the params of an unsubscribe request
 type UnsubscribeRequest struct {
 	Count *int `json:"count"`
@@ -178,7 +199,16 @@ func HandleRequest(data []byte, req Requester) error {
 			}
 		})
 	case "subscribe":
-		req.SubscribeResource(rid, func(data *Resources, err error) {
+		var sr *SubscribeRequest
+		if len(r.Params) > 0 && !bytes.Equal(r.Params, nullBytes) {
+			sr = &SubscribeRequest{}
+			err := json.Unmarshal(r.Params, sr)
+			if err != nil || !sr.isValid() {
+				req.Reply(r.ErrorResponse(reserr.ErrInvalidParams))
+				return nil
+			}
+		}
+		req.SubscribeResource(rid, sr, func(data *Resources, err error) {
 			if err != nil {
 				req.Reply(r.ErrorResponse(err))
 			} else {
@@ -243,6 +273,22 @@ func HandleRequest(data []byte, req Requester) error {
 	return nil
 }
 
+// isValid reports whether the subscribe request params are valid.
+// A window requires a limit, and an offset may only be set together
+// with a limit. Fields, if set, must not be empty.
+func (sr *SubscribeRequest) isValid() bool {
+	if sr.Fields != nil && len(sr.Fields) == 0 {
+		return false
+	}
+	if sr.Limit == nil {
+		return sr.Offset == nil
+	}
+	if *sr.Limit <= 0 {
+		return false
+	}
+	return sr.Offset == nil || *sr.Offset >= 0
+}
+
 // SuccessResponse encodes a result to a request response
 func (r *Request) SuccessResponse(result interface{}) []byte {
 	out, _ := json.Marshal(Response{Result: result, ID: r.ID})
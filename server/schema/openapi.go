@@ -0,0 +1,166 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OpenAPIOptions holds the gateway settings affecting how resources are
+// mapped to the HTTP API.
+type OpenAPIOptions struct {
+	APIPath      string
+	PUTMethod    *string
+	DELETEMethod *string
+	PATCHMethod  *string
+}
+
+type object map[string]interface{}
+
+var (
+	defaultInfo = json.RawMessage(`{"title":"RES API","version":"1.0.0"}`)
+	errorSchema = json.RawMessage(`{"type":"object","properties":{"code":{"type":"string"},"message":{"type":"string"},"data":{}},"required":["code","message"]}`)
+	errorRef    = object{"$ref": "#/components/responses/Error"}
+)
+
+// OpenAPI generates an OpenAPI 3 document describing how the resources are
+// reached through the HTTP API. Resources with patterns containing the full
+// wildcard, >, cannot be described and are left out.
+func (s *Schema) OpenAPI(opts OpenAPIOptions) ([]byte, error) {
+	info := s.Info
+	if len(info) == 0 {
+		info = defaultInfo
+	}
+	paths := make(object)
+	for _, r := range s.Resources {
+		if strings.HasSuffix(r.Pattern, ">") {
+			continue
+		}
+		path, params := patternToPath(r.Pattern, opts.APIPath)
+		item, ok := paths[path].(object)
+		if !ok {
+			item = object{}
+			if len(params) > 0 {
+				item["parameters"] = params
+			}
+			paths[path] = item
+		}
+		if _, ok := item["get"]; !ok {
+			item["get"] = r.getOperation()
+		}
+		for name, m := range r.Methods {
+			mpath := path + "/" + name
+			if _, ok := paths[mpath]; !ok {
+				mitem := object{"post": m.operation(r, name)}
+				if len(params) > 0 {
+					mitem["parameters"] = params
+				}
+				paths[mpath] = mitem
+			}
+			for httpMethod, callMethod := range map[string]*string{
+				"put":    opts.PUTMethod,
+				"delete": opts.DELETEMethod,
+				"patch":  opts.PATCHMethod,
+			} {
+				if callMethod != nil && *callMethod == name {
+					if _, ok := item[httpMethod]; !ok {
+						item[httpMethod] = m.operation(r, name)
+					}
+				}
+			}
+		}
+	}
+
+	return json.MarshalIndent(object{
+		"openapi": "3.0.3",
+		"info":    info,
+		"paths":   paths,
+		"components": object{
+			"schemas": object{"Error": errorSchema},
+			"responses": object{
+				"Error": object{
+					"description": "Error response",
+					"content":     jsonContent(json.RawMessage(`{"$ref":"#/components/schemas/Error"}`)),
+				},
+			},
+		},
+	}, "", "\t")
+}
+
+func (r *Resource) getOperation() object {
+	var sch interface{} = r.Schema
+	if len(r.Schema) == 0 {
+		switch r.Type {
+		case TypeModel:
+			sch = object{"type": "object"}
+		case TypeCollection:
+			sch = object{"type": "array"}
+		default:
+			sch = object{}
+		}
+	}
+	op := object{
+		"summary": "Get " + r.Pattern,
+		"responses": object{
+			"200":     object{"description": "Resource data", "content": jsonContent(sch)},
+			"default": errorRef,
+		},
+	}
+	if r.Description != "" {
+		op["description"] = r.Description
+	}
+	return op
+}
+
+func (m *Method) operation(r *Resource, name string) object {
+	var result interface{} = m.Result
+	if len(m.Result) == 0 {
+		result = object{}
+	}
+	op := object{
+		"summary": fmt.Sprintf("Call %s on %s", name, r.Pattern),
+		"responses": object{
+			"200":     object{"description": "Call result", "content": jsonContent(result)},
+			"204":     object{"description": "Call with no result"},
+			"default": errorRef,
+		},
+	}
+	if m.Description != "" {
+		op["description"] = m.Description
+	}
+	if len(m.Params) > 0 {
+		op["requestBody"] = object{"content": jsonContent(m.Params)}
+	}
+	return op
+}
+
+func jsonContent(sch interface{}) object {
+	return object{"application/json": object{"schema": sch}}
+}
+
+// patternToPath converts a resource pattern to an OpenAPI path template,
+// and returns the path parameters. Placeholder tokens use their names,
+// while * wildcards are numbered.
+func patternToPath(pattern, prefix string) (string, []object) {
+	tokens := strings.Split(pattern, ".")
+	var params []object
+	for i, t := range tokens {
+		var name string
+		switch {
+		case t == "*":
+			name = fmt.Sprintf("p%d", len(params)+1)
+		case len(t) > 1 && t[0] == '$':
+			name = t[1:]
+		default:
+			continue
+		}
+		tokens[i] = "{" + name + "}"
+		params = append(params, object{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   object{"type": "string"},
+		})
+	}
+	return prefix + strings.Join(tokens, "/"), params
+}
@@ -0,0 +1,179 @@
+// Package schema handles resource schema files, describing the resources
+// served by services, their call methods, and the JSON Schema of method
+// parameters.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// Resource types
+const (
+	TypeModel      = "model"
+	TypeCollection = "collection"
+)
+
+// Schema holds the resource descriptions of a schema file.
+type Schema struct {
+	Info      json.RawMessage `json:"info,omitempty"`
+	Resources []*Resource     `json:"resources"`
+}
+
+// Resource describes the resources matching a resource pattern.
+//
+// The pattern uses the same wildcards as NATS subjects. Tokens starting
+// with $, such as "library.book.$id", are named placeholders matching any
+// single token.
+type Resource struct {
+	Pattern     string             `json:"pattern"`
+	Type        string             `json:"type,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Schema      json.RawMessage    `json:"schema,omitempty"`
+	Methods     map[string]*Method `json:"methods,omitempty"`
+
+	pattern rescache.ResourcePattern
+}
+
+// Method describes a call method on a resource.
+type Method struct {
+	Description string          `json:"description,omitempty"`
+	Params      json.RawMessage `json:"params,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+
+	params *validator
+}
+
+// Load reads and parses a schema file. If path is a directory, all *.json
+// files in the directory are loaded in name order, and their resources are
+// combined into a single schema.
+func Load(path string) (*Schema, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return loadFile(path)
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	s := &Schema{}
+	for _, file := range files {
+		fs, err := loadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if len(s.Info) == 0 {
+			s.Info = fs.Info
+		}
+		s.Resources = append(s.Resources, fs.Resources...)
+	}
+	return s, nil
+}
+
+func loadFile(file string) (*Schema, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing schema file %s: %s", file, err)
+	}
+	return s, nil
+}
+
+// Parse parses a JSON encoded schema.
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	for _, r := range s.Resources {
+		if err := r.prepare(); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+// prepare validates the resource and parses its pattern.
+func (r *Resource) prepare() error {
+	p := rescache.ParseResourcePattern(placeholdersToWildcards(r.Pattern))
+	if !p.IsValid() {
+		return fmt.Errorf("invalid resource pattern %#v", r.Pattern)
+	}
+	r.pattern = p
+	switch r.Type {
+	case "", TypeModel, TypeCollection:
+	default:
+		return fmt.Errorf("invalid type %#v for resource %s - must be model or collection", r.Type, r.Pattern)
+	}
+	for name, m := range r.Methods {
+		if !codec.IsValidRIDPart(name) {
+			return fmt.Errorf("invalid method name %#v for resource %s", name, r.Pattern)
+		}
+		if m == nil {
+			r.Methods[name] = &Method{}
+			continue
+		}
+		if len(m.Params) > 0 {
+			vd, err := compile(m.Params)
+			if err != nil {
+				return fmt.Errorf("invalid params for method %s on resource %s: %s", name, r.Pattern, err)
+			}
+			m.params = vd
+		}
+	}
+	return nil
+}
+
+// ValidateParams validates the JSON encoded params of a call request
+// against the params schema of the matching resource method. Empty params
+// are validated as null. If no resource or method, or no params schema, is
+// found, no validation is done.
+func (s *Schema) ValidateParams(rname, method string, params []byte) []ValidationError {
+	r := s.Resource(rname)
+	if r == nil {
+		return nil
+	}
+	m, ok := r.Methods[method]
+	if !ok || m.params == nil {
+		return nil
+	}
+	return m.params.validate(params)
+}
+
+// Resource returns the first resource description with a pattern matching
+// the resource name, or nil if no match is found.
+func (s *Schema) Resource(rname string) *Resource {
+	for _, r := range s.Resources {
+		if r.pattern.Match(rname) {
+			return r
+		}
+	}
+	return nil
+}
+
+// placeholdersToWildcards replaces $placeholder tokens with * wildcards.
+func placeholdersToWildcards(pattern string) string {
+	tokens := strings.Split(pattern, ".")
+	for i, t := range tokens {
+		if len(t) > 1 && t[0] == '$' {
+			tokens[i] = "*"
+		}
+	}
+	return strings.Join(tokens, ".")
+}
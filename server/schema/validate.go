@@ -0,0 +1,550 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidationError describes a value not matching a JSON Schema.
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) String() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// validator validates values against a compiled JSON Schema.
+//
+// The supported keywords are type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, uniqueItems, minLength,
+// maxLength, pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+// multipleOf, minProperties, maxProperties, allOf, anyOf, oneOf, and not.
+// Other keywords are ignored.
+type validator struct {
+	always *bool // Set for the boolean schemas true and false
+
+	types            []string
+	enum             []interface{}
+	constant         *interface{}
+	properties       map[string]*validator
+	required         []string
+	additional       *validator
+	items            *validator
+	minItems         *int
+	maxItems         *int
+	uniqueItems      bool
+	minLength        *int
+	maxLength        *int
+	pattern          *regexp.Regexp
+	minimum          *number
+	maximum          *number
+	exclusiveMinimum *number
+	exclusiveMaximum *number
+	multipleOf       *number
+	minProperties    *int
+	maxProperties    *int
+	allOf            []*validator
+	anyOf            []*validator
+	oneOf            []*validator
+	not              *validator
+}
+
+// Limits of number literals. Longer literals, or larger exponents, are not
+// parsed, as the cost of exact precision grows with their size.
+const (
+	maxNumberDigits   = 64
+	maxNumberExponent = 400
+)
+
+// number is a JSON number with exact precision.
+type number struct {
+	r   *big.Rat
+	raw string
+}
+
+func (n *number) String() string {
+	return n.raw
+}
+
+// compile parses a JSON Schema into a validator.
+func compile(data json.RawMessage) (*validator, error) {
+	var v interface{}
+	if err := unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return compileValue(v, "")
+}
+
+func compileValue(v interface{}, path string) (*validator, error) {
+	if b, ok := v.(bool); ok {
+		return &validator{always: &b}, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, schemaError(path, "schema must be an object or boolean")
+	}
+
+	vd := &validator{}
+	var err error
+	for k, kv := range m {
+		kpath := path + "/" + k
+		switch k {
+		case "type":
+			switch t := kv.(type) {
+			case string:
+				vd.types = []string{t}
+			case []interface{}:
+				for _, tv := range t {
+					s, ok := tv.(string)
+					if !ok {
+						return nil, schemaError(kpath, "type must be a string or an array of strings")
+					}
+					vd.types = append(vd.types, s)
+				}
+			default:
+				return nil, schemaError(kpath, "type must be a string or an array of strings")
+			}
+			for _, t := range vd.types {
+				switch t {
+				case "null", "boolean", "object", "array", "number", "string", "integer":
+				default:
+					return nil, schemaError(kpath, fmt.Sprintf("unknown type %#v", t))
+				}
+			}
+		case "enum":
+			l, ok := kv.([]interface{})
+			if !ok {
+				return nil, schemaError(kpath, "enum must be an array")
+			}
+			vd.enum = l
+		case "const":
+			c := kv
+			vd.constant = &c
+		case "properties":
+			pm, ok := kv.(map[string]interface{})
+			if !ok {
+				return nil, schemaError(kpath, "properties must be an object")
+			}
+			vd.properties = make(map[string]*validator, len(pm))
+			for name, pv := range pm {
+				if vd.properties[name], err = compileValue(pv, kpath+"/"+name); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			l, ok := kv.([]interface{})
+			if !ok {
+				return nil, schemaError(kpath, "required must be an array of strings")
+			}
+			for _, rv := range l {
+				s, ok := rv.(string)
+				if !ok {
+					return nil, schemaError(kpath, "required must be an array of strings")
+				}
+				vd.required = append(vd.required, s)
+			}
+		case "additionalProperties":
+			if vd.additional, err = compileValue(kv, kpath); err != nil {
+				return nil, err
+			}
+		case "items":
+			if vd.items, err = compileValue(kv, kpath); err != nil {
+				return nil, err
+			}
+		case "not":
+			if vd.not, err = compileValue(kv, kpath); err != nil {
+				return nil, err
+			}
+		case "allOf", "anyOf", "oneOf":
+			l, ok := kv.([]interface{})
+			if !ok || len(l) == 0 {
+				return nil, schemaError(kpath, k+" must be a non-empty array")
+			}
+			vs := make([]*validator, len(l))
+			for i, sv := range l {
+				if vs[i], err = compileValue(sv, kpath+"/"+strconv.Itoa(i)); err != nil {
+					return nil, err
+				}
+			}
+			switch k {
+			case "allOf":
+				vd.allOf = vs
+			case "anyOf":
+				vd.anyOf = vs
+			default:
+				vd.oneOf = vs
+			}
+		case "minItems", "maxItems", "minLength", "maxLength", "minProperties", "maxProperties":
+			n, ok := toInt(kv)
+			if !ok || n < 0 {
+				return nil, schemaError(kpath, k+" must be a non-negative integer")
+			}
+			switch k {
+			case "minItems":
+				vd.minItems = &n
+			case "maxItems":
+				vd.maxItems = &n
+			case "minLength":
+				vd.minLength = &n
+			case "maxLength":
+				vd.maxLength = &n
+			case "minProperties":
+				vd.minProperties = &n
+			default:
+				vd.maxProperties = &n
+			}
+		case "uniqueItems":
+			b, ok := kv.(bool)
+			if !ok {
+				return nil, schemaError(kpath, "uniqueItems must be a boolean")
+			}
+			vd.uniqueItems = b
+		case "pattern":
+			s, ok := kv.(string)
+			if !ok {
+				return nil, schemaError(kpath, "pattern must be a string")
+			}
+			if vd.pattern, err = regexp.Compile(s); err != nil {
+				return nil, schemaError(kpath, "invalid pattern: "+err.Error())
+			}
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf":
+			n, ok := toNumber(kv)
+			if !ok {
+				return nil, schemaError(kpath, k+" must be a number")
+			}
+			switch k {
+			case "minimum":
+				vd.minimum = n
+			case "maximum":
+				vd.maximum = n
+			case "exclusiveMinimum":
+				vd.exclusiveMinimum = n
+			case "exclusiveMaximum":
+				vd.exclusiveMaximum = n
+			default:
+				if n.r.Sign() <= 0 {
+					return nil, schemaError(kpath, "multipleOf must be greater than 0")
+				}
+				vd.multipleOf = n
+			}
+		}
+	}
+	return vd, nil
+}
+
+// validate validates a JSON encoded value, returning any validation errors.
+func (vd *validator) validate(data []byte) []ValidationError {
+	var v interface{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := unmarshal(data, &v); err != nil {
+			return []ValidationError{{Message: "invalid JSON: " + err.Error()}}
+		}
+	}
+	return vd.validateValue(v, "", nil)
+}
+
+func (vd *validator) validateValue(v interface{}, path string, errs []ValidationError) []ValidationError {
+	if vd.always != nil {
+		if !*vd.always {
+			errs = append(errs, ValidationError{Path: path, Message: "value is not allowed"})
+		}
+		return errs
+	}
+
+	if len(vd.types) > 0 && !matchesAnyType(v, vd.types) {
+		return append(errs, ValidationError{Path: path, Message: "must be of type " + strings.Join(vd.types, " or ")})
+	}
+	if vd.enum != nil {
+		found := false
+		for _, ev := range vd.enum {
+			if equalValues(v, ev) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, ValidationError{Path: path, Message: "must be one of the allowed values"})
+		}
+	}
+	if vd.constant != nil && !equalValues(v, *vd.constant) {
+		errs = append(errs, ValidationError{Path: path, Message: "must be equal to the constant value"})
+	}
+
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		errs = vd.validateObject(tv, path, errs)
+	case []interface{}:
+		errs = vd.validateArray(tv, path, errs)
+	case string:
+		l := utf8.RuneCountInString(tv)
+		if vd.minLength != nil && l < *vd.minLength {
+			errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("must be at least %d characters long", *vd.minLength)})
+		}
+		if vd.maxLength != nil && l > *vd.maxLength {
+			errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("must be at most %d characters long", *vd.maxLength)})
+		}
+		if vd.pattern != nil && !vd.pattern.MatchString(tv) {
+			errs = append(errs, ValidationError{Path: path, Message: "must match pattern " + vd.pattern.String()})
+		}
+	case json.Number:
+		errs = vd.validateNumber(tv, path, errs)
+	}
+
+	for _, s := range vd.allOf {
+		errs = s.validateValue(v, path, errs)
+	}
+	if vd.anyOf != nil {
+		valid := false
+		for _, s := range vd.anyOf {
+			if len(s.validateValue(v, path, nil)) == 0 {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			errs = append(errs, ValidationError{Path: path, Message: "must match at least one schema in anyOf"})
+		}
+	}
+	if vd.oneOf != nil {
+		count := 0
+		for _, s := range vd.oneOf {
+			if len(s.validateValue(v, path, nil)) == 0 {
+				count++
+			}
+		}
+		if count != 1 {
+			errs = append(errs, ValidationError{Path: path, Message: "must match exactly one schema in oneOf"})
+		}
+	}
+	if vd.not != nil && len(vd.not.validateValue(v, path, nil)) == 0 {
+		errs = append(errs, ValidationError{Path: path, Message: "must not match schema in not"})
+	}
+	return errs
+}
+
+func (vd *validator) validateObject(m map[string]interface{}, path string, errs []ValidationError) []ValidationError {
+	for _, name := range vd.required {
+		if _, ok := m[name]; !ok {
+			errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("missing required property %#v", name)})
+		}
+	}
+	if vd.minProperties != nil && len(m) < *vd.minProperties {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("must have at least %d properties", *vd.minProperties)})
+	}
+	if vd.maxProperties != nil && len(m) > *vd.maxProperties {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("must have at most %d properties", *vd.maxProperties)})
+	}
+	// Validate in key order to get a consistent error order
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		kpath := path + "/" + escapePointer(k)
+		if ps, ok := vd.properties[k]; ok {
+			errs = ps.validateValue(m[k], kpath, errs)
+		} else if vd.additional != nil {
+			if vd.additional.always != nil && !*vd.additional.always {
+				errs = append(errs, ValidationError{Path: kpath, Message: "additional property is not allowed"})
+			} else {
+				errs = vd.additional.validateValue(m[k], kpath, errs)
+			}
+		}
+	}
+	return errs
+}
+
+func (vd *validator) validateArray(l []interface{}, path string, errs []ValidationError) []ValidationError {
+	if vd.minItems != nil && len(l) < *vd.minItems {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("must have at least %d items", *vd.minItems)})
+	}
+	if vd.maxItems != nil && len(l) > *vd.maxItems {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("must have at most %d items", *vd.maxItems)})
+	}
+	if vd.uniqueItems {
+	unique:
+		for i := 1; i < len(l); i++ {
+			for j := 0; j < i; j++ {
+				if equalValues(l[i], l[j]) {
+					errs = append(errs, ValidationError{Path: path, Message: "items must be unique"})
+					break unique
+				}
+			}
+		}
+	}
+	if vd.items != nil {
+		for i, item := range l {
+			errs = vd.items.validateValue(item, path+"/"+strconv.Itoa(i), errs)
+		}
+	}
+	return errs
+}
+
+func (vd *validator) validateNumber(n json.Number, path string, errs []ValidationError) []ValidationError {
+	num, ok := toNumber(n)
+	if !ok {
+		return append(errs, ValidationError{Path: path, Message: fmt.Sprintf("must be a number of at most %d digits and an exponent of at most %d", maxNumberDigits, maxNumberExponent)})
+	}
+	f := num.r
+	if vd.minimum != nil && f.Cmp(vd.minimum.r) < 0 {
+		errs = append(errs, ValidationError{Path: path, Message: "must be greater than or equal to " + vd.minimum.String()})
+	}
+	if vd.maximum != nil && f.Cmp(vd.maximum.r) > 0 {
+		errs = append(errs, ValidationError{Path: path, Message: "must be less than or equal to " + vd.maximum.String()})
+	}
+	if vd.exclusiveMinimum != nil && f.Cmp(vd.exclusiveMinimum.r) <= 0 {
+		errs = append(errs, ValidationError{Path: path, Message: "must be greater than " + vd.exclusiveMinimum.String()})
+	}
+	if vd.exclusiveMaximum != nil && f.Cmp(vd.exclusiveMaximum.r) >= 0 {
+		errs = append(errs, ValidationError{Path: path, Message: "must be less than " + vd.exclusiveMaximum.String()})
+	}
+	if vd.multipleOf != nil {
+		q := new(big.Rat).Quo(f, vd.multipleOf.r)
+		if !q.IsInt() {
+			errs = append(errs, ValidationError{Path: path, Message: "must be a multiple of " + vd.multipleOf.String()})
+		}
+	}
+	return errs
+}
+
+func matchesAnyType(v interface{}, types []string) bool {
+	for _, t := range types {
+		if matchesType(v, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesType(v interface{}, t string) bool {
+	switch tv := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case map[string]interface{}:
+		return t == "object"
+	case []interface{}:
+		return t == "array"
+	case string:
+		return t == "string"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		if t == "integer" {
+			n, ok := toNumber(tv)
+			return ok && n.r.IsInt()
+		}
+	}
+	return false
+}
+
+// equalValues reports whether two decoded JSON values are equal.
+func equalValues(a, b interface{}) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		an, aok := toNumber(av)
+		bn, bok := toNumber(bv)
+		return aok && bok && an.r.Cmp(bn.r) == 0
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			w, ok := bv[k]
+			if !ok || !equalValues(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equalValues(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func toNumber(v interface{}) (*number, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil, false
+	}
+	mant, exp := string(n), ""
+	if i := strings.IndexAny(mant, "eE"); i >= 0 {
+		mant, exp = mant[:i], mant[i+1:]
+	}
+	if len(mant)-strings.Count(mant, "-")-strings.Count(mant, ".") > maxNumberDigits {
+		return nil, false
+	}
+	if exp != "" {
+		e, err := strconv.Atoi(exp)
+		if err != nil || e > maxNumberExponent || e < -maxNumberExponent {
+			return nil, false
+		}
+	}
+	r, ok := new(big.Rat).SetString(string(n))
+	if !ok {
+		return nil, false
+	}
+	return &number{r: r, raw: string(n)}, true
+}
+
+func toInt(v interface{}) (int, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	return int(i), err == nil
+}
+
+func unmarshal(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected data after JSON value")
+	}
+	return nil
+}
+
+// escapePointer escapes a property name as a JSON Pointer token.
+func escapePointer(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+func schemaError(path, msg string) error {
+	if path == "" {
+		return fmt.Errorf("invalid schema: %s", msg)
+	}
+	return fmt.Errorf("invalid schema at %s: %s", path, msg)
+}
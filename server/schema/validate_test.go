@@ -0,0 +1,156 @@
+package schema
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validateErrors compiles the schema and validates the value, returning the
+// validation errors as strings.
+func validateErrors(t *testing.T, schema, value string) []string {
+	vd, err := compile([]byte(schema))
+	if err != nil {
+		t.Fatalf("error compiling schema %s: %s", schema, err)
+	}
+	var errs []string
+	for _, e := range vd.validate([]byte(value)) {
+		errs = append(errs, e.String())
+	}
+	return errs
+}
+
+func TestValidate_Keywords(t *testing.T) {
+	tbl := []struct {
+		Schema   string
+		Value    string
+		Expected []string
+	}{
+		// Boolean schemas
+		{`true`, `"foo"`, nil},
+		{`false`, `"foo"`, []string{"value is not allowed"}},
+		// type
+		{`{"type":["string","null"]}`, `null`, nil},
+		{`{"type":"integer"}`, `1.0`, nil},
+		{`{"type":"integer"}`, `1.5`, []string{"must be of type integer"}},
+		{`{"type":["string","null"]}`, `42`, []string{"must be of type string or null"}},
+		// enum
+		{`{"enum":["foo",42,{"a":[1]}]}`, `"foo"`, nil},
+		{`{"enum":["foo",42,{"a":[1]}]}`, `42.0`, nil},
+		{`{"enum":["foo",42,{"a":[1]}]}`, `{"a":[1]}`, nil},
+		{`{"enum":["foo",42,{"a":[1]}]}`, `{"a":[2]}`, []string{"must be one of the allowed values"}},
+		{`{"enum":["foo",42,{"a":[1]}]}`, `"42"`, []string{"must be one of the allowed values"}},
+		// const
+		{`{"const":null}`, `null`, nil},
+		{`{"const":[1,"a"]}`, `[1,"a"]`, nil},
+		{`{"const":[1,"a"]}`, `["a",1]`, []string{"must be equal to the constant value"}},
+		{`{"const":false}`, `0`, []string{"must be equal to the constant value"}},
+		// pattern
+		{`{"pattern":"^[a-z]+$"}`, `"foo"`, nil},
+		{`{"pattern":"^[a-z]+$"}`, `"Foo"`, []string{"must match pattern ^[a-z]+$"}},
+		{`{"pattern":"^[a-z]+$"}`, `42`, nil},
+		// minLength and maxLength counting characters
+		{`{"minLength":2,"maxLength":3}`, `"åäö"`, nil},
+		{`{"maxLength":2}`, `"åäö"`, []string{"must be at most 2 characters long"}},
+		// items
+		{`{"items":{"type":"number"}}`, `[1,2.5]`, nil},
+		{`{"items":{"type":"number"}}`, `[1,"a",null]`, []string{"/1: must be of type number", "/2: must be of type number"}},
+		// minItems and maxItems
+		{`{"minItems":1,"maxItems":2}`, `[1]`, nil},
+		{`{"minItems":1}`, `[]`, []string{"must have at least 1 items"}},
+		{`{"maxItems":2}`, `[1,2,3]`, []string{"must have at most 2 items"}},
+		// uniqueItems
+		{`{"uniqueItems":true}`, `[1,"1",{"a":1},{"a":2}]`, nil},
+		{`{"uniqueItems":true}`, `[1,2,1.0]`, []string{"items must be unique"}},
+		{`{"uniqueItems":true}`, `[{"a":[1]},{"a":[1]},{"a":[1]}]`, []string{"items must be unique"}},
+		{`{"uniqueItems":false}`, `[1,1]`, nil},
+		// properties and additionalProperties
+		{`{"properties":{"a":{"type":"string"}},"additionalProperties":{"type":"number"}}`, `{"a":"foo","b":42}`, nil},
+		{`{"properties":{"a":{"type":"string"}},"additionalProperties":{"type":"number"}}`, `{"a":1,"b":"bar"}`, []string{"/a: must be of type string", "/b: must be of type number"}},
+		{`{"properties":{"a/b":false}}`, `{"a/b":1}`, []string{"/a~1b: value is not allowed"}},
+		// minProperties and maxProperties
+		{`{"minProperties":1,"maxProperties":2}`, `{"a":1}`, nil},
+		{`{"minProperties":1}`, `{}`, []string{"must have at least 1 properties"}},
+		{`{"maxProperties":1}`, `{"a":1,"b":2}`, []string{"must have at most 1 properties"}},
+		// exclusiveMinimum and exclusiveMaximum
+		{`{"exclusiveMinimum":0,"exclusiveMaximum":10}`, `5`, nil},
+		{`{"exclusiveMinimum":0}`, `0`, []string{"must be greater than 0"}},
+		{`{"exclusiveMaximum":10}`, `10`, []string{"must be less than 10"}},
+		// multipleOf with exact precision
+		{`{"multipleOf":0.1}`, `0.3`, nil},
+		{`{"multipleOf":0.01}`, `19.99`, nil},
+		{`{"multipleOf":3}`, `1e3`, []string{"must be a multiple of 3"}},
+		{`{"multipleOf":0.1}`, `0.35`, []string{"must be a multiple of 0.1"}},
+		// allOf
+		{`{"allOf":[{"type":"string"},{"minLength":2}]}`, `"foo"`, nil},
+		{`{"allOf":[{"type":"string"},{"minLength":2}]}`, `"f"`, []string{"must be at least 2 characters long"}},
+		// anyOf
+		{`{"anyOf":[{"type":"string"},{"minimum":10}]}`, `"foo"`, nil},
+		{`{"anyOf":[{"type":"string"},{"minimum":10}]}`, `12`, nil},
+		{`{"anyOf":[{"type":"string"},{"minimum":10}]}`, `8`, []string{"must match at least one schema in anyOf"}},
+		// oneOf
+		{`{"oneOf":[{"type":"integer"},{"minimum":10}]}`, `8`, nil},
+		{`{"oneOf":[{"type":"integer"},{"minimum":10}]}`, `12`, []string{"must match exactly one schema in oneOf"}},
+		{`{"oneOf":[{"type":"integer"},{"minimum":10}]}`, `8.5`, []string{"must match exactly one schema in oneOf"}},
+		// not
+		{`{"not":{"type":"null"}}`, `"foo"`, nil},
+		{`{"not":{"type":"null"}}`, `null`, []string{"must not match schema in not"}},
+		// Unknown keywords are ignored
+		{`{"format":"email","$comment":"foo"}`, `"foo"`, nil},
+		// Numbers exceeding the limits
+		{`{"minimum":0}`, `1` + strings.Repeat("0", 64), []string{"must be a number of at most 64 digits and an exponent of at most 400"}},
+		{`{"minimum":0}`, `1e401`, []string{"must be a number of at most 64 digits and an exponent of at most 400"}},
+		// Invalid JSON
+		{`{}`, `{"a":1} 2`, []string{"invalid JSON: unexpected data after JSON value"}},
+	}
+
+	for i, l := range tbl {
+		got := validateErrors(t, l.Schema, l.Value)
+		if !reflect.DeepEqual(got, l.Expected) {
+			t.Errorf("test #%d: expected schema %s on %s to give errors %#v, but got %#v", i+1, l.Schema, l.Value, l.Expected, got)
+		}
+	}
+}
+
+func TestCompile_InvalidSchema_ReturnsError(t *testing.T) {
+	tbl := []struct {
+		Schema   string
+		Expected string
+	}{
+		{`"foo"`, "invalid schema: schema must be an object or boolean"},
+		{`{"type":42}`, "invalid schema at /type: type must be a string or an array of strings"},
+		{`{"type":["string",1]}`, "invalid schema at /type: type must be a string or an array of strings"},
+		{`{"type":"date"}`, `invalid schema at /type: unknown type "date"`},
+		{`{"enum":"foo"}`, "invalid schema at /enum: enum must be an array"},
+		{`{"properties":[]}`, "invalid schema at /properties: properties must be an object"},
+		{`{"properties":{"a":1}}`, "invalid schema at /properties/a: schema must be an object or boolean"},
+		{`{"required":[1]}`, "invalid schema at /required: required must be an array of strings"},
+		{`{"additionalProperties":"foo"}`, "invalid schema at /additionalProperties: schema must be an object or boolean"},
+		{`{"items":{"type":"foo"}}`, `invalid schema at /items/type: unknown type "foo"`},
+		{`{"not":null}`, "invalid schema at /not: schema must be an object or boolean"},
+		{`{"allOf":[]}`, "invalid schema at /allOf: allOf must be a non-empty array"},
+		{`{"anyOf":{}}`, "invalid schema at /anyOf: anyOf must be a non-empty array"},
+		{`{"oneOf":[true,1]}`, "invalid schema at /oneOf/1: schema must be an object or boolean"},
+		{`{"minItems":-1}`, "invalid schema at /minItems: minItems must be a non-negative integer"},
+		{`{"maxLength":1.5}`, "invalid schema at /maxLength: maxLength must be a non-negative integer"},
+		{`{"uniqueItems":"yes"}`, "invalid schema at /uniqueItems: uniqueItems must be a boolean"},
+		{`{"pattern":1}`, "invalid schema at /pattern: pattern must be a string"},
+		{`{"minimum":"1"}`, "invalid schema at /minimum: minimum must be a number"},
+		{`{"multipleOf":0}`, "invalid schema at /multipleOf: multipleOf must be greater than 0"},
+		{`{"multipleOf":-2}`, "invalid schema at /multipleOf: multipleOf must be greater than 0"},
+	}
+
+	for i, l := range tbl {
+		_, err := compile([]byte(l.Schema))
+		if err == nil {
+			t.Errorf("test #%d: expected an error compiling %s, but got none", i+1, l.Schema)
+		} else if err.Error() != l.Expected {
+			t.Errorf("test #%d: expected error %#v, but got %#v", i+1, l.Expected, err.Error())
+		}
+	}
+
+	// Invalid pattern
+	if _, err := compile([]byte(`{"pattern":"[a-"}`)); err == nil || !strings.HasPrefix(err.Error(), "invalid schema at /pattern: invalid pattern: ") {
+		t.Errorf("expected an invalid pattern error, but got %v", err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/resgateio/resgate/logger"
 	"github.com/resgateio/resgate/server/mq"
 	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/schema"
 )
 
 // Service is a RES gateway implementation
@@ -28,6 +29,7 @@ type Service struct {
 	h        *http.Server
 	enc      APIEncoder
 	mimetype string
+	schema   *schema.Schema
 
 	// wsListener/wsConn
 	upgrader websocket.Upgrader
@@ -51,6 +53,9 @@ func NewService(mq mq.Client, cfg Config) (*Service, error) {
 	if err := s.initAPIHandler(); err != nil {
 		return nil, err
 	}
+	if err := s.initSchema(); err != nil {
+		return nil, err
+	}
 	return s, nil
 }
 
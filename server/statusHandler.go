@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+type statusResponse struct {
+	Cache           cacheStatus    `json:"cache"`
+	PinnedResources []pinnedStatus `json:"pinnedResources"`
+}
+
+type cacheStatus struct {
+	Resources    int   `json:"resources"`
+	Unsubscribed int   `json:"unsubscribed"`
+	Size         int64 `json:"size"`
+	MaxSize      int64 `json:"maxSize"`
+	ResetErrors  int64 `json:"resetErrors"`
+}
+
+type pinnedStatus struct {
+	RID    string        `json:"rid"`
+	Loaded bool          `json:"loaded"`
+	Error  *reserr.Error `json:"error,omitempty"`
+}
+
+// statusHandler serves the cache totals and the load status of the pinned
+// resources as a JSON document, for operators to monitor.
+//
+// The endpoint has no access control, and reveals resource IDs and service
+// error messages. It should only be exposed through a proxy restricting
+// access.
+func (s *Service) statusHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.setCommonHeaders(w, r); err != nil {
+		httpError(w, err, s.enc)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		httpError(w, reserr.ErrMethodNotAllowed, s.enc)
+		return
+	}
+
+	stats := s.CacheStats()
+	pins := s.PinnedResources()
+	resp := statusResponse{
+		Cache: cacheStatus{
+			Resources:    stats.Resources,
+			Unsubscribed: stats.Unsubscribed,
+			Size:         stats.Size,
+			MaxSize:      stats.MaxSize,
+			ResetErrors:  stats.ResetErrors,
+		},
+		PinnedResources: make([]pinnedStatus, len(pins)),
+	}
+	for i, p := range pins {
+		resp.PinnedResources[i] = pinnedStatus{RID: p.RID, Loaded: p.Loaded}
+		if p.Error != nil {
+			resp.PinnedResources[i].Error = reserr.RESError(p.Error)
+		}
+	}
+
+	out, err := json.Marshal(resp)
+	if err != nil {
+		httpError(w, err, s.enc)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(out)
+}
@@ -53,6 +53,14 @@ type Subscription struct {
 	accessCallbacks []func(*rescache.Access)
 	flags           uint8
 
+	// Collection window
+	window       *window
+	values       []codec.Value // Full collection values of a windowed subscription
+	windowEvents []*rescache.ResourceEvent
+
+	// Model projection
+	fields map[string]bool
+
 	// Protected by conn
 	direct   int // Number of direct subscriptions
 	indirect int // Number of indirect subscriptions
@@ -92,6 +100,10 @@ const (
 var (
 	errSubscriptionLimitExceeded = &reserr.Error{Code: "system.subscriptionLimitExceeded", Message: "Subscription limit exceeded"}
 	errDisposedSubscription      = &reserr.Error{Code: "system.disposedSubscription", Message: "Resource subscription is disposed"}
+	errOptionsConflict           = &reserr.Error{Code: reserr.CodeInvalidParams, Message: "Subscribe options conflict with existing subscription"}
+	errWindowOnModel             = &reserr.Error{Code: reserr.CodeInvalidParams, Message: "Window options not allowed on model"}
+	errCollectionReset           = &reserr.Error{Code: reserr.CodeUnsupportedProtocol, Message: "Unsupported protocol: collection reset requires protocol version 1.2.2"}
+	errFieldsOnCollection        = &reserr.Error{Code: reserr.CodeInvalidParams, Message: "Fields option not allowed on collection"}
 )
 
 // NewSubscription creates a new Subscription
@@ -158,10 +170,11 @@ func (s *Subscription) Error() error {
 	return s.err
 }
 
-// ModelValues returns the subscriptions model values.
+// ModelValues returns the subscriptions model values, limited to the
+// projected fields if any are set.
 // Panics if the subscription is not a loaded model.
 func (s *Subscription) ModelValues() map[string]codec.Value {
-	return s.model.Values
+	return s.projectValues(s.model.Values)
 }
 
 // CollectionValues returns the subscriptions collection values.
@@ -218,12 +231,39 @@ func (s *Subscription) Loaded(resourceSub *rescache.ResourceSubscription, err er
 	}
 }
 
+// setOptions sets the subscribe options for a subscription that was created
+// by the current request. If the subscription already existed, the options
+// must be the same as those previously set, or else an error is returned.
+// The options apply to the connection's subscription of the resource, and
+// therefore also to any reference to it from other resources.
+func (s *Subscription) setOptions(params *rpc.SubscribeRequest) error {
+	w := newWindow(params)
+	fields := newFields(params)
+	if s.direct+s.indirect == 1 {
+		s.window = w
+		s.fields = fields
+		return nil
+	}
+	if !s.window.equals(w) || !equalFields(s.fields, fields) {
+		return errOptionsConflict
+	}
+	return nil
+}
+
 // setResource is called after Loaded is called
 func (s *Subscription) setResource() {
 	switch s.typ {
 	case rescache.TypeCollection:
+		if s.fields != nil {
+			s.err = errFieldsOnCollection
+			return
+		}
 		s.setCollection()
 	case rescache.TypeModel:
+		if s.window != nil {
+			s.err = errWindowOnModel
+			return
+		}
 		s.setModel()
 	default:
 		err := fmt.Errorf("subscription %s: unknown resource type", s.rid)
@@ -307,6 +347,11 @@ func (s *Subscription) unqueueEvents(reason uint8) {
 		}
 	}
 
+	// Continue with any window events not yet sent
+	if !s.flushWindowEvents() {
+		return
+	}
+
 	eq := s.eventQueue
 	s.eventQueue = nil
 
@@ -346,14 +391,22 @@ func (s *Subscription) populateResources(r *rpc.Resources) {
 		if r.Collections == nil {
 			r.Collections = make(map[string]interface{})
 		}
-		r.Collections[s.rid] = s.collection
+		if s.window != nil {
+			r.Collections[s.rid] = s.windowCollection()
+		} else {
+			r.Collections[s.rid] = s.collection
+		}
 
 	case rescache.TypeModel:
 		// Create Models map if needed
 		if r.Models == nil {
 			r.Models = make(map[string]interface{})
 		}
-		r.Models[s.rid] = s.model
+		if s.fields != nil {
+			r.Models[s.rid] = s.projectedModel()
+		} else {
+			r.Models[s.rid] = s.model
+		}
 	}
 
 	s.state = stateToSend
@@ -388,14 +441,22 @@ func (s *Subscription) populateResourcesLegacy(r *rpc.Resources) {
 		if r.Collections == nil {
 			r.Collections = make(map[string]interface{})
 		}
-		r.Collections[s.rid] = (*rescache.Legacy120Collection)(s.collection)
+		if s.window != nil {
+			r.Collections[s.rid] = (*rescache.Legacy120Collection)(s.windowCollection())
+		} else {
+			r.Collections[s.rid] = (*rescache.Legacy120Collection)(s.collection)
+		}
 
 	case rescache.TypeModel:
 		// Create Models map if needed
 		if r.Models == nil {
 			r.Models = make(map[string]interface{})
 		}
-		r.Models[s.rid] = (*rescache.Legacy120Model)(s.model)
+		if s.fields != nil {
+			r.Models[s.rid] = (*rescache.Legacy120Model)(s.projectedModel())
+		} else {
+			r.Models[s.rid] = (*rescache.Legacy120Model)(s.model)
+		}
 	}
 
 	s.state = stateToSend
@@ -405,12 +466,16 @@ func (s *Subscription) populateResourcesLegacy(r *rpc.Resources) {
 	}
 }
 
-// setModel subscribes to all resource references in the model.
+// setModel subscribes to all resource references in the model,
+// or only to those of the projected fields.
 func (s *Subscription) setModel() {
 	m := s.resourceSub.GetModel()
 	s.queueEvents(queueReasonLoading)
 	s.resourceSub.Release()
-	for _, v := range m.Values {
+	for k, v := range m.Values {
+		if s.fields != nil && !s.fields[k] {
+			continue
+		}
 		if !s.subscribeRef(v) {
 			return
 		}
@@ -418,12 +483,18 @@ func (s *Subscription) setModel() {
 	s.model = m
 }
 
-// setCollection subscribes to all resource references in the collection.
+// setCollection subscribes to all resource references in the collection,
+// or only to those within the window of a windowed subscription.
 func (s *Subscription) setCollection() {
 	c := s.resourceSub.GetCollection()
 	s.queueEvents(queueReasonLoading)
 	s.resourceSub.Release()
-	for _, v := range c.Values {
+	vals := c.Values
+	if s.window != nil {
+		s.values = vals
+		vals = s.window.slice(vals)
+	}
+	for _, v := range vals {
 		if !s.subscribeRef(v) {
 			return
 		}
@@ -557,6 +628,10 @@ func (s *Subscription) Event(event *rescache.ResourceEvent) {
 }
 
 func (s *Subscription) processEvent(event *rescache.ResourceEvent) {
+	if event.Event == "unsubscribe" {
+		s.handleUnsubscribe(event.Error)
+		return
+	}
 	switch s.resourceSub.GetResourceType() {
 	case rescache.TypeCollection:
 		s.processCollectionEvent(event)
@@ -568,6 +643,41 @@ func (s *Subscription) processEvent(event *rescache.ResourceEvent) {
 }
 
 func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
+	if event.Event == "reset" {
+		s.resetCollection(event)
+		return
+	}
+	if s.window != nil && (event.Event == "add" || event.Event == "remove" || event.Event == "move") {
+		s.processWindowEvent(event)
+		return
+	}
+	s.sendCollectionEvent(event)
+}
+
+// updateCollection sets the collection to the immutable collection resulting
+// from an add, remove, or move event, keeping it in sync with the events sent
+// to the client. The values of a windowed subscription are instead kept by
+// the window.
+func (s *Subscription) updateCollection(event *rescache.ResourceEvent) {
+	if event.Collection == nil || s.window != nil {
+		return
+	}
+	s.collection = event.Collection
+}
+
+// updateModel sets the model to the immutable model resulting from a change
+// event, keeping it in sync with the events sent to the client.
+func (s *Subscription) updateModel(event *rescache.ResourceEvent) {
+	if event.Model == nil {
+		return
+	}
+	s.model = event.Model
+}
+
+// sendCollectionEvent sends a collection event to the client, subscribing to
+// any added resource reference, and unsubscribing to any removed one. A moved
+// resource reference remains subscribed.
+func (s *Subscription) sendCollectionEvent(event *rescache.ResourceEvent) {
 	switch event.Event {
 	case "add":
 		v := event.Value
@@ -585,6 +695,7 @@ func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
 
 			// Quick exit if added resource is already sent to client
 			if sub.IsSent() {
+				s.updateCollection(event)
 				s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.AddEvent{Idx: idx, Value: v.RawMessage}))
 				return
 			}
@@ -600,6 +711,7 @@ func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
 				}
 
 				r := sub.GetRPCResources()
+				s.updateCollection(event)
 				s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.AddEvent{Idx: idx, Value: v.RawMessage, Resources: r}))
 				sub.ReleaseRPCResources()
 
@@ -609,11 +721,13 @@ func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
 			fallthrough
 		case codec.ValueTypeSoftReference:
 			if s.c.ProtocolVersion() < versionSoftResourceReferenceAndDataValue {
+				s.updateCollection(event)
 				s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.AddEvent{Idx: idx, Value: rescache.Legacy120Value(v)}))
 				break
 			}
 			fallthrough
 		case codec.ValueTypePrimitive:
+			s.updateCollection(event)
 			s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.AddEvent{Idx: idx, Value: v.RawMessage}))
 		}
 
@@ -624,8 +738,29 @@ func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
 		if v.Type == codec.ValueTypeReference {
 			s.removeReference(v.RID)
 		}
+		s.updateCollection(event)
 		s.c.Send(rpc.NewEvent(s.rid, event.Event, event.Payload))
 
+	case "move":
+		// Legacy behavior
+		if s.c.ProtocolVersion() < versionCollectionMoveEvent {
+			s.sendCollectionEvent(&rescache.ResourceEvent{
+				Event:   "remove",
+				Idx:     event.From,
+				Value:   event.Value,
+				Payload: codec.EncodeRemoveEvent(&codec.RemoveEvent{Idx: event.From}),
+			})
+			s.sendCollectionEvent(&rescache.ResourceEvent{
+				Event:      "add",
+				Idx:        event.To,
+				Value:      event.Value,
+				Collection: event.Collection,
+			})
+			return
+		}
+		s.updateCollection(event)
+		s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.MoveEvent{From: event.From, To: event.To}))
+
 	case "delete":
 		s.state = stateDeleted
 		fallthrough
@@ -634,10 +769,108 @@ func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
 	}
 }
 
+// resetCollection replaces the collection values, or the values within the
+// window of a windowed subscription, with a single reset event once any added
+// resource reference is loaded. Clients not supporting the reset event have
+// their subscription to the collection removed, as the collection can no
+// longer be kept in sync.
+func (s *Subscription) resetCollection(event *rescache.ResourceEvent) {
+	// Legacy behavior
+	if s.c.ProtocolVersion() < versionCollectionResetEvent {
+		s.handleUnsubscribe(errCollectionReset)
+		return
+	}
+
+	var old []codec.Value
+	vals := event.Collection.Values
+	col := event.Collection
+	if s.window != nil {
+		old = s.window.slice(s.values)
+		s.values = vals
+		vals = s.window.slice(vals)
+		col = &rescache.Collection{Values: vals}
+	} else if s.collection != nil {
+		old = s.collection.Values
+	}
+
+	// Add references prior to removing the old ones to avoid unsubscribing
+	// to resources remaining in the collection.
+	var subs []*Subscription
+	for _, v := range vals {
+		if v.Type != codec.ValueTypeReference {
+			continue
+		}
+		sub, err := s.addReference(v.RID)
+		if err != nil {
+			s.c.Errorf("Subscription %s: Error subscribing to resource %s: %s", s.rid, v.RID, err)
+			// TODO handle error properly
+			return
+		}
+		if !sub.IsSent() {
+			subs = append(subs, sub)
+		}
+	}
+
+	send := func() {
+		for _, v := range old {
+			if v.Type == codec.ValueTypeReference {
+				s.removeReference(v.RID)
+			}
+		}
+		ev := rpc.ResetEvent{Values: col}
+		if len(subs) > 0 {
+			ev.Resources = &rpc.Resources{}
+			for _, sub := range subs {
+				sub.populateResources(ev.Resources)
+			}
+		}
+		s.updateCollection(event)
+		s.c.Send(rpc.NewEvent(s.rid, event.Event, ev))
+
+		for _, sub := range subs {
+			sub.ReleaseRPCResources()
+		}
+	}
+
+	// Quick exit if there are no unsent references
+	if subs == nil {
+		send()
+		return
+	}
+
+	// Start queueing again
+	s.queueEvents(queueReasonLoading)
+	count := len(subs)
+	for _, sub := range subs {
+		sub.OnReady(func() {
+			// Assert client is not disposed
+			if s.state == stateDisposed {
+				return
+			}
+
+			count--
+			if count > 0 {
+				return
+			}
+
+			send()
+			s.unqueueEvents(queueReasonLoading)
+		})
+	}
+}
+
 func (s *Subscription) processModelEvent(event *rescache.ResourceEvent) {
 	switch event.Event {
 	case "change":
 		ch := event.Changed
+		// Discard changes to fields not projected
+		if s.fields != nil {
+			ch = s.projectValues(ch)
+			if len(ch) == 0 {
+				s.updateModel(event)
+				return
+			}
+		}
 		old := event.OldValues
 		var subs []*Subscription
 
@@ -668,11 +901,12 @@ func (s *Subscription) processModelEvent(event *rescache.ResourceEvent) {
 
 		// Quick exit if there are no new unsent subscriptions
 		if subs == nil {
+			s.updateModel(event)
 			// Legacy behavior
 			if s.c.ProtocolVersion() < versionSoftResourceReferenceAndDataValue {
-				s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.ChangeEvent{Values: rescache.Legacy120ValueMap(event.Changed)}))
+				s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.ChangeEvent{Values: rescache.Legacy120ValueMap(ch)}))
 			} else {
-				s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.ChangeEvent{Values: event.Changed}))
+				s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.ChangeEvent{Values: ch}))
 			}
 			return
 		}
@@ -693,18 +927,19 @@ func (s *Subscription) processModelEvent(event *rescache.ResourceEvent) {
 				}
 
 				r := &rpc.Resources{}
+				s.updateModel(event)
 
 				// Legacy behavior
 				if s.c.ProtocolVersion() < versionSoftResourceReferenceAndDataValue {
 					for _, sub := range subs {
 						sub.populateResourcesLegacy(r)
 					}
-					s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.ChangeEvent{Values: rescache.Legacy120ValueMap(event.Changed), Resources: r}))
+					s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.ChangeEvent{Values: rescache.Legacy120ValueMap(ch), Resources: r}))
 				} else {
 					for _, sub := range subs {
 						sub.populateResources(r)
 					}
-					s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.ChangeEvent{Values: event.Changed, Resources: r}))
+					s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.ChangeEvent{Values: ch, Resources: r}))
 				}
 				for _, sub := range subs {
 					sub.ReleaseRPCResources()
@@ -751,6 +986,21 @@ func (s *Subscription) validateAccess(a *rescache.Access) {
 	}
 }
 
+// handleUnsubscribe handles the resource being removed from the cache with
+// an error, unsubscribing any direct subscription with the error as reason.
+// As indirect subscriptions can no longer be updated, a delete event is sent
+// for any resource still referenced.
+func (s *Subscription) handleUnsubscribe(err error) {
+	s.state = stateDeleted
+	if s.direct > 0 {
+		s.c.Unsubscribe(s, true, s.direct, true)
+		s.c.Send(rpc.NewEvent(s.rid, "unsubscribe", rpc.UnsubscribeEvent{Reason: reserr.RESError(err)}))
+	}
+	if s.indirect > 0 {
+		s.c.Send(rpc.NewEvent(s.rid, "delete", nil))
+	}
+}
+
 // Dispose removes any resourceSubscription and sets
 // the subscription state to stateDisposed
 func (s *Subscription) Dispose() {
@@ -762,6 +1012,7 @@ func (s *Subscription) Dispose() {
 	s.state = stateDisposed
 	s.readyCallbacks = nil
 	s.eventQueue = nil
+	s.windowEvents = nil
 
 	if s.resourceSub != nil {
 		s.unsubscribeRefs()
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// newFields creates a set of projected model fields from the subscribe
+// request params. Returns nil if no projection is requested.
+func newFields(params *rpc.SubscribeRequest) map[string]bool {
+	if params == nil || params.Fields == nil {
+		return nil
+	}
+	fields := make(map[string]bool, len(params.Fields))
+	for _, f := range params.Fields {
+		fields[f] = true
+	}
+	return fields
+}
+
+// equalFields reports whether a and b contains the same set of fields.
+func equalFields(a, b map[string]bool) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if len(a) != len(b) {
+		return false
+	}
+	for f := range a {
+		if !b[f] {
+			return false
+		}
+	}
+	return true
+}
+
+// projectValues returns the values of the projected fields.
+// If the subscription has no projection, vals is returned as is.
+func (s *Subscription) projectValues(vals map[string]codec.Value) map[string]codec.Value {
+	if s.fields == nil {
+		return vals
+	}
+	pvals := make(map[string]codec.Value, len(s.fields))
+	for k, v := range vals {
+		if s.fields[k] {
+			pvals[k] = v
+		}
+	}
+	return pvals
+}
+
+// projectedModel returns a model containing only the projected fields.
+func (s *Subscription) projectedModel() *rescache.Model {
+	return &rescache.Model{Values: s.projectValues(s.model.Values)}
+}
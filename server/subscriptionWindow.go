@@ -0,0 +1,203 @@
+package server
+
+import (
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// window represents a slice of a collection, as seen by the client.
+type window struct {
+	offset int
+	limit  int
+}
+
+// newWindow creates a window from the subscribe request params.
+// Returns nil if no window is requested.
+func newWindow(params *rpc.SubscribeRequest) *window {
+	if params == nil || params.Limit == nil {
+		return nil
+	}
+	w := &window{limit: *params.Limit}
+	if params.Offset != nil {
+		w.offset = *params.Offset
+	}
+	return w
+}
+
+// equals reports whether w and v describes the same window.
+func (w *window) equals(v *window) bool {
+	if w == nil || v == nil {
+		return w == v
+	}
+	return w.offset == v.offset && w.limit == v.limit
+}
+
+// contains reports whether the collection index idx is within the window.
+func (w *window) contains(idx int) bool {
+	return idx >= w.offset && idx < w.offset+w.limit
+}
+
+// slice returns the part of the collection values that is within the window.
+func (w *window) slice(vals []codec.Value) []codec.Value {
+	l := len(vals)
+	if w.offset >= l {
+		return []codec.Value{}
+	}
+	end := w.offset + w.limit
+	if end > l {
+		end = l
+	}
+	return vals[w.offset:end]
+}
+
+// windowCollection returns the collection values within the window.
+func (s *Subscription) windowCollection() *rescache.Collection {
+	return &rescache.Collection{Values: s.window.slice(s.values)}
+}
+
+// processWindowEvent translates a collection add, remove, or move event on the
+// full collection into events relative to the window, and sends them to the
+// client. The collection values are replaced by the immutable collection of
+// the event.
+func (s *Subscription) processWindowEvent(event *rescache.ResourceEvent) {
+	old := s.values
+	vals := event.Collection.Values
+	s.values = vals
+	switch event.Event {
+	case "add":
+		s.windowEvents = append(s.windowEvents, s.windowAdd(event.Idx, event.Value, vals)...)
+	case "remove":
+		s.windowEvents = append(s.windowEvents, s.windowRemove(event.Idx, old)...)
+	case "move":
+		s.windowEvents = append(s.windowEvents, s.windowMove(event.From, event.To, old, vals)...)
+	}
+	s.flushWindowEvents()
+}
+
+// flushWindowEvents sends any pending window events to the client until
+// either all are sent, or the events are queued again while waiting for
+// added resources to load. Returns true if all pending events were sent.
+func (s *Subscription) flushWindowEvents() bool {
+	for len(s.windowEvents) > 0 {
+		if s.queueFlag != 0 {
+			return false
+		}
+		ev := s.windowEvents[0]
+		s.windowEvents = s.windowEvents[1:]
+		s.sendCollectionEvent(ev)
+	}
+	s.windowEvents = nil
+	return s.queueFlag == 0
+}
+
+// windowAdd returns the events that describes the change within the window
+// when value v is added at idx, resulting in the collection values vals.
+func (s *Subscription) windowAdd(idx int, v codec.Value, vals []codec.Value) []*rescache.ResourceEvent {
+	if idx < 0 || idx >= len(vals) {
+		s.c.Errorf("Subscription %s: add event idx %d is out of bounds", s.rid, idx)
+		return nil
+	}
+
+	w := s.window
+	var evs []*rescache.ResourceEvent
+	switch {
+	case idx < w.offset:
+		// An item is shifted into the start of the window
+		if len(vals) <= w.offset {
+			return nil
+		}
+		evs = append(evs, windowAddEvent(0, vals[w.offset]))
+	case w.contains(idx):
+		evs = append(evs, windowAddEvent(idx-w.offset, v))
+	default:
+		return nil
+	}
+
+	// An item is shifted out of the end of the window
+	if len(vals) > w.offset+w.limit {
+		evs = append(evs, windowRemoveEvent(w.limit, vals[w.offset+w.limit]))
+	}
+	return evs
+}
+
+// windowRemove returns the events that describes the change within the window
+// when the value at idx is removed from the collection values old.
+func (s *Subscription) windowRemove(idx int, old []codec.Value) []*rescache.ResourceEvent {
+	l := len(old)
+	if idx < 0 || idx >= l {
+		s.c.Errorf("Subscription %s: remove event idx %d is out of bounds", s.rid, idx)
+		return nil
+	}
+
+	w := s.window
+	var evs []*rescache.ResourceEvent
+	switch {
+	case idx < w.offset:
+		// An item is shifted out of the start of the window
+		if l <= w.offset {
+			return nil
+		}
+		evs = append(evs, windowRemoveEvent(0, old[w.offset]))
+	case w.contains(idx):
+		evs = append(evs, windowRemoveEvent(idx-w.offset, old[idx]))
+	default:
+		return nil
+	}
+
+	// An item is shifted into the end of the window. As idx is before the
+	// end of the window, the item is found one index later in old.
+	if l-1 >= w.offset+w.limit {
+		evs = append(evs, windowAddEvent(w.limit-1, old[w.offset+w.limit]))
+	}
+	return evs
+}
+
+// windowMove returns the events that describes the change within the window
+// when a value is moved within the collection values old, resulting in the
+// collection values vals. A move within the window is sent as a move event,
+// while a move into or out of the window is sent as a remove and an add event.
+func (s *Subscription) windowMove(from, to int, old, vals []codec.Value) []*rescache.ResourceEvent {
+	l := len(old)
+	if from < 0 || from >= l || to < 0 || to >= l {
+		s.c.Errorf("Subscription %s: move event from %d to %d is out of bounds", s.rid, from, to)
+		return nil
+	}
+
+	w := s.window
+	switch {
+	case w.contains(from) && w.contains(to):
+	case from < w.offset && to < w.offset:
+	case from >= w.offset+w.limit && to >= w.offset+w.limit:
+	default:
+		return append(s.windowRemove(from, old), s.windowAdd(to, old[from], vals)...)
+	}
+
+	// Items outside the window are only shifted outside the window
+	if !w.contains(from) || from == to {
+		return nil
+	}
+	return []*rescache.ResourceEvent{{
+		Event: "move",
+		From:  from - w.offset,
+		To:    to - w.offset,
+		Value: vals[to],
+	}}
+}
+
+func windowAddEvent(idx int, v codec.Value) *rescache.ResourceEvent {
+	return &rescache.ResourceEvent{
+		Event: "add",
+		Idx:   idx,
+		Value: v,
+	}
+}
+
+func windowRemoveEvent(idx int, v codec.Value) *rescache.ResourceEvent {
+	return &rescache.ResourceEvent{
+		Event:   "remove",
+		Idx:     idx,
+		Value:   v,
+		Payload: codec.EncodeRemoveEvent(&codec.RemoveEvent{Idx: idx}),
+	}
+}
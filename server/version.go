@@ -2,11 +2,13 @@ package server
 
 // Protocol versions
 const (
-	versionLatest = 1002001 // MAJOR * 1000000 + MINOR * 1000 + PATCH
+	versionLatest = 1002002 // MAJOR * 1000000 + MINOR * 1000 + PATCH
 	versionLegacy = 1001001
 )
 
 const (
 	versionCallResourceResponse              = 1002000
 	versionSoftResourceReferenceAndDataValue = 1002001
+	versionCollectionMoveEvent               = 1002002
+	versionCollectionResetEvent              = 1002002
 )
@@ -20,7 +20,7 @@ import (
 
 type wsConn struct {
 	cid         string
-	ws          *websocket.Conn
+	ws          *websocket.Conn // Set by the worker goroutine while holding serv.mu
 	request     *http.Request
 	token       json.RawMessage
 	serv        *Service
@@ -33,6 +33,14 @@ type wsConn struct {
 	queue []func()
 	work  chan struct{}
 
+	// Header auth
+	authPending bool
+	held        [][]byte // Requests held while awaiting header auth
+
+	// Handler of events, instead of sending them to the WebSocket.
+	// Used by GraphQL over WebSocket connections.
+	onEvent func(data []byte)
+
 	mu sync.Mutex
 }
 
@@ -89,20 +97,22 @@ func (c *wsConn) ProtocolVersion() int {
 	return c.protocolVer
 }
 
-func (c *wsConn) listen() {
+// listen reads requests from the WebSocket connection until it is closed.
+// The connection is passed as c.ws may not yet be set by setWS.
+func (c *wsConn) listen(ws *websocket.Conn) {
 	var in []byte
 	var err error
 
 	// Loop until an error is returned when reading
 	for {
-		if _, in, err = c.ws.ReadMessage(); err != nil {
+		if _, in, err = ws.ReadMessage(); err != nil {
 			break
 		}
 
 		c.Tracef("--> %s", in)
 		in := in
 		c.Enqueue(func() {
-			rpc.HandleRequest(in, c)
+			c.handleRequest(in)
 		})
 	}
 
@@ -110,6 +120,58 @@ func (c *wsConn) listen() {
 	c.Tracef("Disconnected: %s", err)
 }
 
+// handleRequest handles a client request, or holds it if a header auth
+// request is pending.
+func (c *wsConn) handleRequest(in []byte) {
+	if c.authPending {
+		c.held = append(c.held, in)
+		return
+	}
+	rpc.HandleRequest(in, c)
+}
+
+// setWS sets the WebSocket connection once upgraded, for connections
+// created prior to the upgrade. The connection is set by the worker
+// goroutine, which reads it in Send and Reply, while holding the service
+// lock, under which it is read by Disconnect. If the connection is
+// disposing, or the service is stopping, the WebSocket connection is closed.
+func (c *wsConn) setWS(ws *websocket.Conn) {
+	if !c.Enqueue(func() {
+		c.serv.mu.Lock()
+		defer c.serv.mu.Unlock()
+		c.ws = ws
+		if c.serv.stopping {
+			ws.Close()
+		}
+	}) {
+		ws.Close()
+	}
+}
+
+// headerAuth sends an auth request to the header auth resource method.
+// Client requests are held until the auth response is received. The
+// returned channel receives true if a token was set, once done.
+func (c *wsConn) headerAuth() <-chan bool {
+	done := make(chan bool, 1)
+	c.Enqueue(func() {
+		c.authPending = true
+		c.AuthResource(c.serv.cfg.headerAuthRID, c.serv.cfg.headerAuthAction, nil, func(_ interface{}, err error) {
+			if err != nil {
+				c.Debugf("Header auth error: %s", err)
+			}
+			c.authPending = false
+			done <- c.token != nil && string(c.token) != "null"
+
+			held := c.held
+			c.held = nil
+			for _, in := range held {
+				c.handleRequest(in)
+			}
+		})
+	})
+	return done
+}
+
 // dispose closes the wsConn worker and disposes all subscription.
 // Returns false if dispose has already been called, otherwise true.
 func (c *wsConn) dispose() {
@@ -208,6 +270,10 @@ func (c *wsConn) enqueue(f func()) {
 }
 
 func (c *wsConn) Send(data []byte) {
+	if c.onEvent != nil {
+		c.onEvent(data)
+		return
+	}
 	if c.ws != nil {
 		c.Tracef("<<- %s", data)
 		c.ws.WriteMessage(websocket.TextMessage, data)
@@ -306,13 +372,19 @@ func (c *wsConn) GetSubscription(rid string, cb func(sub *Subscription, err erro
 	})
 }
 
-func (c *wsConn) SubscribeResource(rid string, cb func(data *rpc.Resources, err error)) {
+func (c *wsConn) SubscribeResource(rid string, params *rpc.SubscribeRequest, cb func(data *rpc.Resources, err error)) {
 	sub, err := c.Subscribe(rid, true)
 	if err != nil {
 		cb(nil, err)
 		return
 	}
 
+	if err := sub.setOptions(params); err != nil {
+		cb(nil, err)
+		c.Unsubscribe(sub, true, 1, true)
+		return
+	}
+
 	sub.CanGet(func(err error) {
 		if err != nil {
 			cb(nil, err)
@@ -363,6 +435,10 @@ func (c *wsConn) call(rid, action string, params interface{}, cb func(result jso
 			cb(nil, "", err)
 			return
 		}
+		if err := c.serv.validateParams(sub.ResourceName(), action, params); err != nil {
+			cb(nil, "", err)
+			return
+		}
 		c.serv.cache.Call(c, sub.ResourceName(), sub.ResourceQuery(), action, c.token, params, func(result json.RawMessage, refRID string, err error) {
 			c.Enqueue(func() {
 				cb(result, refRID, err)
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server/reserr"
 )
 
 func (s *Service) initWSHandler() {
@@ -41,21 +42,46 @@ func (s *Service) GetWSHandlerFunc() http.Handler {
 }
 
 func (s *Service) wsHandler(w http.ResponseWriter, r *http.Request) {
+	var conn *wsConn
+	if s.cfg.WSHeaderAuth {
+		// Create the connection prior to the upgrade to send the header
+		// auth request while the upgrade request is still available.
+		conn = s.newWSConn(nil, r, versionLegacy)
+		if conn == nil {
+			httpError(w, reserr.ErrServiceUnavailable, s.enc)
+			return
+		}
+		authDone := conn.headerAuth()
+		if s.cfg.WSRequireToken && !<-authDone {
+			conn.Tracef("Rejected: no token set by header auth")
+			conn.Dispose()
+			httpError(w, reserr.ErrAccessDenied, s.enc)
+			return
+		}
+	}
+
 	// Upgrade to gorilla websocket
 	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		s.Debugf("Failed to upgrade connection from %s: %s", r.RemoteAddr, err.Error())
+		if conn != nil {
+			conn.Dispose()
+		}
 		return
 	}
 
-	conn := s.newWSConn(ws, r, versionLegacy)
 	if conn == nil {
-		return
+		conn = s.newWSConn(ws, r, versionLegacy)
+		if conn == nil {
+			return
+		}
+	} else {
+		conn.setWS(ws)
 	}
 
 	conn.Tracef("Connected: %s", ws.RemoteAddr())
 
-	conn.listen()
+	conn.listen(ws)
 }
 
 // stopWSHandler disconnects all ws connections.
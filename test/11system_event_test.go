@@ -335,3 +335,83 @@ func TestSystemReset_MismatchingResourceTypeResponseOnCollection_LogsError(t *te
 		s.AssertErrorsLogged(t, 1)
 	})
 }
+
+// Test that a system.reset event, on a collection changed beyond the diff
+// edit limit, sends a single reset event replacing all values.
+func TestSystemReset_WithCollectionExceedingDiffLimit_ReplacesValues(t *testing.T) {
+	const n = 2002
+	before := make([]interface{}, n)
+	after := make([]interface{}, n+1)
+	after[0] = json.RawMessage(`{"rid":"test.model"}`)
+	for i := 0; i < n; i++ {
+		before[i] = i
+		after[n-i] = i
+	}
+
+	for _, window := range []bool{false, true} {
+		runNamedTest(t, fmt.Sprintf("window=%v", window), func(s *Session) {
+			c := s.Connect()
+			params := json.RawMessage(nil)
+			values := after
+			if window {
+				params = json.RawMessage(`{"offset":0,"limit":2}`)
+				values = after[:2]
+			}
+
+			// Get collection
+			creq := c.Request("subscribe.test.big", params)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.big").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.big").RespondSuccess(map[string]interface{}{"collection": before})
+			creq.GetResponse(t)
+
+			// Send system reset
+			s.SystemEvent("reset", json.RawMessage(`{"resources":["test.big"]}`))
+			s.GetRequest(t).AssertSubject(t, "get.test.big").RespondSuccess(map[string]interface{}{"collection": after})
+			s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+
+			// Validate the values are replaced by a single reset event
+			c.GetEvent(t).Equals(t, "test.big.reset", map[string]interface{}{
+				"values": values,
+				"models": map[string]interface{}{"test.model": json.RawMessage(resourceData("test.model"))},
+			})
+			c.AssertNoEvent(t, "test.big")
+		})
+	}
+}
+
+// Test that a system.reset event, on a collection changed beyond the diff
+// edit limit, unsubscribes clients not supporting the reset event.
+func TestSystemReset_WithCollectionExceedingDiffLimitOnLegacyClient_Unsubscribes(t *testing.T) {
+	const n = 2002
+	before := make([]interface{}, n)
+	after := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		before[i] = i
+		after[n-1-i] = i
+	}
+
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithVersion("1.2.1")
+
+		// Get collection
+		creq := c.Request("subscribe.test.big", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.big").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.big").RespondSuccess(map[string]interface{}{"collection": before})
+		creq.GetResponse(t)
+
+		// Send system reset
+		s.SystemEvent("reset", json.RawMessage(`{"resources":["test.big"]}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.big").RespondSuccess(map[string]interface{}{"collection": after})
+
+		// Validate the client is unsubscribed
+		c.GetEvent(t).Equals(t, "test.big.unsubscribe", json.RawMessage(`{"reason":{"code":"system.unsupportedProtocol","message":"Unsupported protocol: collection reset requires protocol version 1.2.2"}}`))
+		c.AssertNoEvent(t, "test.big")
+
+		// Validate the client may subscribe again
+		creq = c.Request("subscribe.test.big", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.big").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, map[string]interface{}{"collections": map[string]interface{}{"test.big": after}})
+	})
+}
@@ -0,0 +1,176 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test subscribing to a window of a collection
+func TestCollectionWindowSubscribe(t *testing.T) {
+	tbl := []struct {
+		Params   string // Subscribe request params (raw JSON)
+		Expected string // Expected collection in response (raw JSON)
+	}{
+		{`{"offset":0,"limit":2}`, `["foo",42]`},
+		{`{"limit":2}`, `["foo",42]`},
+		{`{"offset":1,"limit":2}`, `[42,true]`},
+		{`{"offset":2,"limit":10}`, `[true,null]`},
+		{`{"offset":4,"limit":2}`, `[]`},
+		{`{"offset":10,"limit":2}`, `[]`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.collection", json.RawMessage(l.Params))
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"test.collection":`+l.Expected+`}}`))
+		})
+	}
+}
+
+// Test subscribe request with invalid window params
+func TestCollectionWindowSubscribeWithInvalidParams(t *testing.T) {
+	tbl := []string{
+		`{"offset":1}`,
+		`{"limit":0}`,
+		`{"offset":-1,"limit":2}`,
+		`{"offset":"1","limit":2}`,
+		`[1,2]`,
+	}
+
+	for i, params := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			c.Request("subscribe.test.collection", json.RawMessage(params)).
+				GetResponse(t).
+				AssertError(t, reserr.ErrInvalidParams)
+		})
+	}
+}
+
+// Test that a window on a model results in an error
+func TestCollectionWindowSubscribeOnModel(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", json.RawMessage(`{"limit":2}`))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		creq.GetResponse(t).AssertErrorCode(t, reserr.CodeInvalidParams)
+
+		// Validate the model may be subscribed without a window
+		creq = c.Request("subscribe.test.model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+resourceData("test.model")+`}}`))
+	})
+}
+
+// Test that add and remove events are translated into events relative to the window
+func TestCollectionWindowEvents(t *testing.T) {
+	tbl := []struct {
+		Params       string   // Subscribe request params (raw JSON)
+		EventName    string   // Name of the event. Either add or remove.
+		EventPayload string   // Event payload (raw JSON)
+		Expected     []string // Expected client events as name and payload pairs
+	}{
+		{`{"offset":1,"limit":2}`, "add", `{"idx":0,"value":"bar"}`, []string{"add", `{"idx":0,"value":"foo"}`, "remove", `{"idx":2}`}},
+		{`{"offset":1,"limit":2}`, "add", `{"idx":1,"value":"bar"}`, []string{"add", `{"idx":0,"value":"bar"}`, "remove", `{"idx":2}`}},
+		{`{"offset":1,"limit":2}`, "add", `{"idx":2,"value":"bar"}`, []string{"add", `{"idx":1,"value":"bar"}`, "remove", `{"idx":2}`}},
+		{`{"offset":1,"limit":2}`, "add", `{"idx":3,"value":"bar"}`, nil},
+		{`{"offset":1,"limit":2}`, "add", `{"idx":4,"value":"bar"}`, nil},
+		{`{"offset":1,"limit":2}`, "remove", `{"idx":0}`, []string{"remove", `{"idx":0}`, "add", `{"idx":1,"value":null}`}},
+		{`{"offset":1,"limit":2}`, "remove", `{"idx":1}`, []string{"remove", `{"idx":0}`, "add", `{"idx":1,"value":null}`}},
+		{`{"offset":1,"limit":2}`, "remove", `{"idx":2}`, []string{"remove", `{"idx":1}`, "add", `{"idx":1,"value":null}`}},
+		{`{"offset":1,"limit":2}`, "remove", `{"idx":3}`, nil},
+		{`{"offset":2,"limit":10}`, "add", `{"idx":0,"value":"bar"}`, []string{"add", `{"idx":0,"value":42}`}},
+		{`{"offset":2,"limit":10}`, "add", `{"idx":4,"value":"bar"}`, []string{"add", `{"idx":2,"value":"bar"}`}},
+		{`{"offset":2,"limit":10}`, "remove", `{"idx":0}`, []string{"remove", `{"idx":0}`}},
+		{`{"offset":4,"limit":2}`, "add", `{"idx":0,"value":"bar"}`, []string{"add", `{"idx":0,"value":null}`}},
+		{`{"offset":4,"limit":2}`, "remove", `{"idx":0}`, nil},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.collection", json.RawMessage(l.Params))
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+			creq.GetResponse(t)
+
+			s.ResourceEvent("test.collection", l.EventName, json.RawMessage(l.EventPayload))
+			for j := 0; j < len(l.Expected); j += 2 {
+				c.GetEvent(t).Equals(t, "test.collection."+l.Expected[j], json.RawMessage(l.Expected[j+1]))
+			}
+			c.AssertNoEvent(t, "test.collection")
+		})
+	}
+}
+
+// Test that only resource references within the window are subscribed
+func TestCollectionWindowSubscribesOnlyReferencesWithinWindow(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.collection.parent", json.RawMessage(`{"limit":1}`))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.collection.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection.parent").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection.parent") + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"test.collection.parent":["parent"]}}`))
+		c.AssertNoNATSRequest(t, "test.collection")
+
+		// Remove the first item, shifting the reference into the window
+		s.ResourceEvent("test.collection.parent", "remove", json.RawMessage(`{"idx":0}`))
+		c.GetEvent(t).Equals(t, "test.collection.parent.remove", json.RawMessage(`{"idx":0}`))
+		s.GetRequest(t).
+			AssertSubject(t, "get.test.collection").
+			RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+		c.GetEvent(t).Equals(t, "test.collection.parent.add", json.RawMessage(`{"idx":0,"value":{"rid":"test.collection"},"collections":{"test.collection":`+resourceData("test.collection")+`}}`))
+
+		// Add an item at the start, shifting the reference out of the window
+		s.ResourceEvent("test.collection.parent", "add", json.RawMessage(`{"idx":0,"value":"foo"}`))
+		c.GetEvent(t).Equals(t, "test.collection.parent.add", json.RawMessage(`{"idx":0,"value":"foo"}`))
+		c.GetEvent(t).Equals(t, "test.collection.parent.remove", json.RawMessage(`{"idx":1}`))
+
+		// Validate the reference is no longer subscribed by the client
+		s.ResourceEvent("test.collection", "custom", common.CustomEvent())
+		c.AssertNoEvent(t, "test.collection")
+	})
+}
+
+// Test that a window conflicting with an existing subscription results in an error
+func TestCollectionWindowConflictingWithExistingSubscription(t *testing.T) {
+	tbl := []struct {
+		First  string // First subscribe request params (raw JSON)
+		Second string // Second subscribe request params (raw JSON)
+		Error  bool   // Expect an error on the second subscribe request
+	}{
+		{`null`, `{"limit":2}`, true},
+		{`{"limit":2}`, `null`, true},
+		{`{"limit":2}`, `{"offset":1,"limit":2}`, true},
+		{`{"limit":2}`, `{"offset":0,"limit":2}`, false},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.collection", json.RawMessage(l.First))
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+			creq.GetResponse(t)
+
+			cresp := c.Request("subscribe.test.collection", json.RawMessage(l.Second)).GetResponse(t)
+			if l.Error {
+				cresp.AssertErrorCode(t, reserr.CodeInvalidParams)
+			} else {
+				cresp.AssertResult(t, json.RawMessage(`{}`))
+			}
+		})
+	}
+}
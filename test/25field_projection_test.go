@@ -0,0 +1,148 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test subscribing to a selection of fields of a model
+func TestFieldProjectionSubscribe(t *testing.T) {
+	tbl := []struct {
+		Params   string // Subscribe request params (raw JSON)
+		Expected string // Expected model in response (raw JSON)
+	}{
+		{`{"fields":["string"]}`, `{"string":"foo"}`},
+		{`{"fields":["string","int"]}`, `{"string":"foo","int":42}`},
+		{`{"fields":["string","missing"]}`, `{"string":"foo"}`},
+		{`{"fields":["missing"]}`, `{}`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.model", json.RawMessage(l.Params))
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+l.Expected+`}}`))
+		})
+	}
+}
+
+// Test subscribe request with invalid fields params
+func TestFieldProjectionSubscribeWithInvalidParams(t *testing.T) {
+	tbl := []string{
+		`{"fields":[]}`,
+		`{"fields":"string"}`,
+		`{"fields":[42]}`,
+	}
+
+	for i, params := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			c.Request("subscribe.test.model", json.RawMessage(params)).
+				GetResponse(t).
+				AssertError(t, reserr.ErrInvalidParams)
+		})
+	}
+}
+
+// Test that fields on a collection results in an error
+func TestFieldProjectionSubscribeOnCollection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.collection", json.RawMessage(`{"fields":["string"]}`))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+		creq.GetResponse(t).AssertErrorCode(t, reserr.CodeInvalidParams)
+
+		// Validate the collection may be subscribed without fields
+		creq = c.Request("subscribe.test.collection", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"test.collection":`+resourceData("test.collection")+`}}`))
+	})
+}
+
+// Test that change events are limited to the projected fields
+func TestFieldProjectionChangeEvent(t *testing.T) {
+	tbl := []struct {
+		EventPayload string // Change event payload (raw JSON)
+		Expected     string // Expected client change event payload, or empty if no event is expected (raw JSON)
+	}{
+		{`{"values":{"string":"bar"}}`, `{"values":{"string":"bar"}}`},
+		{`{"values":{"string":"bar","int":12}}`, `{"values":{"string":"bar"}}`},
+		{`{"values":{"int":12}}`, ``},
+		{`{"values":{"string":{"action":"delete"}}}`, `{"values":{"string":{"action":"delete"}}}`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.model", json.RawMessage(`{"fields":["string"]}`))
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+			creq.GetResponse(t)
+
+			s.ResourceEvent("test.model", "change", json.RawMessage(l.EventPayload))
+			if l.Expected != "" {
+				c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(l.Expected))
+			}
+			c.AssertNoEvent(t, "test.model")
+		})
+	}
+}
+
+// Test that only resource references within the projected fields are subscribed
+func TestFieldProjectionSubscribesOnlyProjectedReferences(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model.parent", json.RawMessage(`{"fields":["name"]}`))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model.parent":{"name":"parent"}}}`))
+		c.AssertNoNATSRequest(t, "test.model")
+
+		// Change the reference, which is not projected
+		s.ResourceEvent("test.model.parent", "change", json.RawMessage(`{"values":{"child":null}}`))
+		c.AssertNoEvent(t, "test.model.parent")
+	})
+}
+
+// Test that fields conflicting with an existing subscription results in an error
+func TestFieldProjectionConflictingWithExistingSubscription(t *testing.T) {
+	tbl := []struct {
+		First  string // First subscribe request params (raw JSON)
+		Second string // Second subscribe request params (raw JSON)
+		Error  bool   // Expect an error on the second subscribe request
+	}{
+		{`null`, `{"fields":["string"]}`, true},
+		{`{"fields":["string"]}`, `null`, true},
+		{`{"fields":["string"]}`, `{"fields":["int"]}`, true},
+		{`{"fields":["string"]}`, `{"fields":["string","int"]}`, true},
+		{`{"fields":["string","int"]}`, `{"fields":["int","string"]}`, false},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.model", json.RawMessage(l.First))
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+			creq.GetResponse(t)
+
+			cresp := c.Request("subscribe.test.model", json.RawMessage(l.Second)).GetResponse(t)
+			if l.Error {
+				cresp.AssertErrorCode(t, reserr.CodeInvalidParams)
+			} else {
+				cresp.AssertResult(t, json.RawMessage(`{}`))
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/posener/wstest"
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func graphqlConfig(c *server.Config) {
+	path := "/graphql"
+	c.GraphQLPath = &path
+}
+
+func graphqlRequest(s *Session, query string, variables string) *HTTPRequest {
+	body := `{"query":` + jsonString(query)
+	if variables != "" {
+		body += `,"variables":` + variables
+	}
+	body += `}`
+	return s.HTTPRequest("POST", "/graphql", []byte(body), func(r *http.Request) {
+		r.Header.Set("Content-Type", "application/json")
+	})
+}
+
+// graphqlWS is a GraphQL over WebSocket client connection.
+type graphqlWS struct {
+	ws   *websocket.Conn
+	msgs chan json.RawMessage
+	err  chan error
+}
+
+func graphqlConnect(s *Session) *graphqlWS {
+	d := wstest.NewDialer(s.s)
+	d.Subprotocols = []string{"graphql-transport-ws"}
+	ws, _, err := d.Dial("ws://example.org/graphql", nil)
+	if err != nil {
+		panic("test: failed to connect to GraphQL: " + err.Error())
+	}
+	g := &graphqlWS{ws: ws, msgs: make(chan json.RawMessage, 256), err: make(chan error, 1)}
+	go func() {
+		for {
+			_, in, err := ws.ReadMessage()
+			if err != nil {
+				g.err <- err
+				return
+			}
+			g.msgs <- json.RawMessage(in)
+		}
+	}()
+	return g
+}
+
+// graphqlConnectAndInit connects and awaits the connection_ack.
+func graphqlConnectAndInit(t *testing.T, s *Session) *graphqlWS {
+	g := graphqlConnect(s)
+	g.Send(`{"type":"connection_init"}`)
+	g.GetMessage(t, `{"type":"connection_ack"}`)
+	return g
+}
+
+func (g *graphqlWS) Send(msg string) {
+	if err := g.ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		panic("test: failed to write GraphQL message: " + err.Error())
+	}
+}
+
+func (g *graphqlWS) Subscribe(id string, query string) {
+	g.Send(`{"id":"` + id + `","type":"subscribe","payload":{"query":` + jsonString(query) + `}}`)
+}
+
+// GetMessage awaits a message and asserts it equals the expected raw JSON.
+func (g *graphqlWS) GetMessage(t *testing.T, expected string) {
+	select {
+	case msg := <-g.msgs:
+		var a, b interface{}
+		if err := json.Unmarshal(msg, &a); err != nil {
+			t.Fatalf("error decoding message: %s", err)
+		}
+		json.Unmarshal([]byte(expected), &b)
+		if !reflect.DeepEqual(a, b) {
+			t.Fatalf("expected message:\n%s\nbut got:\n%s", expected, msg)
+		}
+	case err := <-g.err:
+		t.Fatalf("expected message %s, but got error: %s", expected, err)
+	case <-time.After(timeoutSeconds * time.Second):
+		t.Fatalf("expected message %s, but found none", expected)
+	}
+}
+
+// AssertClosed asserts the connection is closed with the close code.
+func (g *graphqlWS) AssertClosed(t *testing.T, code int) {
+	select {
+	case msg := <-g.msgs:
+		t.Fatalf("expected connection to be closed, but got message: %s", msg)
+	case err := <-g.err:
+		if !websocket.IsCloseError(err, code) {
+			t.Fatalf("expected close code %d, but got: %s", code, err)
+		}
+	case <-time.After(timeoutSeconds * time.Second):
+		t.Fatalf("expected connection to be closed, but it was not")
+	}
+}
+
+func (g *graphqlWS) Close() {
+	g.ws.Close()
+}
+
+func jsonString(s string) string {
+	dta, _ := json.Marshal(s)
+	return string(dta)
+}
+
+// Test GraphQL queries on models and collections
+func TestGraphQLQuery(t *testing.T) {
+	tbl := []struct {
+		RID      string // Resource ID to respond to
+		Type     string // Resource type. Either model or collection.
+		Query    string // GraphQL query
+		Expected string // Expected response (raw JSON)
+	}{
+		{"test.model", "model", `{ model(rid: "test.model") { string int } }`, `{"data":{"model":{"string":"foo","int":42}}}`},
+		{"test.model", "model", `query { m: model(rid: "test.model") { s: string missing _rid } }`, `{"data":{"m":{"s":"foo","missing":null,"_rid":"test.model"}}}`},
+		{"test.model", "model", `{ resource(rid: "test.model") { ...F } } fragment F on Model { bool null }`, `{"data":{"resource":{"bool":true,"null":null}}}`},
+		{"test.model", "model", `query Q($rid: String!) { model(rid: $rid) { string } }`, `{"data":{"model":{"string":"foo"}}}`},
+		{"test.model", "model", `{ model(rid: "test.model") { string int @skip(if: true) bool @include(if: false) } }`, `{"data":{"model":{"string":"foo"}}}`},
+		{"test.collection", "collection", `{ collection(rid: "test.collection") }`, `{"data":{"collection":["foo",42,true,null]}}`},
+		{"test.collection", "collection", `{ resource(rid: "test.collection") }`, `{"data":{"resource":["foo",42,true,null]}}`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := graphqlRequest(s, l.Query, `{"rid":"test.model"}`)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access."+l.RID).RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get."+l.RID).RespondSuccess(json.RawMessage(`{"` + l.Type + `":` + resourceData(l.RID) + `}`))
+			hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(l.Expected))
+		}, graphqlConfig)
+	}
+}
+
+// Test GraphQL queries following resource references
+func TestGraphQLQueryFollowingReferences(t *testing.T) {
+	tbl := []struct {
+		Query    string // GraphQL query
+		Expected string // Expected response (raw JSON)
+	}{
+		{`{ model(rid: "test.model.parent") { name child { string } } }`, `{"data":{"model":{"name":"parent","child":{"string":"foo"}}}}`},
+		{`{ model(rid: "test.model.parent") { name child } }`, `{"data":{"model":{"name":"parent","child":"test.model"}}}`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := graphqlRequest(s, l.Query, "")
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+			s.GetRequest(t).
+				AssertSubject(t, "get.test.model").
+				RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+			hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(l.Expected))
+		}, graphqlConfig)
+	}
+}
+
+// Test GraphQL query errors being returned per field
+func TestGraphQLQueryFieldErrors(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := graphqlRequest(s, `{ a: model(rid: "test.model") { string } b: collection(rid: "test.model") }`, "")
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"data":{"a":{"string":"foo"},"b":null},"errors":[{"message":"Resource is not a collection","path":["b"],"extensions":{"code":"system.invalidRequest","message":"Resource is not a collection"}}]}`))
+	}, graphqlConfig)
+}
+
+// Test GraphQL query on a resource with access denied
+func TestGraphQLQueryWithAccessDenied(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := graphqlRequest(s, `{ model(rid: "test.model") { string } }`, "")
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"data":{"model":null},"errors":[{"message":"Access denied","path":["model"],"extensions":{"code":"system.accessDenied","message":"Access denied"}}]}`))
+	}, graphqlConfig)
+}
+
+// Test GraphQL mutations mapped to call requests
+func TestGraphQLMutationCall(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := graphqlRequest(s, `mutation($v: Int) { call(rid: "test.model", method: "set", params: {value: $v, list: [1, "two"]}) }`, `{"v":42}`)
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			RespondSuccess(json.RawMessage(`{"call":"set"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.set").
+			AssertPathPayload(t, "params", json.RawMessage(`{"value":42,"list":[1,"two"]}`)).
+			RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"data":{"call":{"foo":"bar"}}}`))
+	}, graphqlConfig)
+}
+
+// Test GraphQL mutation with a resource response
+func TestGraphQLMutationCallWithResourceResponse(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := graphqlRequest(s, `mutation { call(rid: "test.model", method: "create") { string } }`, "")
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.create").
+			RespondResource("test.model")
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"data":{"call":{"string":"foo"}}}`))
+	}, graphqlConfig)
+}
+
+// Test GraphQL requests resulting in request errors
+func TestGraphQLRequestErrors(t *testing.T) {
+	tbl := []struct {
+		Query string // GraphQL query
+		Code  string // Expected error code
+	}{
+		{`{ model(rid: "test.model") { string }`, reserr.CodeInvalidRequest},
+		{`subscription { model(rid: "test.model") { string } }`, reserr.CodeInvalidRequest},
+		{`query A { model(rid: "test.model") { string } } query B { model(rid: "test.model") { string } }`, reserr.CodeInvalidRequest},
+		{`{ ...Missing }`, reserr.CodeInvalidRequest},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			resp := graphqlRequest(s, l.Query, "").GetResponse(t)
+			resp.AssertStatusCode(t, http.StatusOK)
+			var r struct {
+				Data   interface{} `json:"data"`
+				Errors []struct {
+					Extensions reserr.Error `json:"extensions"`
+				} `json:"errors"`
+			}
+			if err := json.Unmarshal(resp.Body.Bytes(), &r); err != nil {
+				t.Fatalf("error decoding response: %s", err)
+			}
+			if r.Data != nil || len(r.Errors) != 1 || r.Errors[0].Extensions.Code != l.Code {
+				t.Fatalf("expected a single error with code %s, but got:\n%s", l.Code, resp.Body.String())
+			}
+		}, graphqlConfig)
+	}
+}
+
+// Test that the GraphQL endpoint is disabled by default
+func TestGraphQLDisabledByDefault(t *testing.T) {
+	runTest(t, func(s *Session) {
+		graphqlRequest(s, `{ model(rid: "test.model") { string } }`, "").
+			GetResponse(t).
+			AssertStatusCode(t, http.StatusNotFound)
+	})
+}
+
+// Test GraphQL subscriptions over WebSocket sending a new result on change
+func TestGraphQLWSSubscription(t *testing.T) {
+	runTest(t, func(s *Session) {
+		g := graphqlConnectAndInit(t, s)
+		g.Subscribe("1", `subscription { model(rid: "test.model") { string } }`)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		g.GetMessage(t, `{"id":"1","type":"next","payload":{"data":{"model":{"string":"foo"}}}}`)
+
+		// A change of an unselected field sends no result
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":12}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		g.GetMessage(t, `{"id":"1","type":"next","payload":{"data":{"model":{"string":"bar"}}}}`)
+
+		// Completed subscriptions send no results
+		g.Send(`{"id":"1","type":"complete"}`)
+		g.Send(`{"type":"ping"}`)
+		g.GetMessage(t, `{"type":"pong"}`)
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"baz"}}`))
+		g.Send(`{"type":"ping"}`)
+		g.GetMessage(t, `{"type":"pong"}`)
+		g.Close()
+	}, graphqlConfig)
+}
+
+// Test GraphQL subscriptions over WebSocket following collection references
+func TestGraphQLWSSubscriptionOnCollection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		g := graphqlConnectAndInit(t, s)
+		g.Subscribe("1", `subscription { collection(rid: "test.collection") }`)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+		g.GetMessage(t, `{"id":"1","type":"next","payload":{"data":{"collection":["foo",42,true,null]}}}`)
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":1,"value":"bar"}`))
+		g.GetMessage(t, `{"id":"1","type":"next","payload":{"data":{"collection":["foo","bar",42,true,null]}}}`)
+		s.ResourceEvent("test.collection", "remove", json.RawMessage(`{"idx":0}`))
+		g.GetMessage(t, `{"id":"1","type":"next","payload":{"data":{"collection":["bar",42,true,null]}}}`)
+		g.Close()
+	}, graphqlConfig)
+}
+
+// Test GraphQL queries over WebSocket completing after a single result
+func TestGraphQLWSQuery(t *testing.T) {
+	runTest(t, func(s *Session) {
+		g := graphqlConnectAndInit(t, s)
+		g.Subscribe("1", `{ model(rid: "test.model") { string } }`)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		g.GetMessage(t, `{"id":"1","type":"next","payload":{"data":{"model":{"string":"foo"}}}}`)
+		g.GetMessage(t, `{"id":"1","type":"complete"}`)
+		g.Close()
+	}, graphqlConfig)
+}
+
+// Test GraphQL over WebSocket protocol errors closing the connection
+func TestGraphQLWSProtocolErrors(t *testing.T) {
+	tbl := []struct {
+		Init     bool     // Send connection_init first
+		Messages []string // Messages to send
+		Code     int      // Expected close code
+	}{
+		{false, []string{`{"id":"1","type":"subscribe","payload":{"query":"{ __typename }"}}`}, 4401},
+		{true, []string{`{"type":"connection_init"}`}, 4429},
+		{true, []string{`{"type":"unknown"}`}, 4400},
+		{true, []string{`{"type":"subscribe","payload":{"query":"{ __typename }"}}`}, 4400},
+		{true, []string{`{"id":"1","type":"subscribe","payload":{"query":"subscription { model(rid: \"test.model\") { string } }"}}`, `{"id":"1","type":"subscribe","payload":{"query":"{ __typename }"}}`}, 4409},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			var g *graphqlWS
+			if l.Init {
+				g = graphqlConnectAndInit(t, s)
+			} else {
+				g = graphqlConnect(s)
+			}
+			for _, m := range l.Messages {
+				g.Send(m)
+			}
+			if l.Code == 4409 {
+				// Respond to the requests of the first subscription
+				mreqs := s.GetParallelRequests(t, 2)
+				mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+				mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+			}
+			g.AssertClosed(t, l.Code)
+		}, graphqlConfig)
+	}
+}
+
+// Test GraphQL subscriptions over WebSocket sending a new result on change
+// of a referenced resource
+func TestGraphQLWSSubscriptionFollowingReferences(t *testing.T) {
+	runTest(t, func(s *Session) {
+		g := graphqlConnectAndInit(t, s)
+		g.Subscribe("1", `subscription { model(rid: "test.model.parent") { name child { string } } }`)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+		s.GetRequest(t).
+			AssertSubject(t, "get.test.model").
+			RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		g.GetMessage(t, `{"id":"1","type":"next","payload":{"data":{"model":{"name":"parent","child":{"string":"foo"}}}}}`)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		g.GetMessage(t, `{"id":"1","type":"next","payload":{"data":{"model":{"name":"parent","child":{"string":"bar"}}}}}`)
+		g.Close()
+	}, graphqlConfig)
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+const testSchema = `{
+	"info": {"title": "Test API", "version": "1.2.3"},
+	"resources": [
+		{
+			"pattern": "test.model.$id",
+			"type": "model",
+			"description": "Test model",
+			"methods": {
+				"set": {"params": {"type": "object"}},
+				"delete": {}
+			}
+		},
+		{
+			"pattern": "test.*.collection",
+			"type": "collection"
+		},
+		{
+			"pattern": "test.>"
+		}
+	]
+}`
+
+// schemaConfig writes the schema to a temporary file, and returns a
+// config function setting the schema file path.
+func schemaConfig(t *testing.T, sch string) (func(c *server.Config), func()) {
+	f, err := ioutil.TempFile("", "schema*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(sch); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	name := f.Name()
+	return func(c *server.Config) {
+			c.Schema = &name
+		}, func() {
+			os.Remove(name)
+		}
+}
+
+// Test that the OpenAPI document is served when a schema is configured
+func TestOpenAPIDocument(t *testing.T) {
+	cfg, cleanup := schemaConfig(t, testSchema)
+	defer cleanup()
+
+	runTest(t, func(s *Session) {
+		resp := s.HTTPRequest("GET", "/api/openapi.json", nil).GetResponse(t)
+		resp.AssertStatusCode(t, http.StatusOK)
+
+		var doc struct {
+			OpenAPI string                            `json:"openapi"`
+			Info    map[string]string                 `json:"info"`
+			Paths   map[string]map[string]interface{} `json:"paths"`
+		}
+		if err := json.Unmarshal(resp.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("error decoding OpenAPI document: %s", err)
+		}
+		if doc.OpenAPI != "3.0.3" {
+			t.Errorf("expected openapi version 3.0.3, but got %#v", doc.OpenAPI)
+		}
+		if doc.Info["title"] != "Test API" {
+			t.Errorf("expected info title \"Test API\", but got %#v", doc.Info["title"])
+		}
+
+		expected := map[string][]string{
+			"/api/test/model/{id}":        {"get", "delete"},
+			"/api/test/model/{id}/set":    {"post"},
+			"/api/test/model/{id}/delete": {"post"},
+			"/api/test/{p1}/collection":   {"get"},
+		}
+		if len(doc.Paths) != len(expected) {
+			t.Fatalf("expected %d paths, but got %d:\n%s", len(expected), len(doc.Paths), resp.Body.String())
+		}
+		for path, methods := range expected {
+			item, ok := doc.Paths[path]
+			if !ok {
+				t.Fatalf("expected path %s, but it was missing:\n%s", path, resp.Body.String())
+			}
+			for _, m := range methods {
+				if _, ok := item[m]; !ok {
+					t.Errorf("expected %s operation for path %s, but it was missing", m, path)
+				}
+			}
+		}
+	}, cfg, func(c *server.Config) {
+		method := "delete"
+		c.DELETEMethod = &method
+	})
+}
+
+// Test that the OpenAPI document is not served without a schema
+func TestOpenAPIDocumentWithoutSchema(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/api/openapi.json", nil).
+			GetResponse(t).
+			AssertStatusCode(t, http.StatusNotFound)
+	})
+}
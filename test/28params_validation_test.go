@@ -0,0 +1,98 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+const validationSchema = `{
+	"resources": [
+		{
+			"pattern": "test.model",
+			"type": "model",
+			"methods": {
+				"set": {
+					"params": {
+						"type": "object",
+						"properties": {
+							"name": {"type": "string", "minLength": 1},
+							"age": {"type": "integer", "minimum": 0},
+							"score": {"type": "number", "maximum": 100}
+						},
+						"required": ["name"],
+						"additionalProperties": false
+					}
+				},
+				"noschema": {}
+			}
+		}
+	]
+}`
+
+// Test call params validated against the schema
+func TestCallParamsValidation(t *testing.T) {
+	tbl := []struct {
+		Method   string // Call method
+		Params   string // Call params (raw JSON)
+		Expected string // Expected error message. Empty means valid params.
+	}{
+		{"set", `{"name":"foo"}`, ""},
+		{"set", `{"name":"foo","age":42}`, ""},
+		{"set", `null`, "Invalid parameters: must be of type object"},
+		{"set", `{"age":42}`, `Invalid parameters: missing required property "name"`},
+		{"set", `{"name":""}`, "Invalid parameters: /name: must be at least 1 characters long"},
+		{"set", `{"name":"foo","age":1.5}`, "Invalid parameters: /age: must be of type integer"},
+		{"set", `{"name":"foo","age":-1}`, "Invalid parameters: /age: must be greater than or equal to 0"},
+		{"set", `{"name":"foo","extra":true}`, "Invalid parameters: /extra: additional property is not allowed"},
+		{"set", `{"name":"foo","score":1e2}`, ""},
+		{"set", `{"name":"foo","score":1e999999}`, "Invalid parameters: /score: must be a number of at most 64 digits and an exponent of at most 400"},
+		{"set", `{"name":"foo","score":` + strings.Repeat("1", 65) + `}`, "Invalid parameters: /score: must be a number of at most 64 digits and an exponent of at most 400"},
+		{"set", `{"name":"foo","age":1e999999}`, "Invalid parameters: /age: must be of type integer"},
+		{"noschema", `[1,2,3]`, ""},
+		{"other", `"anything"`, ""},
+	}
+
+	for i, l := range tbl {
+		cfg, cleanup := schemaConfig(t, validationSchema)
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("call.test.model."+l.Method, json.RawMessage(l.Params))
+			s.GetRequest(t).
+				AssertSubject(t, "access.test.model").
+				RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			if l.Expected == "" {
+				s.GetRequest(t).
+					AssertSubject(t, "call.test.model."+l.Method).
+					AssertPathPayload(t, "params", json.RawMessage(l.Params)).
+					RespondSuccess(nil)
+				creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+			} else {
+				resp := creq.GetResponse(t)
+				resp.AssertErrorCode(t, reserr.CodeInvalidParams)
+				if resp.Error.Message != l.Expected {
+					t.Fatalf("expected error message to be:\n%s\nbut got:\n%s", l.Expected, resp.Error.Message)
+				}
+			}
+		}, cfg)
+		cleanup()
+	}
+}
+
+// Test HTTP POST params validated against the schema
+func TestHTTPPostParamsValidation(t *testing.T) {
+	cfg, cleanup := schemaConfig(t, validationSchema)
+	defer cleanup()
+
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/set", []byte(`{"age":"old"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		hreq.GetResponse(t).Equals(t, http.StatusBadRequest, json.RawMessage(`{"code":"system.invalidParams","message":"Invalid parameters: missing required property \"name\"","data":[{"path":"","message":"missing required property \"name\""},{"path":"/age","message":"must be of type integer"}]}`))
+	}, cfg)
+}
@@ -0,0 +1,132 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/posener/wstest"
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func wsHeaderAuthConfig(requireToken bool) func(*server.Config) {
+	return func(c *server.Config) {
+		headerAuth := "vault.method"
+		c.HeaderAuth = &headerAuth
+		c.WSHeaderAuth = true
+		c.WSRequireToken = requireToken
+	}
+}
+
+// Test that a header auth request is sent on WebSocket connect, and that
+// client requests are held until the auth response is received
+func TestWSHeaderAuth_OnConnect_SendsAuthRequestAndHoldsRequests(t *testing.T) {
+	token := json.RawMessage(`{"user":"foo"}`)
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithHeader(http.Header{"Authorization": {"Bearer foo"}})
+
+		req := s.GetRequest(t).
+			AssertSubject(t, "auth.vault.method").
+			AssertPathPayload(t, "header.Authorization", []string{"Bearer foo"})
+		cid := req.PathPayload(t, "cid").(string)
+
+		// Send a client request while awaiting the auth response
+		creq := c.Request("subscribe.test.model", nil)
+
+		s.ConnEvent(cid, "token", struct {
+			Token interface{} `json:"token"`
+		}{token})
+		req.RespondSuccess(nil)
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").
+			AssertPathPayload(t, "token", token).
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").
+			RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+resourceData("test.model")+`}}`))
+	}, wsHeaderAuthConfig(false))
+}
+
+// Test that the connection is kept without a token when a token is not required
+func TestWSHeaderAuth_WithoutToken_KeepsConnection(t *testing.T) {
+	tbl := []struct {
+		AuthResponse interface{} // Response on auth request. requestTimeout means timeout.
+	}{
+		{nil},
+		{reserr.ErrAccessDenied},
+		{requestTimeout},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.ConnectWithHeader(nil)
+			req := s.GetRequest(t).AssertSubject(t, "auth.vault.method")
+			creq := c.Request("version", versionRequest)
+			if l.AuthResponse == requestTimeout {
+				req.Timeout()
+			} else if err, ok := l.AuthResponse.(*reserr.Error); ok {
+				req.RespondError(err)
+			} else {
+				req.RespondSuccess(l.AuthResponse)
+			}
+			creq.GetResponse(t).AssertResult(t, versionResult)
+		}, wsHeaderAuthConfig(false))
+	}
+}
+
+// Test that the upgrade is rejected if a token is required but not set
+func TestWSHeaderAuth_RequireToken(t *testing.T) {
+	token := json.RawMessage(`{"user":"foo"}`)
+	tbl := []struct {
+		Token    interface{} // Token to send. noToken means no token events should be sent.
+		Expected bool        // Expect the upgrade to succeed
+	}{
+		{token, true},
+		{noToken, false},
+		{nil, false},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			d := wstest.NewDialer(s.s.GetWSHandlerFunc())
+			type dialResult struct {
+				resp *http.Response
+				err  error
+			}
+			ch := make(chan dialResult, 1)
+			go func() {
+				ws, resp, err := d.Dial("ws://example.org/", nil)
+				if err == nil {
+					ws.Close()
+				}
+				ch <- dialResult{resp, err}
+			}()
+
+			req := s.GetRequest(t).AssertSubject(t, "auth.vault.method")
+			if l.Token != noToken {
+				cid := req.PathPayload(t, "cid").(string)
+				s.ConnEvent(cid, "token", struct {
+					Token interface{} `json:"token"`
+				}{l.Token})
+			}
+			req.RespondSuccess(nil)
+
+			r := <-ch
+			if l.Expected {
+				if r.err != nil {
+					t.Fatalf("expected upgrade to succeed, but got error: %s", r.err)
+				}
+			} else {
+				if r.err == nil {
+					t.Fatalf("expected upgrade to be rejected, but it succeeded")
+				}
+				if r.resp == nil || r.resp.StatusCode != http.StatusUnauthorized {
+					t.Fatalf("expected response status %d, but got %+v", http.StatusUnauthorized, r.resp)
+				}
+			}
+		}, wsHeaderAuthConfig(true))
+	}
+}
@@ -0,0 +1,55 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// Test that a resource is fetched over HTTP from a service connected over
+// the transport
+func TestMQTransport_HTTPGet_ServesResource(t *testing.T) {
+	runMQTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(model))
+	})
+}
+
+// Test that a call request is sent to a service connected over the transport
+func TestMQTransport_Call_ReturnsResult(t *testing.T) {
+	runMQTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+	})
+}
+
+// Test that events sent by a service connected over the transport are sent
+// to the subscribing client
+func TestMQTransport_Event_SentToClient(t *testing.T) {
+	runMQTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		s.ResourceEvent("test.model", "custom", common.CustomEvent())
+		c.GetEvent(t).Equals(t, "test.model.custom", common.CustomEvent())
+	})
+}
+
+// Test that a system.reset event sent by a service connected over the
+// transport triggers get requests on subscribed resources
+func TestMQTransport_SystemReset_TriggersGetRequest(t *testing.T) {
+	runMQTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		s.SystemEvent("reset", json.RawMessage(`{"resources":["test.>"]}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		c.AssertNoEvent(t, "test.model")
+	})
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func bulkheadConfig(maxRequests, maxWait int) func(*server.Config) {
+	return func(c *server.Config) {
+		c.Bulkheads = []server.BulkheadConfig{{Pattern: "test.>", MaxRequests: maxRequests, MaxWait: maxWait}}
+	}
+}
+
+// Test that requests exceeding the bulkhead limit fail, while requests for
+// resources not matching the bulkhead pattern are sent
+func TestBulkhead_ExceedingLimit_FailsRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		successResponse := json.RawMessage(`{"foo":"bar"}`)
+		fullCallAccess := json.RawMessage(`{"get":true,"call":"*"}`)
+
+		// First request is outstanding
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(fullCallAccess)
+		req := s.GetRequest(t).AssertSubject(t, "call.test.model.method")
+
+		// Second request exceeds the limit
+		s.HTTPRequest("POST", "/api/test/other/method", nil).
+			GetResponse(t).
+			Equals(t, http.StatusServiceUnavailable, reserr.ErrTooManyRequests)
+
+		// Request not matching the pattern is not limited
+		oreq := s.HTTPRequest("POST", "/api/example/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.example.model").RespondSuccess(fullCallAccess)
+		s.GetRequest(t).AssertSubject(t, "call.example.model.method").RespondSuccess(successResponse)
+		oreq.GetResponse(t).Equals(t, http.StatusOK, successResponse)
+
+		req.RespondSuccess(successResponse)
+		hreq.GetResponse(t).Equals(t, http.StatusOK, successResponse)
+	}, bulkheadConfig(1, 0))
+}
+
+// Test that excess requests wait for outstanding requests to complete
+func TestBulkhead_ExceedingLimitWithWait_SendsRequestOnCompletion(t *testing.T) {
+	runTest(t, func(s *Session) {
+		successResponse := json.RawMessage(`{"foo":"bar"}`)
+		fullCallAccess := json.RawMessage(`{"get":true,"call":"*"}`)
+
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(fullCallAccess)
+		req := s.GetRequest(t).AssertSubject(t, "call.test.model.method")
+
+		// Second request waits
+		c := s.Connect()
+		creq := c.Request("subscribe.test.other", nil)
+		c.AssertNoNATSRequest(t, "example.model")
+
+		req.RespondSuccess(successResponse)
+		hreq.GetResponse(t).Equals(t, http.StatusOK, successResponse)
+
+		// Waiting access and get requests are sent one at a time
+		for i := 0; i < 2; i++ {
+			req := s.GetRequest(t)
+			switch req.Subject {
+			case "access.test.other":
+				req.RespondSuccess(json.RawMessage(`{"get":true}`))
+			case "get.test.other":
+				req.RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+			default:
+				t.Fatalf("expected access or get request for test.other, but got %s", req.Subject)
+			}
+		}
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.other":{"foo":"bar"}}}`))
+	}, bulkheadConfig(1, 1000))
+}
+
+// Test that excess requests fail when the wait times out
+func TestBulkhead_ExceedingLimitWithWait_FailsOnTimeout(t *testing.T) {
+	runTest(t, func(s *Session) {
+		successResponse := json.RawMessage(`{"foo":"bar"}`)
+		fullCallAccess := json.RawMessage(`{"get":true,"call":"*"}`)
+
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(fullCallAccess)
+		req := s.GetRequest(t).AssertSubject(t, "call.test.model.method")
+
+		s.HTTPRequest("POST", "/api/test/other/method", nil).
+			GetResponse(t).
+			Equals(t, http.StatusServiceUnavailable, reserr.ErrTooManyRequests)
+
+		req.RespondSuccess(successResponse)
+		hreq.GetResponse(t).Equals(t, http.StatusOK, successResponse)
+	}, bulkheadConfig(1, 50))
+}
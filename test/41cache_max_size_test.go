@@ -0,0 +1,79 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// awaitCacheStats waits until the cache stats satisfy the condition, and
+// returns the stats.
+func awaitCacheStats(t *testing.T, s *Session, cond func(st rescache.Stats) bool) rescache.Stats {
+	deadline := time.Now().Add(timeoutSeconds * time.Second)
+	for {
+		st := s.s.CacheStats()
+		if cond(st) {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected cache stats condition to be met, but got %+v", st)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Test that the cache tracks the size of subscribed and unsubscribed resources
+func TestCacheStats_SubscribeAndUnsubscribe_TracksSize(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		st := s.s.CacheStats()
+		if st.Resources != 1 || st.Unsubscribed != 0 || st.Size <= 0 {
+			t.Fatalf("expected 1 subscribed resource with size, but got %+v", st)
+		}
+		size := st.Size
+
+		c.Request("unsubscribe.test.model", nil).GetResponse(t)
+		st = awaitCacheStats(t, s, func(st rescache.Stats) bool { return st.Unsubscribed == 1 })
+		if st.Size != size {
+			t.Fatalf("expected size %d to be kept until eviction, but got %d", size, st.Size)
+		}
+	})
+}
+
+// Test that change events update the tracked size
+func TestCacheStats_ChangeEvent_UpdatesSize(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		size := s.s.CacheStats().Size
+
+		s.ResourceEvent("test.model", "change", map[string]interface{}{"values": map[string]interface{}{"string": "foobar"}})
+		c.GetEvent(t).Equals(t, "test.model.change", map[string]interface{}{"values": map[string]interface{}{"string": "foobar"}})
+		if st := s.s.CacheStats(); st.Size != size+3 {
+			t.Fatalf("expected size %d, but got %d", size+3, st.Size)
+		}
+	})
+}
+
+// Test that unsubscribed resources are evicted when the cache size limit is
+// exceeded
+func TestCacheMaxSize_LimitExceeded_EvictsUnsubscribedResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		c.Request("unsubscribe.test.model", nil).GetResponse(t)
+
+		subscribeToTestCollection(t, s, c)
+		awaitCacheStats(t, s, func(st rescache.Stats) bool { return st.Resources == 1 && st.Unsubscribed == 0 })
+		s.NoSubscriptions(t, "test.model")
+
+		// Evicted resource is fetched again
+		subscribeToTestModel(t, s, c)
+	}, func(c *server.Config) {
+		c.CacheMaxSize = 1000
+	})
+}
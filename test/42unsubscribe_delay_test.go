@@ -0,0 +1,40 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// Test that the unsubscribe delay setting is used for unsubscribed resources
+func TestUnsubscribeDelay_ZeroDelay_UnsubscribesImmediately(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		c.Request("unsubscribe.test.model", nil).GetResponse(t)
+
+		awaitCacheStats(t, s, func(st rescache.Stats) bool { return st.Resources == 0 })
+		s.NoSubscriptions(t, "test.model")
+	}, func(c *server.Config) {
+		delay := 0
+		c.UnsubscribeDelay = &delay
+	})
+}
+
+// Test that unsubscribe delays are matched by resource pattern
+func TestUnsubscribeDelays_MatchingPattern_UsesPatternDelay(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		subscribeToTestCollection(t, s, c)
+		c.Request("unsubscribe.test.model", nil).GetResponse(t)
+		c.Request("unsubscribe.test.collection", nil).GetResponse(t)
+
+		// Only test.collection, using the default delay, remains
+		awaitCacheStats(t, s, func(st rescache.Stats) bool { return st.Resources == 1 })
+		s.NoSubscriptions(t, "test.model")
+	}, func(c *server.Config) {
+		c.UnsubscribeDelays = []server.UnsubscribeDelayConfig{{Pattern: "test.model", Delay: 0}}
+	})
+}
@@ -0,0 +1,43 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that a refresh interval in a get response makes the resource refresh,
+// sending change events for any difference
+func TestRefresh_GetResponseInterval_SendsChangeEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"},"refresh":50}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":{"foo":"bar"}}}`))
+
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"baz"},"refresh":50}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"foo":"baz"}}`))
+
+		// Refresh continues
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"baz"}}`))
+		c.AssertNoEvent(t, "test.model")
+	})
+}
+
+// Test that a configured refresh interval makes matching resources refresh,
+// sending add and remove events for any difference
+func TestRefresh_ConfiguredInterval_SendsCollectionEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		s.GetRequest(t).AssertSubject(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":["foo",42,true,"bar"]}`))
+		c.GetEvent(t).Equals(t, "test.collection.remove", json.RawMessage(`{"idx":3}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"value":"bar","idx":3}`))
+	}, func(c *server.Config) {
+		c.RefreshIntervals = []server.RefreshIntervalConfig{{Pattern: "test.collection", Interval: 50}}
+	})
+}
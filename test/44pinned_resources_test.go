@@ -0,0 +1,186 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// awaitPinned waits until the status of the first pinned resource satisfies
+// the condition, and returns the status.
+func awaitPinned(t *testing.T, s *Session, cond func(st rescache.PinStatus) bool) rescache.PinStatus {
+	deadline := time.Now().Add(timeoutSeconds * time.Second)
+	for {
+		st := s.s.PinnedResources()
+		if len(st) > 0 && cond(st[0]) {
+			return st[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected pinned resource status condition to be met, but got %+v", st)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Test that pinned resources are fetched on start, and served from the cache
+// on subscribe
+func TestPinnedResources_OnStart_FetchesResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+		awaitPinned(t, s, func(st rescache.PinStatus) bool { return st.Loaded })
+
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":{"foo":"bar"}}}`))
+	}, func(c *server.Config) {
+		c.PinnedResources = []string{"test.model"}
+	})
+}
+
+// Test that pinned resources failing to load are reported in the status
+func TestPinnedResources_LoadError_ReportsError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrNotFound)
+		st := awaitPinned(t, s, func(st rescache.PinStatus) bool { return st.Error != nil })
+		if st.Loaded || st.RID != "test.model" {
+			t.Fatalf("expected test.model not to be loaded, but got %+v", st)
+		}
+		s.AssertErrorsLogged(t, 1)
+	}, func(c *server.Config) {
+		c.PinnedResources = []string{"test.model"}
+	})
+}
+
+// Test that pinned resources failing to load are retried with backoff until
+// loaded
+func TestPinnedResources_LoadError_RetriesWithBackoff(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrNotFound)
+		awaitPinned(t, s, func(st rescache.PinStatus) bool { return st.Error != nil })
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrTimeout)
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+		st := awaitPinned(t, s, func(st rescache.PinStatus) bool { return st.Loaded })
+		if st.Error != nil {
+			t.Fatalf("expected no error, but got %+v", st)
+		}
+		s.AssertErrorsLogged(t, 1)
+	}, func(c *server.Config) {
+		c.PinnedResources = []string{"test.model"}
+		c.PinnedRetry = &server.PinnedRetryConfig{Backoff: 10, MaxBackoff: 20}
+	})
+}
+
+// Test that deleted pinned resources are retried and loaded again
+func TestPinnedResources_Deleted_RetriesUntilLoaded(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+		awaitPinned(t, s, func(st rescache.PinStatus) bool { return st.Loaded })
+
+		s.ResourceEvent("test.model", "delete", nil)
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"baz"}}`))
+		awaitPinned(t, s, func(st rescache.PinStatus) bool { return st.Loaded })
+		s.AssertErrorsLogged(t, 1)
+	}, func(c *server.Config) {
+		c.PinnedResources = []string{"test.model"}
+		c.PinnedRetry = &server.PinnedRetryConfig{Backoff: 10}
+	})
+}
+
+// Test that the status endpoint serves the load status of pinned resources
+func TestPinnedResources_StatusPath_ServesStatus(t *testing.T) {
+	statusPath := "/status"
+	runTest(t, func(s *Session) {
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+		mreqs.GetRequest(t, "get.test.missing").RespondError(reserr.ErrNotFound)
+		deadline := time.Now().Add(timeoutSeconds * time.Second)
+		for {
+			st := s.s.PinnedResources()
+			if st[0].Loaded && st[1].Error != nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected pinned resources to be loaded and failed, but got %+v", st)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		stats := s.s.CacheStats()
+		s.HTTPRequest("GET", "/status", nil).GetResponse(t).
+			AssertStatusCode(t, http.StatusOK).
+			AssertBody(t, map[string]interface{}{
+				"cache": map[string]interface{}{
+					"resources":    stats.Resources,
+					"unsubscribed": stats.Unsubscribed,
+					"size":         stats.Size,
+					"maxSize":      stats.MaxSize,
+					"resetErrors":  stats.ResetErrors,
+				},
+				"pinnedResources": json.RawMessage(`[
+					{"rid": "test.model", "loaded": true},
+					{"rid": "test.missing", "loaded": false, "error": {"code": "system.notFound", "message": "Not found"}}
+				]`),
+			})
+		s.HTTPRequest("POST", "/status", nil).GetResponse(t).
+			AssertError(t, reserr.ErrMethodNotAllowed)
+		s.AssertErrorsLogged(t, 1)
+	}, func(c *server.Config) {
+		c.PinnedResources = []string{"test.model", "test.missing"}
+		c.PinnedRetry = &server.PinnedRetryConfig{Backoff: 60000}
+		c.StatusPath = &statusPath
+	})
+}
+
+// Test that the status endpoint validates the request origin
+func TestPinnedResources_StatusPath_ValidatesOrigin(t *testing.T) {
+	statusPath := "/status"
+	allowOrigin := "http://localhost"
+	tbl := []struct {
+		Origin       string // Request's Origin header
+		ExpectedCode int    // Expected response status code
+	}{
+		{"http://localhost", http.StatusOK},
+		{"http://example.com", http.StatusForbidden},
+	}
+
+	for i, l := range tbl {
+		l := l
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			s.HTTPRequest("GET", "/status", nil, func(req *http.Request) {
+				req.Header.Set("Origin", l.Origin)
+			}).GetResponse(t).
+				AssertStatusCode(t, l.ExpectedCode).
+				AssertHeaders(t, map[string]string{"Access-Control-Allow-Origin": "http://localhost", "Vary": "Origin"})
+		}, func(c *server.Config) {
+			c.AllowOrigin = &allowOrigin
+			c.StatusPath = &statusPath
+		})
+	}
+}
+
+// Test that resources matching pinned patterns are kept in the cache, and
+// updated by events, after clients unsubscribe
+func TestPinnedResources_MatchingPattern_KeepsResourceAfterUnsubscribe(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		c.Request("unsubscribe.test.model", nil).GetResponse(t)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"foo"}}`))
+
+		creq := c.Request("subscribe.test.model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":{"string":"foo","int":42,"bool":true,"null":null}}}`))
+	}, func(c *server.Config) {
+		delay := 0
+		c.UnsubscribeDelay = &delay
+		c.PinnedResources = []string{"test.>"}
+	})
+}
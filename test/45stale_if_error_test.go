@@ -0,0 +1,91 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that a failed reset get request is retried while the stale resource is
+// kept, sending events once the retry succeeds
+func TestStaleIfError_ResetError_RetriesRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.SystemEvent("reset", json.RawMessage(`{"resources":["test.model"]}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrInternalError)
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"string":"bar","int":42,"bool":true,"null":null}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+
+		if st := s.s.CacheStats(); st.ResetErrors != 1 {
+			t.Fatalf("expected 1 reset error, but got %d", st.ResetErrors)
+		}
+		s.AssertErrorsLogged(t, 1)
+	}, func(c *server.Config) {
+		c.StaleIfError = &server.StaleIfErrorConfig{MaxStale: 60000, Backoff: 10}
+	})
+}
+
+// Test that clients are unsubscribed with the error once a resource has been
+// stale for longer than the staleness limit
+func TestStaleIfError_MaxStaleExceeded_UnsubscribesClient(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.SystemEvent("reset", json.RawMessage(`{"resources":["test.model"]}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrInternalError)
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrInternalError)
+		c.GetEvent(t).Equals(t, "test.model.unsubscribe", json.RawMessage(`{"reason":{"code":"system.internalError","message":"Internal error"}}`))
+		s.AssertErrorsLogged(t, 3)
+
+		// Resource is fetched again on subscribe
+		subscribeToTestModel(t, s, c)
+	}, func(c *server.Config) {
+		c.StaleIfError = &server.StaleIfErrorConfig{MaxStale: 5, Backoff: 10}
+	})
+}
+
+// Test that clients are sent a delete event for indirectly subscribed
+// resources once stale for longer than the staleness limit, and an
+// unsubscribe event for directly subscribed resources also referenced
+func TestStaleIfError_MaxStaleExceeded_DeletesIndirectSubscription(t *testing.T) {
+	for _, direct := range []bool{false, true} {
+		runNamedTest(t, fmt.Sprintf("direct: %v", direct), func(s *Session) {
+			c := s.Connect()
+			if direct {
+				subscribeToTestModel(t, s, c)
+			}
+			subscribeToTestModelParent(t, s, c, direct)
+
+			s.SystemEvent("reset", json.RawMessage(`{"resources":["test.model"]}`))
+			s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrInternalError)
+			s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrInternalError)
+			if direct {
+				c.GetEvent(t).Equals(t, "test.model.unsubscribe", json.RawMessage(`{"reason":{"code":"system.internalError","message":"Internal error"}}`))
+			}
+			c.GetEvent(t).Equals(t, "test.model.delete", nil)
+			c.AssertNoEvent(t, "test.model")
+			s.AssertErrorsLogged(t, 3)
+		}, func(c *server.Config) {
+			c.StaleIfError = &server.StaleIfErrorConfig{MaxStale: 5, Backoff: 10}
+		})
+	}
+}
+
+// Test that without staleIfError, a failed reset get request is not retried
+func TestStaleIfError_Disabled_KeepsResourceWithoutRetry(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.SystemEvent("reset", json.RawMessage(`{"resources":["test.model"]}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrInternalError)
+		c.AssertNoNATSRequest(t, "test.model")
+		s.AssertErrorsLogged(t, 1)
+	})
+}
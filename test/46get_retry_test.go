@@ -0,0 +1,81 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that get requests failing with a temporary error are retried, and the
+// subscriber gets the resource once loaded
+func TestGetRetry_TemporaryError_RetriesRequest(t *testing.T) {
+	for _, rerr := range []*reserr.Error{reserr.ErrTimeout, reserr.ErrInternalError} {
+		runNamedTest(t, rerr.Code, func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.model", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").RespondError(rerr)
+
+			s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":{"foo":"bar"}}}`))
+		}, func(c *server.Config) {
+			c.GetRetry = &server.GetRetryConfig{Retries: 1, Backoff: 10}
+		})
+	}
+}
+
+// Test that subscribers get the error once the get request retries are
+// exhausted
+func TestGetRetry_RetriesExhausted_RespondsWithError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondError(reserr.ErrTimeout)
+
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrTimeout)
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrTimeout)
+		creq.GetResponse(t).AssertError(t, reserr.ErrTimeout)
+	}, func(c *server.Config) {
+		c.GetRetry = &server.GetRetryConfig{Retries: 2, Backoff: 10}
+	})
+}
+
+// Test that get requests failing with a non-temporary error are not retried
+func TestGetRetry_NotFoundError_RespondsWithError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondError(reserr.ErrNotFound)
+
+		creq.GetResponse(t).AssertError(t, reserr.ErrNotFound)
+		c.AssertNoNATSRequest(t, "test.model")
+	}, func(c *server.Config) {
+		c.GetRetry = &server.GetRetryConfig{Retries: 1, Backoff: 10}
+	})
+}
+
+// Test that a system reset while a get request retry is scheduled sends the
+// request directly, and the subscriber gets the resource once loaded
+func TestGetRetry_SystemResetWhileRetrying_SendsRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondError(reserr.ErrTimeout)
+
+		s.SystemEvent("reset", json.RawMessage(`{"resources":["test.>"]}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":{"foo":"bar"}}}`))
+		c.AssertNoNATSRequest(t, "test.model")
+	}, func(c *server.Config) {
+		c.GetRetry = &server.GetRetryConfig{Retries: 3, Backoff: 60000, MaxBackoff: 60000}
+	})
+}
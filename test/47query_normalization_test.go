@@ -0,0 +1,72 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that equivalent queries after normalization share the cache entry
+func TestQueryNormalization_EquivalentQueries_ShareCacheEntry(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+
+		creq := c.Request("subscribe.test.model?b=2&A=1&c=", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").
+			AssertPathPayload(t, "query", "a=1&b=2").
+			RespondSuccess(json.RawMessage(`{"model":` + model + `,"query":"a=1&b=2"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?b=2&A=1&c=":`+model+`}}`))
+
+		// Equivalent query is served from the cache
+		creq = c.Request("subscribe.test.model?a=1&b=2", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?a=1&b=2":`+model+`}}`))
+	}, func(c *server.Config) {
+		c.QueryNormalizations = []server.QueryNormalizationConfig{{Pattern: "test.>", SortParams: true, RemoveEmpty: true, LowercaseKeys: true}}
+	})
+}
+
+// Test that normalized queries returned by the service are remembered,
+// linking equivalent queries without sending a get request
+func TestQueryNormalization_ServiceNormalizedQuery_IsRemembered(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+
+		// Subscribe to the normalized query
+		creq := c.Request("subscribe.test.model?q=foo", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `,"query":"q=foo"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?q=foo":`+model+`}}`))
+
+		// Subscribe to a variant, learning its normalized query
+		creq = c.Request("subscribe.test.model?q=FOO", nil)
+		mreqs = s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").
+			AssertPathPayload(t, "query", "q=FOO").
+			RespondSuccess(json.RawMessage(`{"model":` + model + `,"query":"q=foo"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?q=FOO":`+model+`}}`))
+		c.Request("unsubscribe.test.model?q=FOO", nil).GetResponse(t)
+		c.Request("unsubscribe.test.model?q=foo", nil).GetResponse(t)
+
+		// Subscribe to the normalized query again, after its link is removed
+		creq = c.Request("subscribe.test.model?q=foo", nil)
+		mreqs = s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `,"query":"q=foo"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?q=foo":`+model+`}}`))
+
+		// Subscribe to the variant, linked without a get request
+		creq = c.Request("subscribe.test.model?q=FOO", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?q=FOO":`+model+`}}`))
+	}, func(c *server.Config) {
+		c.QueryNormalizations = []server.QueryNormalizationConfig{{Pattern: "test.>"}}
+	})
+}
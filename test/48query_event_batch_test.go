@@ -0,0 +1,72 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that a query event with the batch flag sends a single batch query
+// request for all queries, applying the events for each query
+func TestQueryEventBatch_MultipleQueries_SendsSingleRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestQueryModel(t, s, c, "q=foo&f=bar", "q=foo&f=bar")
+		subscribeToTestQueryModel(t, s, c, "q=foo&f=baz", "q=foo&f=baz")
+
+		s.ResourceEvent("test.model", "query", json.RawMessage(`{"subject":"_EVENT_01_","batch":true}`))
+		req := s.GetRequest(t).AssertSubject(t, "_EVENT_01_")
+		queries := req.PathPayload(t, "queries").([]interface{})
+		if len(queries) != 2 || queries[0] == queries[1] {
+			t.Fatalf("expected queries q=foo&f=bar and q=foo&f=baz, but got %#v", queries)
+		}
+		for _, q := range queries {
+			if q != "q=foo&f=bar" && q != "q=foo&f=baz" {
+				t.Fatalf("expected queries q=foo&f=bar and q=foo&f=baz, but got %#v", queries)
+			}
+		}
+		req.RespondSuccess(json.RawMessage(`{"queries":{"q=foo&f=baz":{"events":[{"event":"change","data":{"values":{"string":"bar"}}}]}}}`))
+
+		c.GetEvent(t).Equals(t, "test.model?q=foo&f=baz.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.AssertNoEvent(t, "test.model")
+	})
+}
+
+// Test that a system.notFound error for a query in a batch query response
+// generates a delete event for the query resource
+func TestQueryEventBatch_NotFoundError_SendsDeleteEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestQueryModel(t, s, c, "q=foo&f=bar", "q=foo&f=bar")
+
+		s.ResourceEvent("test.model", "query", json.RawMessage(`{"subject":"_EVENT_01_","batch":true}`))
+		s.GetRequest(t).
+			Equals(t, "_EVENT_01_", json.RawMessage(`{"queries":["q=foo&f=bar"]}`)).
+			RespondSuccess(json.RawMessage(`{"queries":{"q=foo&f=bar":{"error":{"code":"system.notFound","message":"Not found"}}}}`))
+
+		c.GetEvent(t).Equals(t, "test.model?q=foo&f=bar.delete", nil)
+	})
+}
+
+// Test that subscribing to a new query variant beyond the cacheMaxQueries
+// limit responds with an error
+func TestCacheMaxQueries_LimitReached_RespondsWithError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestQueryModel(t, s, c, "q=foo&f=bar", "q=foo&f=bar")
+
+		creq := c.Request("subscribe.test.model?q=foo&f=baz", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertError(t, reserr.ErrTooManyQueries)
+
+		// Cached query variants may still be subscribed to on another connection
+		c2 := s.Connect()
+		creq = c2.Request("subscribe.test.model?q=foo&f=bar", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?q=foo&f=bar":{"string":"foo","int":42,"bool":true,"null":null}}}`))
+	}, func(c *server.Config) {
+		c.CacheMaxQueries = 1
+	})
+}
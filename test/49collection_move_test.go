@@ -0,0 +1,144 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// Test that a collection move event is sent to the client and updates the cache
+func TestCollectionMoveEvent(t *testing.T) {
+	tbl := []struct {
+		EventPayload string // Move event payload (raw JSON)
+		Expected     string // Expected collection after the move (raw JSON)
+	}{
+		{`{"from":0,"to":3}`, `[42,true,null,"foo"]`},
+		{`{"from":3,"to":0}`, `[null,"foo",42,true]`},
+		{`{"from":1,"to":2}`, `["foo",true,42,null]`},
+		{`{"from":2,"to":1}`, `["foo",true,42,null]`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			subscribeToTestCollection(t, s, c)
+
+			s.ResourceEvent("test.collection", "move", json.RawMessage(l.EventPayload))
+			c.GetEvent(t).Equals(t, "test.collection.move", json.RawMessage(l.EventPayload))
+
+			// Validate the cached collection
+			c2 := s.Connect()
+			creq := c2.Request("subscribe.test.collection", nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"test.collection":`+l.Expected+`}}`))
+		})
+	}
+}
+
+// Test that a collection move event with indexes out of bounds is discarded
+func TestCollectionMoveEvent_OutOfBounds_IsDiscarded(t *testing.T) {
+	tbl := []string{
+		`{"from":-1,"to":0}`,
+		`{"from":4,"to":0}`,
+		`{"from":0,"to":-1}`,
+		`{"from":0,"to":4}`,
+	}
+
+	for i, payload := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			subscribeToTestCollection(t, s, c)
+
+			s.ResourceEvent("test.collection", "move", json.RawMessage(payload))
+			c.AssertNoEvent(t, "test.collection")
+			s.AssertErrorsLogged(t, 1)
+		})
+	}
+}
+
+// Test that moving a resource reference keeps the referenced resource subscribed
+func TestCollectionMoveEvent_WithReference_KeepsReferenceSubscribed(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollectionParent(t, s, c, false)
+
+		s.ResourceEvent("test.collection.parent", "move", json.RawMessage(`{"from":1,"to":0}`))
+		c.GetEvent(t).Equals(t, "test.collection.parent.move", json.RawMessage(`{"from":1,"to":0}`))
+
+		// Validate the reference is still subscribed by the client
+		s.ResourceEvent("test.collection", "custom", common.CustomEvent())
+		c.GetEvent(t).Equals(t, "test.collection.custom", common.CustomEvent())
+	})
+}
+
+// Test that a collection move event is sent as a remove and an add event to
+// clients with a protocol version not supporting move events
+func TestCollectionMoveEvent_LegacyClient_SendsRemoveAndAddEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithVersion("1.2.1")
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.collection", "move", json.RawMessage(`{"from":0,"to":2}`))
+		c.GetEvent(t).Equals(t, "test.collection.remove", json.RawMessage(`{"idx":0}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":2,"value":"foo"}`))
+	})
+}
+
+// Test that move events are translated into events relative to the window
+func TestCollectionWindowMoveEvents(t *testing.T) {
+	tbl := []struct {
+		Params       string   // Subscribe request params (raw JSON)
+		EventPayload string   // Move event payload (raw JSON)
+		Expected     []string // Expected client events as name and payload pairs
+	}{
+		{`{"offset":1,"limit":2}`, `{"from":1,"to":2}`, []string{"move", `{"from":0,"to":1}`}},
+		{`{"offset":1,"limit":2}`, `{"from":0,"to":3}`, []string{"remove", `{"idx":0}`, "add", `{"idx":1,"value":null}`}},
+		{`{"offset":1,"limit":2}`, `{"from":3,"to":0}`, []string{"add", `{"idx":0,"value":"foo"}`, "remove", `{"idx":2}`}},
+		{`{"offset":2,"limit":10}`, `{"from":0,"to":1}`, nil},
+		{`{"offset":0,"limit":2}`, `{"from":2,"to":3}`, nil},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.collection", json.RawMessage(l.Params))
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+			creq.GetResponse(t)
+
+			s.ResourceEvent("test.collection", "move", json.RawMessage(l.EventPayload))
+			for j := 0; j < len(l.Expected); j += 2 {
+				c.GetEvent(t).Equals(t, "test.collection."+l.Expected[j], json.RawMessage(l.Expected[j+1]))
+			}
+			c.AssertNoEvent(t, "test.collection")
+		})
+	}
+}
+
+// Test that a system reset with a reordered collection sends a move event
+func TestCollectionMoveEvent_ResetReorderedCollection_SendsMoveEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		s.SystemEvent("reset", json.RawMessage(`{"resources":["test.collection"]}`))
+		s.GetRequest(t).
+			AssertSubject(t, "get.test.collection").
+			RespondSuccess(json.RawMessage(`{"collection":[42,true,"foo",null]}`))
+		c.GetEvent(t).Equals(t, "test.collection.move", json.RawMessage(`{"from":0,"to":2}`))
+		c.AssertNoEvent(t, "test.collection")
+	})
+}
+
+// Test that a move event on a model is sent to the client as a custom event
+func TestModelMoveEvent_SentAsCustomEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "move", common.CustomEvent())
+		c.GetEvent(t).Equals(t, "test.model.move", common.CustomEvent())
+		s.AssertErrorsLogged(t, 0)
+	})
+}
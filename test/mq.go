@@ -0,0 +1,187 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/resgateio/resgate/grpc"
+	"github.com/resgateio/resgate/inproc"
+	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/mqrouter"
+	"github.com/resgateio/resgate/nats"
+	"github.com/resgateio/resgate/server/mq"
+	grpcgo "google.golang.org/grpc"
+)
+
+// syncSubject is a request subject responded to by the transport services
+// directly, without passing it to the NATSTestClient.
+const syncSubject = "call.mqtransport.sync"
+
+// grpcToken is the shared secret used by the gRPC transport service.
+var grpcToken = "secret"
+
+// mqTransport connects the server to the NATSTestClient over a messaging
+// transport, with the NATSTestClient acting as the service.
+//
+// Requests are passed to the NATSTestClient, and events sent by it are
+// published over the transport. Errors sent on requests are not passed on,
+// and the request is left to time out.
+type mqTransport struct {
+	name string
+	// client returns the mq client used by the server.
+	client func(c *NATSTestClient, l logger.Logger) mq.Client
+	// serve connects the NATSTestClient as a service to the connected mq
+	// client, and returns a function to disconnect it, or nil if the
+	// NATSTestClient is connected by the mq client.
+	serve func(t *testing.T, mc mq.Client, c *NATSTestClient) func()
+}
+
+// mqTransports are the transports used by runMQTest.
+var mqTransports = []*mqTransport{
+	{
+		name: "nats",
+		client: func(c *NATSTestClient, l logger.Logger) mq.Client {
+			return &nats.Client{
+				RequestTimeout: timeoutSeconds * time.Second,
+				Server:         &nats.ServerConfig{Host: "127.0.0.1", Port: -1},
+				Logger:         l,
+			}
+		},
+		serve: func(t *testing.T, mc mq.Client, c *NATSTestClient) func() {
+			c.Connect()
+			nc, err := natsgo.Connect(mc.(*nats.Client).ServerURL())
+			if err != nil {
+				t.Fatalf("error connecting service: %s", err)
+			}
+			nc.Subscribe(">", func(m *natsgo.Msg) {
+				if m.Reply != "" {
+					c.serveRequest(m.Subject, m.Data, func(data []byte) { m.Respond(data) })
+				}
+			})
+			nc.Flush()
+			c.publish = func(subj string, payload []byte) {
+				// Ensure the server's subscriptions are registered
+				// with the NATS server prior to publishing.
+				if err := syncMQ(mc); err != nil {
+					panic("test: " + err.Error())
+				}
+				nc.Publish(subj, payload)
+			}
+			return nc.Close
+		},
+	},
+	{
+		name: "grpc",
+		client: func(c *NATSTestClient, l logger.Logger) mq.Client {
+			return &grpc.Client{
+				Addr:           "127.0.0.1:0",
+				RequestTimeout: timeoutSeconds * time.Second,
+				Token:          &grpcToken,
+				Logger:         l,
+			}
+		},
+		serve: func(t *testing.T, mc mq.Client, c *NATSTestClient) func() {
+			c.Connect()
+			cc, err := grpcgo.Dial(mc.(*grpc.Client).ListenAddr().String(), grpcgo.WithInsecure())
+			if err != nil {
+				t.Fatalf("error dialing: %s", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			svc, err := grpc.ConnectService(ctx, cc, grpcToken, ">")
+			if err != nil {
+				t.Fatalf("error connecting service: %s", err)
+			}
+			go func() {
+				for {
+					r, err := svc.Recv()
+					if err != nil {
+						return
+					}
+					c.serveRequest(r.Subject, r.Payload, func(data []byte) { svc.Respond(r.ID, data) })
+				}
+			}()
+			c.publish = func(subj string, payload []byte) {
+				svc.Publish(subj, payload)
+			}
+			// Await the asynchronous service registration
+			if err := syncMQ(mc); err != nil {
+				t.Fatal(err)
+			}
+			return func() {
+				cancel()
+				cc.Close()
+			}
+		},
+	},
+	{
+		name: "inproc",
+		client: func(c *NATSTestClient, l logger.Logger) mq.Client {
+			return &inproc.Client{RequestTimeout: timeoutSeconds * time.Second, Logger: l}
+		},
+		serve: func(t *testing.T, mc mq.Client, c *NATSTestClient) func() {
+			c.Connect()
+			ic := mc.(*inproc.Client)
+			sub, err := ic.Handle(">", func(r *inproc.Request) {
+				if r.IsRequest() {
+					c.serveRequest(r.Subject, r.Payload, func(data []byte) { r.Respond(data) })
+				}
+			})
+			if err != nil {
+				t.Fatalf("error handling requests: %s", err)
+			}
+			c.publish = func(subj string, payload []byte) {
+				ic.Publish(subj, payload)
+			}
+			return func() { sub.Unsubscribe() }
+		},
+	},
+	{
+		name: "mqrouter",
+		client: func(c *NATSTestClient, l logger.Logger) mq.Client {
+			return &mqrouter.Client{
+				Default: c,
+				Routes:  []mqrouter.Route{{Patterns: []string{"test.>"}, Client: c}},
+			}
+		},
+		serve: func(t *testing.T, mc mq.Client, c *NATSTestClient) func() {
+			return nil
+		},
+	},
+}
+
+// serveRequest passes a request received over a transport to the
+// NATSTestClient, calling respond with the response.
+func (c *NATSTestClient) serveRequest(subj string, payload []byte, respond func(data []byte)) {
+	if subj == syncSubject {
+		respond([]byte(`{"result":null}`))
+		return
+	}
+	c.SendRequest(subj, payload, func(_ string, data []byte, err error) {
+		if err == nil {
+			respond(data)
+		}
+	})
+}
+
+// syncMQ sends a sync request using the mq client, retrying until it is
+// responded to by the transport service, or until timeout.
+func syncMQ(mc mq.Client) error {
+	deadline := time.Now().Add(timeoutSeconds * time.Second)
+	for {
+		ch := make(chan error, 1)
+		mc.SendRequest(syncSubject, []byte(`{}`), func(_ string, _ []byte, err error) {
+			ch <- err
+		})
+		err := <-ch
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("error syncing transport: " + err.Error())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -40,6 +40,9 @@ type NATSTestClient struct {
 	reqs      chan *Request
 	connected bool
 	mu        sync.Mutex
+	// publish sends events over a transport, when serving a gateway
+	// connected over an mqTransport.
+	publish func(subj string, payload []byte)
 }
 
 // ParallelRequests holds multiple requests in undetermined order
@@ -233,7 +236,7 @@ func (c *NATSTestClient) event(ns string, event string, payload interface{}) {
 	c.mu.Lock()
 
 	s, ok := c.subs[ns]
-	if !ok {
+	if !ok && c.publish == nil {
 		c.mu.Unlock()
 		panic("test: no subscription for " + ns)
 	}
@@ -251,6 +254,10 @@ func (c *NATSTestClient) event(ns string, event string, payload interface{}) {
 	c.mu.Unlock()
 	subj := ns + "." + event
 	c.Tracef("=>> %s: %s", subj, data)
+	if c.publish != nil {
+		c.publish(subj, data)
+		return
+	}
 	s.cb(subj, data, nil)
 }
 
@@ -11,6 +11,7 @@ import (
 
 	"github.com/posener/wstest"
 	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/mq"
 )
 
 const timeoutSeconds = 1
@@ -28,13 +29,24 @@ type Session struct {
 	s     *server.Service
 	conns map[*Conn]struct{}
 	*CountLogger
+	stopMQ func()
 }
 
 func setup(t *testing.T, cfgs ...func(*server.Config)) *Session {
+	return setupMQ(t, nil, cfgs...)
+}
+
+// setupMQ sets up a test session. If mqt is not nil, the server is connected
+// to the NATSTestClient over the transport.
+func setupMQ(t *testing.T, mqt *mqTransport, cfgs ...func(*server.Config)) *Session {
 	l := NewCountLogger(true, true)
 
 	c := NewNATSTestClient(l)
-	serv, err := server.NewService(c, DefaultConfig(cfgs...))
+	var mc mq.Client = c
+	if mqt != nil {
+		mc = mqt.client(c, l)
+	}
+	serv, err := server.NewService(mc, DefaultConfig(cfgs...))
 	if err != nil {
 		t.Fatalf("error creating new service: %s", err)
 	}
@@ -52,6 +64,10 @@ func setup(t *testing.T, cfgs ...func(*server.Config)) *Session {
 		panic("test: failed to start server: " + err.Error())
 	}
 
+	if mqt != nil {
+		s.stopMQ = mqt.serve(t, mc, c)
+	}
+
 	return s
 }
 
@@ -154,6 +170,9 @@ func teardown(s *Session) {
 			conn.AssertClosed(s.t)
 		}
 	}
+	if s.stopMQ != nil {
+		s.stopMQ()
+	}
 	st := s.s.StopChannel()
 	go s.s.Stop(nil)
 
@@ -183,6 +202,18 @@ func runTest(t *testing.T, cb func(*Session), cfgs ...func(*server.Config)) {
 }
 
 func runNamedTest(t *testing.T, name string, cb func(*Session), cfgs ...func(*server.Config)) {
+	runNamedMQTest(t, name, nil, cb, cfgs...)
+}
+
+// runMQTest runs the test once for each of the mqTransports, with the server
+// connected to the NATSTestClient over the transport.
+func runMQTest(t *testing.T, cb func(*Session), cfgs ...func(*server.Config)) {
+	for _, mqt := range mqTransports {
+		runNamedMQTest(t, mqt.name, mqt, cb, cfgs...)
+	}
+}
+
+func runNamedMQTest(t *testing.T, name string, mqt *mqTransport, cb func(*Session), cfgs ...func(*server.Config)) {
 	var s *Session
 	panicked := true
 	defer func() {
@@ -194,7 +225,7 @@ func runNamedTest(t *testing.T, name string, cb func(*Session), cfgs ...func(*se
 		}
 	}()
 
-	s = setup(t, cfgs...)
+	s = setupMQ(t, mqt, cfgs...)
 	cb(s)
 	teardown(s)
 